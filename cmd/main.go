@@ -1,30 +1,101 @@
 package main
 
 import (
+	"context"
 	"forum/internal/handler"
 	"forum/internal/models"
 	svr "forum/internal/server"
 	"forum/internal/service"
 	"forum/internal/storage"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	if err := run(); err != nil {
+		models.ErrLog.Println(err)
+		os.Exit(1)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, then lets the requests in flight and
+// the background work finish within the shutdown timeout.
+func run() error {
 	config, err := svr.NewConfig()
 	if err != nil {
 		models.ErrLog.Println(err)
 	}
 
-	storage.InitRedis(config)
+	if err := storage.InitRedis(config); err != nil {
+		models.ErrLog.Println(err)
+	}
 	defer storage.RDB.Close()
 
 	storage.InitWebAuthn()
 
-	db := storage.InitDB(config)
+	db, err := storage.InitDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	store := storage.NewStorage(db)
-	services := service.NewService(store)
+	services := service.NewService(store, config)
+	if err := services.BackfillReputation(); err != nil {
+		models.ErrLog.Println(err)
+	}
+	var background sync.WaitGroup
+	for _, work := range []func(){
+		func() { services.RunDigests(ctx, 15*time.Minute) },
+		func() { services.RunBadges(ctx, 6*time.Hour) },
+		func() { services.RunScheduler(ctx, time.Minute) },
+	} {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			work()
+		}()
+	}
+
 	handlers := handler.NewHandler(services, config)
 	server := new(svr.Server)
-	if err := server.Run(config.Port, handlers.InitRoutes()); err != nil {
-		models.ErrLog.Printf("Error running server: %s\n", err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Run(config, handlers.InitRoutes())
+	}()
+
+	select {
+	case err = <-served:
+		if err != nil {
+			models.ErrLog.Printf("Error running server: %s\n", err)
+		}
+	case <-ctx.Done():
+		models.InfoLog.Println("Shutting down")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownGrace())
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		models.ErrLog.Println("shutdown:", err)
+	}
+	drained := make(chan struct{})
+	go func() {
+		services.StopJobs()
+		background.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		models.InfoLog.Println("Background work finished")
+	case <-shutdownCtx.Done():
+		models.ErrLog.Println("shutdown: background work did not finish in time")
 	}
+	return err
 }
@@ -0,0 +1,16 @@
+// Buttons and forms with a data-confirm message ask before they submit.
+// Inline onclick handlers would do the same, but the Content-Security-Policy
+// does not run them.
+document.addEventListener('click', function(event) {
+  var button = event.target.closest('button[data-confirm]');
+  if (button && !confirm(button.dataset.confirm)) {
+    event.preventDefault();
+  }
+});
+
+document.addEventListener('submit', function(event) {
+  var form = event.target;
+  if (!event.defaultPrevented && form.dataset.confirm && !confirm(form.dataset.confirm)) {
+    event.preventDefault();
+  }
+});
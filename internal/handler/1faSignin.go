@@ -39,14 +39,10 @@ func (h *Handler) verifyPrimaryAuth(r *http.Request, username, password string)
 }
 
 func (h *Handler) renderSignInFormError(w http.ResponseWriter, message, username string) {
-	info := models.InfoSign{
+	h.renderSignIn(w, models.InfoSign{
 		Error:    message,
 		Username: username,
-	}
-
-	if err := h.Temp.ExecuteTemplate(w, "signin.html", info); err != nil {
-		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-	}
+	})
 }
 
 var (
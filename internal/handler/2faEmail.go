@@ -1,19 +1,26 @@
 package handler
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"forum/internal/models"
+	"forum/internal/service"
 	"log"
-	"math/rand"
+	"math/big"
 	"net/http"
-	"net/smtp"
 	"time"
 )
 
+const pendingEmailCookie = "pending_user"
+
 func generateEmailCode() string {
-	return fmt.Sprintf("%06d", rand.Intn(1000000))
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
 }
 
 func hashCode(code string) string {
@@ -21,39 +28,6 @@ func hashCode(code string) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func sendEmailCode(to, code string, smtpConfig struct {
-	From     string
-	Password string
-	Host     string
-	Port     string
-}) error {
-	if smtpConfig.From == "" || smtpConfig.Password == "" || smtpConfig.Host == "" || smtpConfig.Port == "" {
-		return fmt.Errorf("smtp config is not fully specified")
-	}
-	from := smtpConfig.From
-	password := smtpConfig.Password
-	host := smtpConfig.Host
-	addr := host + ":" + smtpConfig.Port
-
-	auth := smtp.PlainAuth("", from, password, host)
-
-	msg := []byte(fmt.Sprintf(
-		"Subject: Login confirmation\r\n"+
-			"MIME-Version: 1.0\r\n"+
-			"Content-Type: text/plain; charset=\"UTF-8\"\r\n\r\n"+
-			"Your code: %s\nExpires in 5 minutes",
-		code,
-	))
-
-	return smtp.SendMail(
-		addr,
-		auth,
-		from,
-		[]string{to},
-		msg,
-	)
-}
-
 func (h *Handler) startSecondFactor(w http.ResponseWriter, username string) error {
 	code := generateEmailCode()
 	email, err := h.Service.SaveEmailCode(username, hashCode(code), time.Now().Add(5*time.Minute))
@@ -61,14 +35,17 @@ func (h *Handler) startSecondFactor(w http.ResponseWriter, username string) erro
 		return err
 	}
 
-	if err := sendEmailCode(email, code, h.Config.SMTP); err != nil {
+	if err := h.Service.SendTemplate(email, "code", map[string]string{"Code": code}); err != nil {
 		return err
 	}
 
+	// signed like the authenticator app step, so the cookie cannot be
+	// pointed at another user
 	http.SetCookie(w, &http.Cookie{
-		Name:     "pending_user",
-		Value:    username,
+		Name:     pendingEmailCookie,
+		Value:    h.Service.SignPendingLogin(username, "email"),
 		Path:     "/verify",
+		MaxAge:   300,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
@@ -81,9 +58,16 @@ func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		code := r.FormValue("code")
-		log.Println(code)
-		c, _ := r.Cookie("pending_user")
-		username := c.Value
+		c, err := r.Cookie(pendingEmailCookie)
+		if err != nil {
+			h.renderSignIn(w, models.InfoSign{Error: service.ErrPendingLogin.Error()})
+			return
+		}
+		username, err := h.Service.ParsePendingLogin(c.Value, "email")
+		if err != nil {
+			h.renderSignIn(w, models.InfoSign{Error: err.Error()})
+			return
+		}
 		user, err := h.Service.Auth.GetUserByUsername(username)
 		if err != nil {
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -95,8 +79,9 @@ func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
 				IP:     clientIP(r.RemoteAddr),
 				Device: getDevice(r),
 				Status: false,
-				Reason: "fail auth by Invalid code"})
-			h.Temp.ExecuteTemplate(w, "verify.html", "Invalid code")
+				Reason: "fail auth by Invalid code",
+				Method: "email code"})
+			h.render(w, "verify.html", "Invalid code")
 			return
 		}
 		if err != nil {
@@ -111,14 +96,16 @@ func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
 			h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
+		http.SetCookie(w, &http.Cookie{Name: pendingEmailCookie, Value: "", Path: "/verify", MaxAge: -1, HttpOnly: true, Secure: true})
 		h.SetCookieAndSuccess(w, r, token, expired)
 		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
 			IP:     clientIP(r.RemoteAddr),
 			Device: getDevice(r),
 			Status: true,
-			Reason: "success login by 2 steps auth"})
+			Reason: "success login by 2 steps auth",
+			Method: "email code"})
 	case http.MethodGet:
-		h.Temp.ExecuteTemplate(w, "verify.html", nil)
+		h.render(w, "verify.html", nil)
 		return
 	}
 }
@@ -131,6 +118,7 @@ func (h *Handler) SetCookieAndSuccess(w http.ResponseWriter, r *http.Request, to
 		Expires:  expired,
 		HttpOnly: true,
 		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
 	})
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/service"
+	"forum/internal/storage"
+	"log"
+	"net/http"
+	"time"
+)
+
+const pendingTOTPCookie = "pending_totp"
+
+// startTOTPFactor remembers, in a signed cookie, that the user passed the
+// password step and now owes a code from the authenticator app.
+func (h *Handler) startTOTPFactor(w http.ResponseWriter, username string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingTOTPCookie,
+		Value:    h.Service.SignPendingLogin(username, "totp"),
+		Path:     "/verify",
+		MaxAge:   300,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// verifyTOTP is the second step with an authenticator app. A recovery code
+// is accepted in the same field.
+func (h *Handler) verifyTOTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.render(w, "verifyTotp.html", nil)
+	case http.MethodPost:
+		c, err := r.Cookie(pendingTOTPCookie)
+		if err != nil {
+			h.renderSignIn(w, models.InfoSign{Error: service.ErrPendingLogin.Error()})
+			return
+		}
+		username, err := h.Service.ParsePendingLogin(c.Value, "totp")
+		if err != nil {
+			h.renderSignIn(w, models.InfoSign{Error: err.Error()})
+			return
+		}
+		allowed, err := h.CheckAtomic(storage.RDB, []Rule{{
+			Key:    "rate:user:totp:" + username,
+			Limit:  5,
+			Window: 10 * time.Minute,
+		}})
+		if err != nil {
+			h.ErrorPage(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !allowed {
+			h.ErrorPage(w, "Too many attempts", http.StatusTooManyRequests)
+			return
+		}
+		user, err := h.Service.Auth.GetUserByUsername(username)
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		recovery, err := h.Service.VerifyTOTP(user.Id, r.FormValue("code"))
+		if errors.Is(err, service.ErrInvalidTOTP) {
+			h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+				IP:     clientIP(r.RemoteAddr),
+				Device: getDevice(r),
+				Status: false,
+				Reason: "fail auth by Invalid authenticator code",
+				Method: "authenticator app"})
+			h.render(w, "verifyTotp.html", "Invalid code")
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		token, expired, err := h.Service.Auth.GetTokenByUsername(username)
+		if err != nil {
+			log.Println(err)
+			h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: pendingTOTPCookie, Value: "", Path: "/verify", MaxAge: -1, HttpOnly: true, Secure: true})
+		reason, method := "success login by authenticator app", "authenticator app"
+		if recovery {
+			reason, method = "success login by recovery code", "recovery code"
+		}
+		h.SetCookieAndSuccess(w, r, token, expired)
+		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+			IP:     clientIP(r.RemoteAddr),
+			Device: getDevice(r),
+			Status: true,
+			Reason: reason,
+			Method: method})
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -10,8 +10,8 @@ func (h *Handler) info(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
-	if err := h.Temp.ExecuteTemplate(w, "about.html", nil); err != nil {
-		models.ErrLog.Println("h.Temp.ExecuteTemplate")
+	if err := h.render(w, "about.html", nil); err != nil {
+		models.ErrLog.Println("h.render")
 		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// forgotPassword mails a reset link. The answer is the same whether or not
+// the email has an account.
+func (h *Handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.render(w, "forgot.html", map[string]any{})
+	case http.MethodPost:
+		email := strings.TrimSpace(r.FormValue("email"))
+		allowed, err := h.CheckAtomic(storage.RDB, []Rule{
+			{
+				Key:    "rate:ip:forgot:" + clientIP(r.RemoteAddr),
+				Limit:  5,
+				Window: 15 * time.Minute,
+			},
+			{
+				Key:    "rate:email:forgot:" + strings.ToLower(email),
+				Limit:  3,
+				Window: time.Hour,
+			},
+		})
+		if err != nil {
+			h.ErrorPage(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !allowed {
+			h.ErrorPage(w, "Too many attempts", http.StatusTooManyRequests)
+			return
+		}
+		if err := h.Service.RequestPasswordReset(email); err != nil {
+			models.ErrLog.Println(err)
+			h.render(w, "forgot.html", map[string]any{"Email": email, "Error": "The mail could not be sent, try again later"})
+			return
+		}
+		h.render(w, "forgot.html", map[string]any{"Sent": true})
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if err := h.Service.CheckResetToken(token); err != nil {
+			h.renderReset(w, "", err)
+			return
+		}
+		h.renderReset(w, token, nil)
+	case http.MethodPost:
+		token := r.FormValue("token")
+		err := h.Service.ResetPassword(token, r.FormValue("password"), r.FormValue("password1"))
+		switch {
+		case errors.Is(err, storage.ErrTokenInvalid):
+			h.renderReset(w, "", err)
+		case errors.Is(err, models.ErrShortPassword), errors.Is(err, models.ErrPasswordDoesNotMatch):
+			h.renderReset(w, token, err)
+		case err != nil:
+			models.ErrLog.Println(err)
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		default:
+			h.renderSignIn(w, models.InfoSign{Error: "Your password is changed, sign in with the new one"})
+		}
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) renderReset(w http.ResponseWriter, token string, err error) {
+	data := map[string]any{"Token": token}
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	if err := h.render(w, "reset.html", data); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// confirmEmail is where the link of the verification mail points to.
+func (h *Handler) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	userID, err := h.Service.VerifyEmail(r.URL.Query().Get("token"))
+	if err != nil && !errors.Is(err, storage.ErrTokenInvalid) {
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	message := "Your email is confirmed"
+	if err != nil {
+		message = err.Error()
+	} else if userID == user.Id {
+		user.EmailVerified = true
+	}
+	if !user.IsAuth {
+		h.renderSignIn(w, models.InfoSign{Error: message})
+		return
+	}
+	h.renderSettings(w, user, message)
+}
+
+// resendVerification sends a new verification mail, a few times an hour at
+// most.
+func (h *Handler) resendVerification(w http.ResponseWriter, r *http.Request, user models.User) {
+	if user.EmailVerified {
+		h.renderSettings(w, user, "Your email is already confirmed")
+		return
+	}
+	allowed, err := h.CheckAtomic(storage.RDB, []Rule{{
+		Key:    "rate:user:verify-email:" + user.Username,
+		Limit:  3,
+		Window: time.Hour,
+	}})
+	if err != nil {
+		h.ErrorPage(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !allowed {
+		h.renderSettings(w, user, "Too many mails sent, try again in an hour")
+		return
+	}
+	if err := h.Service.SendVerificationEmail(user); err != nil {
+		models.ErrLog.Println(err)
+		h.renderSettings(w, user, "The mail could not be sent, try again later")
+		return
+	}
+	h.renderSettings(w, user, "A new confirmation link was sent to "+user.Email)
+}
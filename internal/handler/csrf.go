@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookie    = "csrf_token"
+	csrfFieldName = "csrf_token"
+	// csrfPeek is how much of a multipart body is read to find the token.
+	// Forms send it as their first field.
+	csrfPeek = 8 << 10
+	errCSRF  = "The form has expired, reload the page and try again"
+)
+
+// csrfPlaceholder stands for the token in executed templates until render
+// puts the token of the request in. It is random, so no text a user wrote
+// can ask for somebody's token.
+var csrfPlaceholder = randomToken()
+
+// csrfExempt are routes posted to from outside the forum's pages, with
+// their own proof: mail clients send the RFC 8058 one click unsubscribe,
+// which carries the unsubscribe token.
+var csrfExempt = map[string]bool{
+	"/unsubscribe": true,
+}
+
+// randomToken is 32 random bytes, base64url encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func wellFormedCSRF(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == 32
+}
+
+// csrfField is the hidden input every POST form starts with.
+func csrfField() template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + csrfPlaceholder + `">`)
+}
+
+// csrfToken is the token alone, for links that change something.
+func csrfToken() string {
+	return csrfPlaceholder
+}
+
+// csrfWriter carries the token of the request to render. fresh is set when
+// the browser has no token yet, so the first page rendered sets the cookie.
+type csrfWriter struct {
+	http.ResponseWriter
+	token string
+	fresh bool
+}
+
+func (w *csrfWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// csrf keeps other sites from writing in the name of a signed in user. The
+// token in the csrf_token cookie is also in every form (double submit); a
+// form posted without it is refused. Other bodies, like the JSON of the
+// passkey calls, need an Origin or Referer of the forum or of an allowed
+// origin. Any Origin or Referer a write comes with must be one of those.
+func (h *Handler) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &csrfWriter{ResponseWriter: w}
+		// the token goes into pages unescaped, so only one of ours is taken
+		if c, err := r.Cookie(csrfCookie); err == nil && wellFormedCSRF(c.Value) {
+			cw.token = c.Value
+		} else {
+			cw.token, cw.fresh = randomToken(), true
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			_, route := h.Mux.Handler(r)
+			if !csrfExempt[route] && !h.allowWrite(r) {
+				h.ErrorPage(w, errCSRF, http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// allowWrite tells whether the state changing request r comes from the
+// forum's own pages.
+func (h *Handler) allowWrite(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Referer()
+	}
+	if source != "" && !h.trustedSource(r, source) {
+		return false
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return false
+		}
+		return validCSRF(r, r.PostForm.Get(csrfFieldName))
+	case "multipart/form-data":
+		return validCSRF(r, multipartCSRF(r, params["boundary"]))
+	default:
+		return source != ""
+	}
+}
+
+// validCSRF tells whether sent is the token in the cookie of r.
+func validCSRF(r *http.Request, sent string) bool {
+	c, err := r.Cookie(csrfCookie)
+	return err == nil && wellFormedCSRF(c.Value) && subtle.ConstantTimeCompare([]byte(c.Value), []byte(sent)) == 1
+}
+
+// multipartCSRF reads the token from the first part of a multipart body and
+// puts back what it read, so the handler parses the body whole, with its own
+// size limit.
+func multipartCSRF(r *http.Request, boundary string) string {
+	if boundary == "" {
+		return ""
+	}
+	body := r.Body
+	var read bytes.Buffer
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, csrfPeek), &read), boundary)
+	token := ""
+	if part, err := mr.NextPart(); err == nil && part.FormName() == csrfFieldName {
+		value, _ := io.ReadAll(io.LimitReader(part, 256))
+		token = string(value)
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&read, body), body}
+	return token
+}
+
+// trustedSource tells whether source, the Origin or Referer of r, is the
+// forum itself or an origin allowed in the config.
+func (h *Handler) trustedSource(r *http.Request, source string) bool {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	origin := u.Scheme + "://" + u.Host
+	if base, err := url.Parse(h.Config.Mail.BaseURL); err == nil && base.Host != "" && strings.EqualFold(base.Scheme+"://"+base.Host, origin) {
+		return true
+	}
+	return h.corsAllowed(origin)
+}
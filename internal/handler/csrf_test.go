@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir("../.."))
+	defer os.Chdir(wd)
+
+	h := &Handler{
+		Mux:  http.NewServeMux(),
+		Temp: template.Must(template.New("").Funcs(templateFuncs).Parse(`{{define "form.html"}}<form method="POST">{{csrfField}}</form><a href="/logout?csrf_token={{csrfToken}}">{{.}}</a>{{end}}`)),
+	}
+	var got string
+	h.Mux.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.render(w, "form.html", r.URL.Query().Get("text"))
+			return
+		}
+		r.ParseMultipartForm(1 << 20)
+		got = r.FormValue("body")
+	})
+	h.Mux.HandleFunc("/unsubscribe", func(w http.ResponseWriter, r *http.Request) {})
+	srv := h.csrf(h.Mux)
+
+	// the first page sets the cookie and puts the token in forms and links
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form?text=hi", nil))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	require.Equal(t, csrfCookie, cookie.Name)
+	require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	page := w.Body.String()
+	require.Contains(t, page, `value="`+cookie.Value+`"`)
+	require.Contains(t, page, "csrf_token="+cookie.Value)
+	require.NotContains(t, page, csrfPlaceholder)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/form", nil)
+	r.AddCookie(cookie)
+	srv.ServeHTTP(w, r)
+	require.Empty(t, w.Result().Cookies(), "a browser with a token keeps it")
+
+	send := func(r *http.Request, withCookie bool) int {
+		if withCookie {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Code
+	}
+	form := func(token string) *http.Request {
+		values := url.Values{"body": {"hello"}, csrfFieldName: {token}}
+		r := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	require.Equal(t, http.StatusOK, send(form(cookie.Value), true))
+	require.Equal(t, "hello", got)
+	require.Equal(t, http.StatusForbidden, send(form(""), true))
+	require.Equal(t, http.StatusForbidden, send(form(cookie.Value), false))
+	require.Equal(t, http.StatusForbidden, send(form(randomToken()), true))
+
+	r = form(cookie.Value)
+	r.Header.Set("Origin", "https://evil.example")
+	require.Equal(t, http.StatusForbidden, send(r, true), "a foreign origin is refused even with the token")
+	r = form(cookie.Value)
+	r.Header.Set("Origin", "https://example.com")
+	require.Equal(t, http.StatusOK, send(r, true))
+
+	// multipart bodies are left whole for the handler
+	multipartForm := func(token string) *http.Request {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField(csrfFieldName, token)
+		fw, _ := mw.CreateFormFile("image", "big.png")
+		fw.Write(bytes.Repeat([]byte("x"), 3*csrfPeek))
+		mw.WriteField("body", "with image")
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/form", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		return r
+	}
+	require.Equal(t, http.StatusOK, send(multipartForm(cookie.Value), true))
+	require.Equal(t, "with image", got)
+	require.Equal(t, http.StatusForbidden, send(multipartForm("nope"), true))
+
+	// JSON needs the forum's origin
+	json := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	require.Equal(t, http.StatusOK, send(json("https://example.com"), true))
+	require.Equal(t, http.StatusForbidden, send(json(""), true))
+	require.Equal(t, http.StatusForbidden, send(json("null"), true))
+	require.Equal(t, http.StatusForbidden, send(json("https://evil.example"), true))
+	h.Config.CORS.AllowedOrigins = []string{"https://app.example"}
+	require.Equal(t, http.StatusOK, send(json("https://app.example"), true))
+
+	r = httptest.NewRequest(http.MethodPost, "/unsubscribe?token=abc", nil)
+	r.Header.Set("Origin", "https://mail.example")
+	require.Equal(t, http.StatusOK, send(r, false), "one click unsubscribe")
+}
+
+func TestCORS(t *testing.T) {
+	h := &Handler{}
+	h.Config.CORS.AllowedOrigins = []string{"https://app.example/"}
+	srv := h.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/webauthn/login/start", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	w := preflight("https://app.example")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "https://app.example", w.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	require.NotEmpty(t, w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = preflight("https://evil.example")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	require.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	require.Equal(t, "ok", w.Body.String())
+	require.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "Origin", w.Header().Get("Vary"))
+}
+
+func TestTemplatesHaveCSRFField(t *testing.T) {
+	forms := regexp.MustCompile(`(?is)<form\b[^>]*method="post"[^>]*>\s*\{\{csrfField\}\}`)
+	posts := regexp.MustCompile(`(?is)<form\b[^>]*method="post"`)
+	files, err := os.ReadDir("../../front/html")
+	require.NoError(t, err)
+	for _, f := range files {
+		b, err := os.ReadFile("../../front/html/" + f.Name())
+		require.NoError(t, err)
+		require.Equal(t, len(posts.FindAll(b, -1)), len(forms.FindAll(b, -1)), "%s: a POST form without {{csrfField}} first", f.Name())
+	}
+}
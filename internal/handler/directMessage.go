@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (h *Handler) messages(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/messages/" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := userValue.(models.User)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		h.renderMessages(w, user, id, "")
+	case http.MethodPost:
+		if err := r.ParseMultipartForm(20 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			h.ErrorPage(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		switch r.FormValue("form") {
+		case "new":
+			usernames := strings.Split(r.FormValue("to"), ",")
+			convID, err := h.Service.StartConversation(user.Id, usernames, r.FormValue("title"))
+			if err != nil {
+				h.renderMessages(w, user, 0, err.Error())
+				return
+			}
+			if body := r.FormValue("body"); strings.TrimSpace(body) != "" {
+				if err := h.Service.SendDirectMessage(models.DirectMessage{ConversationId: convID, SenderId: user.Id, Body: body}); err != nil {
+					h.renderMessages(w, user, convID, err.Error())
+					return
+				}
+			}
+			id = convID
+		case "send":
+			image, err := saveUploadedImage(r, "image")
+			if err != nil && !errors.Is(err, http.ErrMissingFile) {
+				h.renderMessages(w, user, id, err.Error())
+				return
+			}
+			if err := h.Service.SendDirectMessage(models.DirectMessage{
+				ConversationId: id,
+				SenderId:       user.Id,
+				Body:           r.FormValue("body"),
+				Image:          image,
+			}); err != nil {
+				removeUploadedImage(image)
+				h.renderMessages(w, user, id, err.Error())
+				return
+			}
+		case "report":
+			messageID, _ := strconv.Atoi(r.FormValue("message_id"))
+			if err := h.Service.ReportConversation(models.DMReport{
+				ConversationId: id,
+				MessageId:      messageID,
+				ReporterId:     user.Id,
+				Reason:         r.FormValue("reason"),
+			}); err != nil {
+				h.renderMessages(w, user, id, err.Error())
+				return
+			}
+		case "block":
+			if err := h.Service.DirectMessageServiceIR.BlockUser(user.Id, r.FormValue("username")); err != nil {
+				h.renderMessages(w, user, 0, err.Error())
+				return
+			}
+			id = 0
+		case "unblock":
+			blockedID, _ := strconv.Atoi(r.FormValue("user_id"))
+			if err := h.Service.UnblockUser(user.Id, blockedID); err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				models.ErrLog.Println(err)
+				return
+			}
+			id = 0
+		default:
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if id != 0 {
+			http.Redirect(w, r, "/messages/?id="+strconv.Itoa(id), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/messages/", http.StatusSeeOther)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) renderMessages(w http.ResponseWriter, user models.User, conversationID int, message string) {
+	model := models.InfoMessages{
+		User:  user,
+		Error: message,
+	}
+	var err error
+	if conversationID != 0 {
+		model.Conversation, model.Messages, err = h.Service.GetThread(conversationID, user.Id)
+		if errors.Is(err, service.ErrNotConversationMember) {
+			h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			models.ErrLog.Println(err)
+			return
+		}
+	}
+	if model.Conversations, err = h.Service.GetInbox(user.Id); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		models.ErrLog.Println(err)
+		return
+	}
+	for _, c := range model.Conversations {
+		model.Unread += c.Unread
+	}
+	if model.Blocked, err = h.Service.GetBlockedUsers(user.Id); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		models.ErrLog.Println(err)
+		return
+	}
+	if err := h.render(w, "messages.html", model); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		models.ErrLog.Println(err)
+	}
+}
+
+// messageReport lets staff read a private conversation, but only one that a
+// member has reported.
+func (h *Handler) messageReport(w http.ResponseWriter, r *http.Request) {
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := userValue.(models.User)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if user.Rol != "king" && user.Rol != "admin" && user.Rol != "moderator" {
+		h.ErrorPage(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if id == 0 || err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		report, conversation, messages, err := h.Service.GetReportedConversation(id)
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err := h.render(w, "messages.html", models.InfoMessages{
+			User:         user,
+			Report:       report,
+			Conversation: conversation,
+			Messages:     messages,
+		}); err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			models.ErrLog.Println(err)
+		}
+	case http.MethodPost:
+		if err := h.Service.ResolveDMReport(id, user.Id); err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			models.ErrLog.Println(err)
+			return
+		}
+		http.Redirect(w, r, "/profile/?id="+strconv.Itoa(user.Id), http.StatusSeeOther)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -47,7 +47,7 @@ func (h *Handler) commentPage(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		if err = h.Temp.ExecuteTemplate(w, "comment.html", commentinfo); err != nil {
+		if err = h.render(w, "comment.html", commentinfo); err != nil {
 			log.Println(err.Error())
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
@@ -93,6 +93,10 @@ func (h *Handler) changePost(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, "INVALID CATEGORY, please select existing categories ", http.StatusBadRequest)
 		return
 	}
+	if err := h.Service.CheckCategories(categories); err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if len(description) > 600 || len(description) == 0 {
 		h.ErrorPage(w, "description should be shorter than 400 symbols and not empty", http.StatusBadRequest)
 		return
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"fmt"
 	"forum/internal/models"
 	"html/template"
@@ -10,16 +11,19 @@ import (
 func (h *Handler) ErrorPage(w http.ResponseWriter, message string, status int) {
 	models.ErrLog.Println(message)
 	errData := models.Error{Status: status, StatusText: http.StatusText(status), Message: message}
-	templ, err := template.ParseFiles("./front/html/error.html")
+	templ, err := template.New("error.html").Funcs(templateFuncs).ParseFiles("./front/html/error.html")
 	if err != nil {
 		fmt.Printf("error handler: parsefiles: %s\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(status)
-	if err := templ.Execute(w, errData); err != nil {
+	var page bytes.Buffer
+	if err := templ.Execute(&page, errData); err != nil {
 		fmt.Printf("error handler: execute: %s\n", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	body := fillPage(w, page.Bytes())
+	w.WriteHeader(status)
+	w.Write(body)
 }
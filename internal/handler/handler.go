@@ -19,9 +19,12 @@ type Handler struct {
 }
 
 func NewHandler(services *service.Service, config svr.Config) *Handler {
+	uploads := uploadsPrefix(config.Headers)
 	return &Handler{
-		Mux:     http.NewServeMux(),
-		Temp:    template.Must(template.ParseGlob("./front/html/*.html")),
+		Mux: http.NewServeMux(),
+		Temp: template.Must(template.New("").Funcs(templateFuncs).Funcs(template.FuncMap{
+			"uploads": func() string { return uploads },
+		}).ParseGlob("./front/html/*.html")),
 		Service: services,
 		Config:  config,
 		sessionStore: service.NewRedisWebAuthnSessionStore(
@@ -40,21 +43,35 @@ func (h *Handler) InitRoutes() http.Handler {
 	h.Mux.HandleFunc("/signup", h.middleWareGetUser(h.signUp))
 	h.Mux.HandleFunc("/signin", h.signIn)
 	h.Mux.HandleFunc("/verify", h.verifyEmail)
+	h.Mux.HandleFunc("/verify/totp", h.verifyTOTP)
+	h.Mux.HandleFunc("/forgot", h.forgotPassword)
+	h.Mux.HandleFunc("/reset", h.resetPassword)
+	h.Mux.HandleFunc("/verify-email", h.middleWareGetUser(h.confirmEmail))
+	h.Mux.HandleFunc("/email/confirm", h.middleWareGetUser(h.emailChangeConfirm))
+	h.Mux.HandleFunc("/email/cancel", h.middleWareGetUser(h.emailChangeCancel))
 	h.Mux.HandleFunc("/passkey3fa", h.passkeyLogin)
 
-	h.Mux.HandleFunc("/auth/google", h.googleAuth)
-	h.Mux.HandleFunc("/oauth2callback-google", h.googleAuthCallback)
-	h.Mux.HandleFunc("/auth/google/callback", h.googleAuthCallback)
-
-	h.Mux.HandleFunc("/login/github/", h.githubLogin)
-	h.Mux.HandleFunc("/oauth2callback", h.githubCallback)
-	h.Mux.HandleFunc("/login/github/callback", h.githubCallback)
+	h.Mux.HandleFunc("/auth/", h.middleWareGetUser(h.oauth))
+	// callback URLs still registered at the providers from the old login;
+	// the state cookie tells which provider it is
+	h.Mux.HandleFunc("/oauth2callback-google", h.middleWareGetUser(h.oauthCallback))
+	h.Mux.HandleFunc("/oauth2callback", h.middleWareGetUser(h.oauthCallback))
+	h.Mux.HandleFunc("/login/github/callback", h.middleWareGetUser(h.oauthCallback))
+	h.Mux.HandleFunc("/login/github/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/auth/github", http.StatusMovedPermanently)
+	})
 
 	h.Mux.HandleFunc("/post/", h.middleWareGetUser(h.postPage))
 	h.Mux.HandleFunc("/post/create", h.middleWareGetUser(h.createPost))
 	h.Mux.HandleFunc("/post/myPost", h.middleWareGetUser(h.myPost))
+	h.Mux.HandleFunc("/post/drafts", h.middleWareGetUser(h.myDrafts))
+	h.Mux.HandleFunc("/post/moderate", h.middleWareGetUser(h.moderatePost))
+	h.Mux.HandleFunc("/announcement/dismiss", h.middleWareGetUser(h.dismissAnnouncement))
 	h.Mux.HandleFunc("/post/myLikedPost", h.middleWareGetUser(h.myLikedPost))
 
+	h.Mux.HandleFunc("/poll/vote", h.middleWareGetUser(h.pollVote))
+	h.Mux.HandleFunc("/poll/results", h.middleWareGetUser(h.pollResults))
+
 	h.Mux.HandleFunc("/emotion/post/", h.middleWareGetUser(h.emotionPost))
 	h.Mux.HandleFunc("/emotion/comment/", h.middleWareGetUser(h.emotionComment))
 
@@ -67,6 +84,14 @@ func (h *Handler) InitRoutes() http.Handler {
 
 	h.Mux.HandleFunc("/notification/", h.middleWareGetUser(h.notification))
 	h.Mux.HandleFunc("/settings/", h.middleWareGetUser(h.settings))
+	h.Mux.HandleFunc("/security", h.middleWareGetUser(h.security))
+	h.Mux.HandleFunc("/security/not-me", h.securityNotMe)
+	h.Mux.HandleFunc("/admin/risk", h.middleWareGetUser(h.riskDashboard))
+	h.Mux.HandleFunc("/admin/risk/export", h.middleWareGetUser(h.riskExport))
+	h.Mux.HandleFunc("/admin/jobs", h.middleWareGetUser(h.jobs))
+	h.Mux.HandleFunc("/messages/", h.middleWareGetUser(h.messages))
+	h.Mux.HandleFunc("/messages/report", h.middleWareGetUser(h.messageReport))
+	h.Mux.HandleFunc("/unsubscribe", h.unsubscribe)
 
 	h.Mux.HandleFunc("/webauthn/register/start", h.middleWareGetUser(h.WebAuthnRegisterStart))
 	h.Mux.HandleFunc("/webauthn/register/finish", h.middleWareGetUser(h.WebAuthnRegisterFinish))
@@ -74,13 +99,22 @@ func (h *Handler) InitRoutes() http.Handler {
 	h.Mux.HandleFunc("/webauthn/login/start", h.middleWareGetUser(h.WebAuthnLoginStart))
 	h.Mux.HandleFunc("/webauthn/login/finish", h.middleWareGetUser(h.WebAuthnLoginFinish))
 
+	h.Mux.HandleFunc("/webauthn/sudo/start", h.middleWareGetUser(h.WebAuthnSudoStart))
+	h.Mux.HandleFunc("/webauthn/sudo/finish", h.middleWareGetUser(h.WebAuthnSudoFinish))
+
 	h.Mux.HandleFunc("/webauthn/credentials/delete", h.middleWareGetUser(h.DeleteCredentials))
 
 	h.Mux.HandleFunc("/logout", h.logOut)
+	h.Mux.HandleFunc("/healthz", h.healthz)
+	h.Mux.HandleFunc("/readyz", h.readyz)
 	fileServer := http.FileServer(neuteredFileSystem{http.Dir("./front/static/")})
 	h.Mux.Handle("/static", http.NotFoundHandler())
 	h.Mux.Handle("/static/", http.StripPrefix("/static", fileServer))
-	return h.Mux
+	h.Mux.Handle(defaultUploadsPrefix, h.uploads(defaultUploadsPrefix))
+	if prefix := uploadsPrefix(h.Config.Headers); prefix != defaultUploadsPrefix {
+		h.Mux.Handle(prefix, h.uploads(prefix))
+	}
+	return h.forwarded(h.securityHeaders(h.cors(h.csrf(h.rateLimit(h.Mux)))))
 }
 
 type neuteredFileSystem struct {
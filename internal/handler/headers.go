@@ -0,0 +1,111 @@
+package handler
+
+import (
+	svr "forum/internal/server"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultCSP lets pages load only what the forum serves itself and run only
+// the inline scripts carrying the nonce of the request. Styles stay inline
+// as the templates have many style attributes.
+const defaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+const (
+	defaultHSTSMaxAge     = 180 * 24 * 60 * 60
+	defaultReferrerPolicy = "strict-origin-when-cross-origin"
+
+	uploadDir            = "./front/static/data/"
+	defaultUploadsPrefix = "/static/data/"
+)
+
+// noncePlaceholder stands for the nonce in executed templates until
+// fillPage puts the nonce of the request in.
+var noncePlaceholder = randomToken()
+
+// uploadTypes are the only types uploaded files are served as, by extension.
+var uploadTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+}
+
+// cspNonce is the nonce every inline script tag carries.
+func cspNonce() string {
+	return noncePlaceholder
+}
+
+// nonceWriter carries the CSP nonce of the request to fillPage.
+type nonceWriter struct {
+	http.ResponseWriter
+	nonce string
+}
+
+func (w *nonceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// securityHeaders sets the Content-Security-Policy with a new nonce, HSTS
+// on HTTPS, and the headers against sniffing, framing and leaking
+// URLs in the Referer of every answer.
+func (h *Handler) securityHeaders(next http.Handler) http.Handler {
+	policy := h.Config.Headers.CSP
+	if policy == "" {
+		policy = defaultCSP
+	}
+	referrer := h.Config.Headers.ReferrerPolicy
+	if referrer == "" {
+		referrer = defaultReferrerPolicy
+	}
+	hsts := ""
+	switch maxAge := h.Config.Headers.HSTSMaxAge; {
+	case maxAge == 0:
+		hsts = "max-age=" + strconv.Itoa(defaultHSTSMaxAge)
+	case maxAge > 0:
+		hsts = "max-age=" + strconv.Itoa(maxAge)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := randomToken()
+		header := w.Header()
+		header.Set("Content-Security-Policy", strings.ReplaceAll(policy, "{nonce}", nonce))
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", referrer)
+		if secure(r) && hsts != "" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(&nonceWriter{ResponseWriter: w, nonce: nonce}, r)
+	})
+}
+
+// uploadsPrefix is the path uploaded images are linked from.
+func uploadsPrefix(config svr.HeadersConfig) string {
+	prefix := strings.Trim(config.UploadsPrefix, "/")
+	if prefix == "" {
+		return defaultUploadsPrefix
+	}
+	return "/" + prefix + "/"
+}
+
+// uploads serves the images users uploaded under prefix. A file is only
+// sent as the image type its extension names, never sniffed, and under a
+// policy that runs nothing, so an upload that is not really an image cannot
+// act as a page of the forum.
+func (h *Handler) uploads(prefix string) http.Handler {
+	files := http.FileServer(neuteredFileSystem{http.Dir(uploadDir)})
+	return http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType, ok := uploadTypes[strings.ToLower(path.Ext(r.URL.Path))]
+		if !ok {
+			h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		files.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"crypto/tls"
+	svr "forum/internal/server"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	h := &Handler{
+		Temp: template.Must(template.New("").Funcs(templateFuncs).Parse(`{{define "page.html"}}<script nonce="{{cspNonce}}">go()</script>{{end}}`)),
+	}
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.render(w, "page.html", nil)
+	})
+	srv := h.securityHeaders(page)
+
+	get := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	w := get(httptest.NewRequest(http.MethodGet, "/", nil))
+	csp := w.Header().Get("Content-Security-Policy")
+	nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(csp)
+	require.Len(t, nonce, 2, csp)
+	require.Equal(t, `<script nonce="`+nonce[1]+`">go()</script>`, w.Body.String())
+	require.Contains(t, csp, "frame-ancestors 'none'")
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	require.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	require.Equal(t, defaultReferrerPolicy, w.Header().Get("Referrer-Policy"))
+	require.Empty(t, w.Header().Get("Strict-Transport-Security"), "not on plain HTTP")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	w2 := get(r)
+	require.Equal(t, "max-age=15552000", w2.Header().Get("Strict-Transport-Security"))
+	require.NotEqual(t, csp, w2.Header().Get("Content-Security-Policy"), "a new nonce every time")
+
+	h.Config.Headers.CSP = "script-src 'nonce-{nonce}'"
+	h.Config.Headers.HSTSMaxAge = -1
+	srv = h.securityHeaders(page)
+	w = get(r)
+	require.Regexp(t, `^script-src 'nonce-[\w-]+'$`, w.Header().Get("Content-Security-Policy"))
+	require.Empty(t, w.Header().Get("Strict-Transport-Security"))
+}
+
+func TestUploads(t *testing.T) {
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir("../.."))
+	defer os.Chdir(wd)
+
+	f, err := os.CreateTemp(uploadDir, "test*.png")
+	require.NoError(t, err)
+	f.WriteString("<html><script>alert(1)</script>")
+	f.Close()
+	defer os.Remove(f.Name())
+	name := f.Name()[len(uploadDir):]
+
+	require.Equal(t, "/uploads/", uploadsPrefix(svr.HeadersConfig{UploadsPrefix: "uploads"}))
+	require.Equal(t, defaultUploadsPrefix, uploadsPrefix(svr.HeadersConfig{UploadsPrefix: "/"}))
+
+	h := &Handler{}
+	srv := h.uploads("/uploads/")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/uploads/"+name, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"), "never sniffed as HTML")
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	require.Contains(t, w.Header().Get("Content-Security-Policy"), "sandbox")
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/uploads/Readme.md", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTemplatesFollowCSP(t *testing.T) {
+	handlers := regexp.MustCompile(`(?i)<[^>]+\son[a-z]+=`)
+	scripts := regexp.MustCompile(`<script(\s[^>]*)?>`)
+	files, err := os.ReadDir("../../front/html")
+	require.NoError(t, err)
+	for _, f := range files {
+		b, err := os.ReadFile("../../front/html/" + f.Name())
+		require.NoError(t, err)
+		require.Nil(t, handlers.Find(b), "%s: inline event handlers do not run under the CSP", f.Name())
+		for _, tag := range scripts.FindAll(b, -1) {
+			require.Regexp(t, `src=|nonce="\{\{cspNonce\}\}"`, string(tag), f.Name())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"forum/internal/models"
+	"io"
+	"net/http"
+)
+
+// healthz is the liveness probe: it answers as long as the process serves
+// requests, whatever the state of the database or Redis.
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, "ok\n")
+}
+
+// readyz is the readiness probe: 200 when the database answers, Redis
+// answers and the migrations were applied, 503 naming what failed when not.
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	ready, checks := h.Service.Readiness(r.Context())
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+		for _, c := range checks {
+			if !c.OK {
+				models.ErrLog.Printf("readyz: %s: %s", c.Name, c.Error)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
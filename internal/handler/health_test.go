@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"forum/internal/models"
+	"forum/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHealth struct {
+	checks []models.HealthCheck
+}
+
+func (f fakeHealth) Readiness(ctx context.Context) (bool, []models.HealthCheck) {
+	for _, c := range f.checks {
+		if !c.OK {
+			return false, f.checks
+		}
+	}
+	return true, f.checks
+}
+
+func TestProbes(t *testing.T) {
+	health := &fakeHealth{checks: []models.HealthCheck{{Name: "db", OK: true}, {Name: "redis", OK: true}}}
+	h := &Handler{Service: &service.Service{HealthServiceIR: health}}
+
+	get := func(probe http.HandlerFunc) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		probe(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+	w := get(h.healthz)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "ok\n", w.Body.String())
+
+	w = get(h.readyz)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"status":"ready","checks":[{"name":"db","ok":true},{"name":"redis","ok":true}]}`, w.Body.String())
+
+	health.checks[1] = models.HealthCheck{Name: "redis", Error: "dial tcp 10.0.0.5:6379: connection refused"}
+	w = get(h.readyz)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var body struct {
+		Status string
+		Checks []map[string]any
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Equal(t, "not ready", body.Status)
+	require.NotContains(t, w.Body.String(), "10.0.0.5", "errors are only logged")
+
+	require.Equal(t, http.StatusOK, get(h.healthz).Code, "liveness does not depend on Redis")
+}
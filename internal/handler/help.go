@@ -3,8 +3,16 @@ package handler
 import (
 	"fmt"
 	"forum/internal/models"
+	"html"
+	"html/template"
 	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+
+	"github.com/gofrs/uuid"
 )
 
 type ByCreatedAt []models.Post
@@ -56,3 +64,88 @@ func checkImageSignature(file io.Reader) (string, error) {
 
 	return "", fmt.Errorf("Invalid image file ERROR")
 }
+
+// saveUploadedImage validates the image sent in the given form field and
+// stores it under ./front/static/data/ with a random name. It returns
+// http.ErrMissingFile when nothing was uploaded.
+func saveUploadedImage(r *http.Request, field string) (string, error) {
+	file, handler, err := r.FormFile(field)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := checkImageSignature(file); err != nil {
+		return "", err
+	}
+
+	fileExt := strings.ToLower(filepath.Ext(filepath.Base(handler.Filename)))
+	if fileExt != ".jpeg" && fileExt != ".png" && fileExt != ".gif" && fileExt != ".jpg" {
+		return "", fmt.Errorf("Invalid image file extension")
+	}
+	uniqueID, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	image := strings.Replace(uniqueID.String(), "-", "", -1) + fileExt
+	f, err := os.Create("./front/static/data/" + image)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, file); err != nil {
+		return "", err
+	}
+	return image, nil
+}
+
+// removeUploadedImage deletes an image saveUploadedImage wrote when what it
+// was uploaded for is refused.
+func removeUploadedImage(image string) {
+	if image == "" {
+		return
+	}
+	if err := os.Remove(uploadDir + image); err != nil && !os.IsNotExist(err) {
+		models.ErrLog.Println("remove upload:", err)
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"markdown":  markdownLite,
+	"csrfField": csrfField,
+	"csrfToken": csrfToken,
+	"cspNonce":  cspNonce,
+	"uploads":   func() string { return defaultUploadsPrefix },
+}
+
+var (
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^\s)]+)\)`)
+	mdBold   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdItalic = regexp.MustCompile(`\*([^*]+)\*`)
+	mdCode   = regexp.MustCompile("`([^`]+)`")
+)
+
+// markdownLite renders the small Markdown subset allowed in bios: **bold**,
+// *italic*, `code`, [links](https://...) and line breaks. The text is escaped
+// first, so no other HTML gets through.
+func markdownLite(text string) template.HTML {
+	out := html.EscapeString(text)
+	out = mdCode.ReplaceAllString(out, "<code>$1</code>")
+	out = mdLink.ReplaceAllString(out, `<a href="$2" rel="nofollow noopener" target="_blank">$1</a>`)
+	out = mdBold.ReplaceAllString(out, "<strong>$1</strong>")
+	out = mdItalic.ReplaceAllString(out, "<em>$1</em>")
+	out = strings.ReplaceAll(out, "\n", "<br>")
+	return template.HTML(out)
+}
+
+func isStaff(user models.User) bool {
+	return user.IsAuth && (user.Rol == "king" || user.Rol == "admin" || user.Rol == "moderator")
+}
+
+// evaluateBadges re-checks the badges of userID after something that can earn
+// one. A failure only delays the badge until the next backfill run.
+func (h *Handler) evaluateBadges(userID int) {
+	if err := h.Service.EvaluateBadges(userID); err != nil {
+		models.ErrLog.Println("badges:", err)
+	}
+}
@@ -37,13 +37,15 @@ func (h *Handler) homePage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	var current models.Category
 	if r.URL.Query().Has("category") {
-		category := r.URL.Query().Get("category")
-		if !inSlice(category, categories) {
+		// links use the slug, older ones the category name
+		current, err = h.Service.GetCategory(r.URL.Query().Get("category"))
+		if err != nil {
 			h.ErrorPage(w, "Not exist page", http.StatusBadRequest)
 			return
 		}
-		posts, err = h.Service.ServicePostIR.GetAllPostsByCategories(category)
+		posts, err = h.Service.ServicePostIR.GetAllPostsByCategories(current.Name)
 		if err != nil {
 			if !errors.Is(err, sql.ErrNoRows) {
 				h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
@@ -60,17 +62,47 @@ func (h *Handler) homePage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// pins on the front page are global, on a category page that category's
+	pinned, err := h.Service.GetPinnedPosts(current.Name)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	announcements, err := h.Service.GetAnnouncements(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	info := models.InfoPosts{
-		user,
-		posts,
-		categories,
+		User:          user,
+		Posts:         withoutPosts(posts, pinned),
+		Category:      categories,
+		Current:       current,
+		Pinned:        pinned,
+		Announcements: announcements,
 	}
-	if err := h.Temp.ExecuteTemplate(w, "homepage.html", info); err != nil {
+	if err := h.render(w, "homepage.html", info); err != nil {
 		log.Println(err.Error())
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// withoutPosts drops from posts the ones already listed in shown.
+func withoutPosts(posts, shown []models.Post) []models.Post {
+	ids := make(map[int]bool, len(shown))
+	for _, post := range shown {
+		ids[post.Id] = true
+	}
+	var rest []models.Post
+	for _, post := range posts {
+		if !ids[post.Id] {
+			rest = append(rest, post)
+		}
+	}
+	return rest
+}
+
 func inSlice(val string, slice []models.Category) bool {
 	for _, item := range slice {
 		if item.Name == val {
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/service"
+	"net/http"
+	"strconv"
+)
+
+// jobs serves /admin/jobs: how many jobs wait, run and died, the jobs with
+// one status (dead by default), and retrying or discarding dead ones.
+func (h *Handler) jobs(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/jobs" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	if !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.renderJobs(w, user, r.URL.Query().Get("status"))
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			h.ErrorPage(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		switch r.FormValue("action") {
+		case "retry":
+			err = h.Service.RetryJob(user, id)
+		case "discard":
+			err = h.Service.DiscardJob(user, id)
+		default:
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.jobsError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) renderJobs(w http.ResponseWriter, user models.User, status string) {
+	counts, err := h.Service.CountJobs(user)
+	if err != nil {
+		h.jobsError(w, err)
+		return
+	}
+	jobs, err := h.Service.ListJobs(user, status)
+	if err != nil {
+		h.jobsError(w, err)
+		return
+	}
+	if status == "" {
+		status = "dead"
+	}
+	if err := h.render(w, "jobs.html", map[string]any{
+		"Counts": counts,
+		"Status": status,
+		"Jobs":   jobs,
+	}); err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) jobsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotAdmin):
+		h.ErrorPage(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, service.ErrJobStatus):
+		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+	default:
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
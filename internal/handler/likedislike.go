@@ -79,6 +79,7 @@ func (h *Handler) emotionComment(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
 	}
+	h.evaluateBadges(comment.UserId)
 	link := fmt.Sprintf("/post/?id=%d", postId)
 	http.Redirect(w, r, link, http.StatusSeeOther)
 }
@@ -146,6 +147,7 @@ func (h *Handler) emotionPost(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
 	}
+	h.evaluateBadges(post.UserId)
 	link := fmt.Sprintf("/post/?id=%d", postId)
 	http.Redirect(w, r, link, http.StatusSeeOther)
 }
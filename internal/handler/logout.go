@@ -13,6 +13,11 @@ func (h *Handler) logOut(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
+	// a link cannot be posted, so it carries the CSRF token in the query
+	if !validCSRF(r, r.URL.Query().Get(csrfFieldName)) {
+		h.ErrorPage(w, errCSRF, http.StatusForbidden)
+		return
+	}
 	c, err := r.Cookie("token")
 	if err != nil {
 		if err == http.ErrNoCookie {
@@ -27,9 +32,13 @@ func (h *Handler) logOut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	http.SetCookie(w, &http.Cookie{
-		Name: "token",
-
-		MaxAge: -1,
+		Name:     "token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
 	})
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -4,20 +4,46 @@ import (
 	"context"
 	"forum/internal/models"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 )
 
-func (h *Handler) middleWareGetUser(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+// cors lets the pages of the origins in cors.allowedorigins call the forum
+// with the user's cookies. Other origins get no CORS headers, so browsers
+// keep their pages from reading the answers.
+func (h *Handler) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && h.corsAllowed(origin)
+		w.Header().Add("Vary", "Origin")
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
 		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
+			if allowed && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) corsAllowed(origin string) bool {
+	for _, allowed := range h.Config.CORS.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
 
+func (h *Handler) middleWareGetUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		var user models.User
 		c, err := r.Cookie("token")
 		if err != nil {
@@ -44,18 +70,3 @@ func (h *Handler) middleWareGetUser(next http.HandlerFunc) http.HandlerFunc {
 
 	}
 }
-
-type client struct {
-	requests int
-	lastSeen time.Time
-}
-
-var (
-	clients = make(map[string]*client)
-	mu      sync.Mutex
-)
-
-const (
-	maxRequests = 10
-	window      = time.Minute
-)
@@ -32,11 +32,11 @@ func (h *Handler) myLikedPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	info := models.InfoPosts{
-		user,
-		posts,
-		nil,
+		User:     user,
+		Posts:    posts,
+		Category: nil,
 	}
-	if err := h.Temp.ExecuteTemplate(w, "myLikedPost.html", info); err != nil {
+	if err := h.render(w, "myLikedPost.html", info); err != nil {
 		log.Println(err.Error())
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 	}
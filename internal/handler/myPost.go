@@ -39,7 +39,7 @@ func (h *Handler) myPost(w http.ResponseWriter, r *http.Request) {
 		Category: nil,
 	}
 
-	if err := h.Temp.ExecuteTemplate(w, "myPost.html", info); err != nil {
+	if err := h.render(w, "myPost.html", info); err != nil {
 		log.Println(err.Error())
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -86,7 +86,7 @@ func (h *Handler) notification(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	case http.MethodGet:
 
-		if err := h.Temp.ExecuteTemplate(w, "notification.html", messages); err != nil {
+		if err := h.render(w, "notification.html", messages); err != nil {
 			log.Println(err.Error())
 			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 		}
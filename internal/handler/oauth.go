@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/service"
+	"forum/internal/storage"
+	"net/http"
+	"strings"
+)
+
+const oauthStateCookie = "oauth_state"
+
+// oauth serves /auth/{provider} which sends the user to the provider, and
+// /auth/{provider}/callback where the provider sends them back.
+func (h *Handler) oauth(w http.ResponseWriter, r *http.Request) {
+	provider, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/"), "/")
+	switch {
+	case provider == "":
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case rest == "":
+		h.oauthStart(w, r, provider)
+	case rest == "callback":
+		h.oauthCallback(w, r)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+}
+
+// oauthStart redirects to the provider. With ?link=1 a signed in user links
+// the provider to their account instead of logging in.
+func (h *Handler) oauthStart(w http.ResponseWriter, r *http.Request, provider string) {
+	if r.Method != http.MethodGet {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	linkUserID := 0
+	if r.URL.Query().Get("link") == "1" {
+		if !user.IsAuth {
+			h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if !h.requireSudo(w, user) {
+			return
+		}
+		linkUserID = user.Id
+	}
+	redirectURL, state, err := h.Service.StartOAuth(provider, linkUserID)
+	if errors.Is(err, service.ErrUnknownProvider) {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		// Lax, so the cookie comes along on the provider's redirect back
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (h *Handler) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		h.renderSignIn(w, models.InfoSign{Error: service.ErrOAuthState.Error()})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: true})
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		h.renderSignIn(w, models.InfoSign{Error: "The provider did not sign you in: " + reason})
+		return
+	}
+
+	state, claims, err := h.Service.FinishOAuth(r.Context(), cookie.Value, r.URL.Query().Get("state"), r.URL.Query().Get("code"))
+	if err != nil {
+		models.ErrLog.Println(err)
+		if errors.Is(err, service.ErrOAuthState) {
+			h.renderSignIn(w, models.InfoSign{Error: err.Error()})
+			return
+		}
+		h.ErrorPage(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	user, _ := r.Context().Value("user").(models.User)
+	if state.LinkUserID != 0 {
+		if !user.IsAuth || user.Id != state.LinkUserID {
+			h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		message := "Your " + state.Provider + " account is linked"
+		if err := h.Service.LinkIdentity(user.Id, state.Provider, claims); err != nil {
+			if !errors.Is(err, storage.ErrIdentityTaken) {
+				models.ErrLog.Println(err)
+				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			message = err.Error()
+		}
+		h.renderSettings(w, user, message)
+		return
+	}
+
+	account, err := h.Service.LoginWithIdentity(state.Provider, claims)
+	if err != nil {
+		if errors.Is(err, service.ErrOAuthEmailTaken) {
+			h.renderSignIn(w, models.InfoSign{Error: err.Error()})
+			return
+		}
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	token, expired, err := h.Service.Auth.CreateSession(account.Username)
+	if err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// An account without a password has nothing to type on the sudo form,
+	// so the fresh sign in with the provider opens the sudo window.
+	if hasPassword, err := h.Service.HasPassword(account.Id); err == nil && !hasPassword {
+		if err := h.Service.StartSudo(account.Id); err != nil {
+			models.ErrLog.Println(err)
+		}
+	}
+	if err := h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: account.Id,
+		IP:     clientIP(r.RemoteAddr),
+		Device: getDevice(r),
+		Status: true,
+		Reason: "success login by " + state.Provider,
+		Method: state.Provider}); err != nil {
+		models.ErrLog.Println(err)
+	}
+	models.InfoLog.Printf("\n        Name:  %s\n        Status:%s\n", account.Username, "OAuth "+state.Provider)
+	h.SetCookieAndSuccess(w, r, token, expired)
+}
+
+// renderSignIn shows signin.html with the login providers filled in.
+func (h *Handler) renderSignIn(w http.ResponseWriter, info models.InfoSign) {
+	info.Providers = h.Service.OAuthProviders()
+	if err := h.render(w, "signin.html", info); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/service"
+	"forum/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pollFromForm reads the optional poll of the create post form. It returns
+// nil when no question was asked.
+func pollFromForm(r *http.Request) (*models.Poll, error) {
+	question := r.FormValue("poll_question")
+	if question == "" {
+		return nil, nil
+	}
+	poll := &models.Poll{
+		Question:    question,
+		Multiple:    r.FormValue("poll_multiple") == "on",
+		HideResults: r.FormValue("poll_hide") == "on",
+	}
+	for _, text := range r.Form["poll_option"] {
+		poll.Options = append(poll.Options, models.PollOption{Text: text})
+	}
+	if closes := r.FormValue("poll_closes"); closes != "" {
+		t, err := time.ParseInLocation("2006-01-02T15:04", closes, time.Local)
+		if err != nil {
+			return nil, errors.New(" invalid poll close time")
+		}
+		poll.ClosesAt = t
+	}
+	return poll, nil
+}
+
+// pollVote handles /poll/vote?id=<post id>. Form posts are redirected back to
+// the post, fetch requests asking for JSON get the updated results.
+func (h *Handler) pollVote(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/poll/vote" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok || !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	post, ok := h.pollPost(w, r, user)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	var options []int
+	for _, value := range r.Form["option"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		options = append(options, id)
+	}
+	if err := h.Service.VotePoll(post.Id, user.Id, options); err != nil {
+		switch {
+		case errors.Is(err, service.ErrPollClosed), errors.Is(err, service.ErrPollVote), errors.Is(err, storage.ErrPollOption):
+			h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+		default:
+			models.ErrLog.Println("poll vote:", err)
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Header.Get("Accept") == "application/json" {
+		h.writePoll(w, post, user)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/post/?id=%d#poll", post.Id), http.StatusSeeOther)
+}
+
+// pollResults serves /poll/results?id=<post id> for the live result bars.
+func (h *Handler) pollResults(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/poll/results" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	post, ok := h.pollPost(w, r, user)
+	if !ok {
+		return
+	}
+	h.writePoll(w, post, user)
+}
+
+// pollPost loads the post a poll request is about, with the same visibility
+// rules as the post page.
+func (h *Handler) pollPost(w http.ResponseWriter, r *http.Request, user models.User) (models.Post, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if id == 0 || err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return models.Post{}, false
+	}
+	post, err := h.Service.ServicePostIR.GetPostId(id)
+	hidden := post.Status != "done" && (!user.IsAuth || (user.Rol == "user" && user.Id != post.UserId))
+	if post.Status == "draft" || post.Status == "scheduled" {
+		hidden = !user.IsAuth || user.Id != post.UserId
+	}
+	if err != nil || hidden {
+		h.ErrorPage(w, models.ErrPostNotFound.Error(), http.StatusNotFound)
+		return models.Post{}, false
+	}
+	return post, true
+}
+
+func (h *Handler) writePoll(w http.ResponseWriter, post models.Post, user models.User) {
+	poll, err := h.Service.GetPoll(post.Id, user, post.UserId)
+	if err != nil {
+		models.ErrLog.Println("poll results:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if poll == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(poll)
+}
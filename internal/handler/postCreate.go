@@ -1,15 +1,15 @@
 package handler
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"forum/internal/models"
-	"io"
+	"forum/internal/service"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
-
-	"github.com/gofrs/uuid"
+	"strconv"
+	"time"
 )
 
 func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
@@ -32,7 +32,7 @@ func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	categories, err := h.Service.ServicePostIR.GetCategories()
+	categories, err := h.Service.GetCategories()
 	if err != nil {
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -48,67 +48,124 @@ func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
 			h.ErrorPage(w, "File size exceeds the limit of 20 MB", http.StatusRequestEntityTooLarge)
 			return
 		}
-		title := r.FormValue("title")
-		description := r.FormValue("description")
-		categories := r.Form["category"]
-		file, handler, err := r.FormFile("image")
-		if err != nil {
-			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
-			return
+		post := models.Post{
+			Title:       r.FormValue("title"),
+			Description: r.FormValue("description"),
+			Category:    r.Form["category"],
+			UserId:      user.Id,
 		}
-		defer file.Close()
-		_, err = checkImageSignature(file)
-		if err != nil {
-			h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+		if id := r.FormValue("draft_id"); id != "" {
+			if post.Id, err = strconv.Atoi(id); err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		}
+		action := r.FormValue("action")
+		if action == "draft" {
+			h.saveDraft(w, r, post)
 			return
 		}
 
-		uniqueID, err := uuid.NewV4()
-		if err != nil {
-			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		if !user.EmailVerified {
+			h.ErrorPage(w, service.ErrEmailNotVerified.Error(), http.StatusForbidden)
 			return
 		}
-		filename := strings.Replace(uniqueID.String(), "-", "", -1)
-		prevname := filepath.Base(handler.Filename)
-		fileExt := filepath.Ext(prevname)
-
-		if fileExt != ".jpeg" && fileExt != ".png" && fileExt != ".gif" && fileExt != ".jpg" {
+		if err := h.Service.CheckCategories(post.Category); err != nil {
 			h.ErrorPage(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		image := fmt.Sprintf("%s%s", filename, fileExt)
-		imagePath := "./front/static/data/" + image
-		f, err := os.Create(imagePath)
+		poll, err := pollFromForm(r)
 		if err != nil {
-			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+			h.ErrorPage(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer f.Close()
-
-		_, err = io.Copy(f, file)
+		if poll != nil {
+			allowed := isStaff(user)
+			if !allowed {
+				if allowed, err = h.Service.HasPrivilege(user.Id, service.PrivilegeCreatePolls); err != nil {
+					models.ErrLog.Println(err)
+				}
+			}
+			if !allowed {
+				h.ErrorPage(w, fmt.Sprintf("You need %d reputation to create polls", h.Service.PrivilegeThreshold(service.PrivilegeCreatePolls)), http.StatusForbidden)
+				return
+			}
+			if err := h.Service.ValidatePoll(poll); err != nil {
+				h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		image, err := saveUploadedImage(r, "image")
+		if errors.Is(err, http.ErrMissingFile) && post.Id != 0 {
+			// a draft keeps the image it was saved with
+			err = nil
+		}
 		if err != nil {
-			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+			h.ErrorPage(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if err := h.Service.ServicePostIR.CreatePost(models.Post{
-			Title:       title,
-			Description: description,
-			Image:       image,
-			Category:    categories,
-			UserId:      user.Id,
-		}); err != nil {
+		post.Image = image
+
+		postID := post.Id
+		switch {
+		case action == "schedule":
+			var publishAt time.Time
+			if publishAt, err = time.ParseInLocation("2006-01-02T15:04", r.FormValue("publish_at"), time.Local); err != nil {
+				h.ErrorPage(w, service.ErrScheduleTime.Error(), http.StatusBadRequest)
+				return
+			}
+			postID, err = h.Service.SchedulePost(post, publishAt)
+		case post.Id != 0:
+			err = h.Service.SubmitDraft(post)
+		default:
+			postID, err = h.Service.ServicePostIR.CreatePost(post)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			h.ErrorPage(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if user.Rol == "king" || user.Rol == "admin" || user.Rol == "moderator" {
-			if err := h.Service.CommunicationServiceIR.ConfirmPost(0, "forking"); err != nil {
+		if poll != nil {
+			poll.PostId = postID
+			if err := h.Service.CreatePoll(*poll); err != nil {
+				models.ErrLog.Println("create poll:", err)
+				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		if action == "schedule" {
+			http.Redirect(w, r, "/post/drafts", http.StatusSeeOther)
+			return
+		}
+		trusted, err := h.Service.CanSkipModeration(user)
+		if err != nil {
+			models.ErrLog.Println(err)
+		}
+		if trusted {
+			if err := h.Service.CommunicationServiceIR.ConfirmPost(postID, "accept"); err != nil {
 				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
+			h.evaluateBadges(user.Id)
 		}
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	case http.MethodGet:
-		if err := h.Temp.ExecuteTemplate(w, "postCreate.html", categories); err != nil {
+		info := models.InfoCreatePost{User: user, Categories: categories}
+		if id := r.URL.Query().Get("draft"); id != "" {
+			draftID, err := strconv.Atoi(id)
+			if err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			if info.Draft, err = h.Service.GetDraft(draftID, user.Id); err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+		}
+		if err := h.render(w, "postCreate.html", info); err != nil {
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
@@ -117,3 +174,90 @@ func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// saveDraft stores the form as a draft. The autosave in postCreate.html asks
+// for JSON to learn the id of a new draft; the save button is redirected.
+func (h *Handler) saveDraft(w http.ResponseWriter, r *http.Request, post models.Post) {
+	image, err := saveUploadedImage(r, "image")
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	post.Image = image
+	id, err := h.Service.SaveDraft(post)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+		return
+	}
+	http.Redirect(w, r, "/post/drafts", http.StatusSeeOther)
+}
+
+// myDrafts lists the drafts and scheduled posts of the user and handles the
+// delete and unschedule buttons.
+func (h *Handler) myDrafts(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/post/drafts" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok || !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		drafts, err := h.Service.GetDrafts(user.Id)
+		if err != nil {
+			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		info := models.InfoPosts{
+			User:  user,
+			Posts: drafts,
+		}
+		if err := h.render(w, "drafts.html", info); err != nil {
+			models.ErrLog.Println(err.Error())
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		switch r.FormValue("action") {
+		case "delete":
+			err = h.Service.DeleteDraft(id, user.Id)
+		case "unschedule":
+			err = h.Service.UnschedulePost(id, user.Id)
+		default:
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/post/drafts", http.StatusSeeOther)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
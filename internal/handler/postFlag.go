@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// moderatePost handles the pin, lock and announcement controls of post.html
+// at /post/moderate?id=<post id>.
+func (h *Handler) moderatePost(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/post/moderate" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user, ok := userValue.(models.User)
+	if !ok || !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if !isStaff(user) {
+		h.ErrorPage(w, service.ErrNotStaff.Error(), http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if id == 0 || err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	post, err := h.Service.ServicePostIR.GetPostId(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.ErrorPage(w, models.ErrPostNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	var expires time.Time
+	if value := r.FormValue("expires"); value != "" {
+		if expires, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local); err != nil {
+			h.ErrorPage(w, "Invalid expiry time", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := h.Service.SetPostFlag(user, post, r.FormValue("action"), r.FormValue("scope"), expires); err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	models.InfoLog.Printf("post %d: %s %q by %s", post.Id, r.FormValue("action"), r.FormValue("scope"), user.Username)
+	http.Redirect(w, r, fmt.Sprintf("/post/?id=%d", post.Id), http.StatusSeeOther)
+}
+
+// dismissAnnouncement hides an announcement banner for the user.
+func (h *Handler) dismissAnnouncement(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/announcement/dismiss" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := r.Context().Value("user").(models.User)
+	if !ok || !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if id == 0 || err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.Service.DismissAnnouncement(user.Id, id); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
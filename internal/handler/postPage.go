@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"forum/internal/models"
+	"forum/internal/service"
 	"net/http"
 	"sort"
 	"strconv"
@@ -38,6 +39,15 @@ func (h *Handler) postPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if post.Status == "draft" || post.Status == "scheduled" {
+		// unpublished posts are only edited, never shown
+		if user.IsAuth && user.Id == post.UserId {
+			http.Redirect(w, r, fmt.Sprintf("/post/create?draft=%d", post.Id), http.StatusSeeOther)
+			return
+		}
+		h.ErrorPage(w, models.ErrPostNotFound.Error(), http.StatusNotFound)
+		return
+	}
 	if !user.IsAuth {
 		if post.Status != "done" {
 			h.ErrorPage(w, "Bad request or post not exist", http.StatusBadRequest)
@@ -55,7 +65,7 @@ func (h *Handler) postPage(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	categories, err := h.Service.ServicePostIR.GetCategories()
+	categories, err := h.Service.GetCategories()
 	if err != nil {
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -63,13 +73,31 @@ func (h *Handler) postPage(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		sort.Sort(ByCreatedAtCom(comments))
+		poll, err := h.Service.GetPoll(post.Id, user, post.UserId)
+		if err != nil {
+			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		flags, err := h.Service.GetPostFlags(post.Id)
+		if err != nil {
+			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		model := models.Info{
 			User:        user,
 			Post:        post,
 			Comment:     comments,
 			AllCategory: categories,
+			Poll:        poll,
+			Flags:       flags,
+		}
+		if user.IsAuth && user.Rol != "user" {
+			if model.FlagAudit, err = h.Service.GetPostFlagAudit(post.Id); err != nil {
+				h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 		}
-		if err := h.Temp.ExecuteTemplate(w, "post.html", model); err != nil {
+		if err := h.render(w, "post.html", model); err != nil {
 			models.ErrLog.Println(err.Error())
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
@@ -81,6 +109,21 @@ func (h *Handler) postPage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		commentText := r.FormValue("text")
+		if !user.EmailVerified {
+			h.ErrorPage(w, service.ErrEmailNotVerified.Error(), http.StatusForbidden)
+			return
+		}
+		if !isStaff(user) {
+			locked, err := h.Service.IsPostLocked(post.Id)
+			if err != nil {
+				h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if locked {
+				h.ErrorPage(w, service.ErrPostLocked.Error(), http.StatusForbidden)
+				return
+			}
+		}
 
 		commentid, err := h.Service.CommentServiceIR.CreateComment(id, user.Id, commentText)
 		if err != nil {
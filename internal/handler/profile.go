@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"forum/internal/models"
+	"forum/internal/service"
 	"forum/internal/storage"
 	"log"
 	"net/http"
@@ -31,110 +34,73 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	if !user.IsAuth {
-		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-		return
-	}
-
-	profileUser := models.User{}
-
-	posts, err := h.Service.ServicePostIR.GetMyPost(user.Id)
-	if err != nil {
-		h.ErrorPage(w, models.ErrPostNotFound.Error(), http.StatusNotFound)
-		log.Println(err.Error())
-		return
-	}
 
-	asks, err := h.Service.CommunicationServiceIR.GetAllAsks(user.Rol)
+	model, err := h.Service.GetPublicProfile(id, user, r.URL.Query().Get("tab"))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
 		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		models.ErrLog.Println(err)
+		log.Println(err.Error())
 		return
 	}
-	model := models.ProfileInfo{
-		User:        user,
-		ProfileUser: profileUser,
-		Posts:       posts,
-		Askeds:      asks,
-	}
-	if user.Rol == "king" {
-		alluser, err := h.Service.User.GetAllUser(user.Id)
+	if model.IsOwner && user.Rol == "user" {
+		offer, err := h.Service.HasPrivilege(user.Id, service.PrivilegeModeratorOffer)
 		if err != nil {
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			models.ErrLog.Println(err)
 			return
 		}
-		model.AllUsers = alluser
-		allcat, err := h.Service.ServicePostIR.GetCategories()
-		if err != nil {
-			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			models.ErrLog.Println(err)
-			return
-		}
-		model.AllCategory = allcat
-	} else if user.Rol == "moderator" {
-		waitPosts, err := h.Service.ServicePostIR.GetAllWaitPosts()
-		if err != nil {
-			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			models.ErrLog.Println(err)
-			return
-		}
-		model.WaitPosts = waitPosts
-		a, err := h.Service.CommunicationServiceIR.GetCommunication("moderator")
-		if err != nil {
-			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			models.ErrLog.Println(err)
-			return
-		}
-		model.RoleMsgs = a
-	} else if user.Rol == "admin" {
-		// if r.URL.Query().Get("show") == "modMsg" {
-		// }
-		a, err := h.Service.CommunicationServiceIR.GetCommunication("admin")
-		if err != nil {
+		model.ModeratorOffer = offer
+	}
+	if model.IsOwner {
+		if err := h.loadControlSpace(&model); err != nil {
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			models.ErrLog.Println(err)
 			return
 		}
-		model.RoleMsgs = a
 	}
 	switch r.Method {
 	case http.MethodGet:
 
-		if err := h.Temp.ExecuteTemplate(w, "profile.html", model); err != nil {
+		if err := h.render(w, "profile.html", model); err != nil {
 			log.Println(err.Error())
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 		return
 	case http.MethodPost:
-		if err := r.ParseForm(); err != nil {
+		if !user.IsAuth {
+			h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if !model.IsOwner {
+			h.ErrorPage(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+		if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
 			h.ErrorPage(w, "Bad request", http.StatusBadRequest)
 			return
 		}
 		if r.FormValue("form") == "username" { //---------------------------------------------------------------------name edit
 			username := r.FormValue("username")
 			if err := h.Service.User.UpdateUserName(user.Id, username); err != nil {
-				info := models.ProfileInfo{
-					Error:       err.Error(),
-					User:        user,
-					ProfileUser: profileUser,
-					Posts:       posts,
-				}
-				if err := h.Temp.ExecuteTemplate(w, "profile.html", info); err != nil {
+				info := model
+				info.Error = err.Error()
+				if err := h.render(w, "profile.html", info); err != nil {
 					h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 					return
 				}
 				return
 			}
 			user.Username = username
-			info := models.ProfileInfo{
-				Error:       "You have successfully update name",
-				User:        user,
-				ProfileUser: profileUser,
-				Posts:       posts,
-			}
-			if err := h.Temp.ExecuteTemplate(w, "profile.html", info); err != nil {
+			info := model
+			info.User.Username = username
+			info.ProfileUser.Username = username
+			info.Error = "You have successfully update name"
+			if err := h.render(w, "profile.html", info); err != nil {
 				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
@@ -180,12 +146,12 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 			link := fmt.Sprintf("/post/?id=%d", post_id)
 			http.Redirect(w, r, link, http.StatusSeeOther)
 		} else if r.FormValue("form") == "crPost" { //------------------------------------------------------------------ask create post
-			if user.Rol != "moderator" {
-				h.ErrorPage(w, "your role is not suitable", http.StatusBadRequest)
-				return
-			}
 			res := r.Form.Get("isCrPost")
 			inf := strings.Split(res, ",")
+			if len(inf) != 2 {
+				h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
 			action := inf[0]
 			strId := inf[1]
 			post_id, err := strconv.Atoi(strId)
@@ -193,10 +159,26 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 				models.ErrLog.Println(" Error strconv.Atoi: ", post_id)
 				return
 			}
+			post, err := h.Service.ServicePostIR.GetPostId(post_id)
+			if err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			// category moderators only approve posts in their categories
+			allowed, err := h.Service.CanModeratePost(user, post)
+			if err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				h.ErrorPage(w, "your role is not suitable", http.StatusBadRequest)
+				return
+			}
 			if err := h.Service.CommunicationServiceIR.ConfirmPost(post_id, action); err != nil {
 				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
+			h.evaluateBadges(post.UserId)
 			if err := h.Service.CreateCommunication(models.Communication{
 				FromUserId:  user.Id,
 				ForWhomRole: storage.UpRole(user.Rol),
@@ -212,25 +194,17 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 			res := r.Form.Get("isLevelUp")
 			if res == "isLevelUp" {
 				if err := h.Service.CommunicationServiceIR.AskRole(models.Communication{FromUserId: user.Id, OldRole: user.Rol}); err != nil {
-					info := models.ProfileInfo{
-						Error:       err.Error(),
-						User:        user,
-						ProfileUser: profileUser,
-						Posts:       posts,
-					}
-					if err := h.Temp.ExecuteTemplate(w, "profile.html", info); err != nil {
+					info := model
+					info.Error = err.Error()
+					if err := h.render(w, "profile.html", info); err != nil {
 						h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 						return
 					}
 					return
 				}
-				info := models.ProfileInfo{
-					Error:       "Your request for a role upgrade has been sent",
-					User:        user,
-					ProfileUser: profileUser,
-					Posts:       posts,
-				}
-				if err := h.Temp.ExecuteTemplate(w, "profile.html", info); err != nil {
+				info := model
+				info.Error = "Your request for a role upgrade has been sent"
+				if err := h.render(w, "profile.html", info); err != nil {
 					h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 					return
 				}
@@ -238,34 +212,118 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 				models.ErrLog.Println("such control with a role is not provided")
 				return
 			}
+		} else if r.FormValue("form") == "acceptOffer" { //-------------------------------------------------------------moderator offer
+			if !model.ModeratorOffer {
+				h.ErrorPage(w, "your reputation is not high enough", http.StatusForbidden)
+				return
+			}
+			if err := h.Service.CommunicationServiceIR.UpUserRole(user.Id, "moderator"); err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if err := h.Service.CommunicationServiceIR.DeleteAskRole(user.Id); err != nil {
+				models.ErrLog.Println(err)
+			}
+			link := fmt.Sprintf("/profile/?id=%d", user.Id)
+			http.Redirect(w, r, link, http.StatusSeeOther)
 		} else if r.FormValue("form") == "bio" { //---------------------------------------------------------------------bio edit
-		} else if r.FormValue("form") == "ava" { //---------------------------------------------------------------------ava edit
-		} else if r.FormValue("form") == "delCat" { //---------------------------------------------------------------------ava edit
+			if err := h.Service.UpdateBio(user.Id, r.FormValue("bio")); err != nil {
+				info := model
+				info.Error = err.Error()
+				if err := h.render(w, "profile.html", info); err != nil {
+					h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+				return
+			}
+			link := fmt.Sprintf("/profile/?id=%d", user.Id)
+			http.Redirect(w, r, link, http.StatusSeeOther)
+		} else if r.FormValue("form") == "ava" || r.FormValue("form") == "banner" { //----------------------------------ava edit
+			file, _, err := r.FormFile("image")
+			if err != nil {
+				h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			if _, err := checkImageSignature(file); err != nil {
+				h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.FormValue("form") == "ava" {
+				err = h.Service.UpdateAvatar(user.Id, file)
+			} else {
+				err = h.Service.UpdateBanner(user.Id, file)
+			}
+			if err != nil {
+				info := model
+				info.Error = err.Error()
+				if err := h.render(w, "profile.html", info); err != nil {
+					h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+				return
+			}
+			link := fmt.Sprintf("/profile/?id=%d", user.Id)
+			http.Redirect(w, r, link, http.StatusSeeOther)
+		} else if r.FormValue("form") == "delCat" { //---------------------------------------------------------------------delete category
 			if user.Rol != "king" {
 				h.ErrorPage(w, "your role is not suitable", http.StatusBadRequest)
 				return
 			}
-			nameCategory := r.FormValue("name")
-			if err := h.Service.ServicePostIR.DeleteCategory(nameCategory); err != nil {
-				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			archived, err := h.Service.DeleteCategory(r.FormValue("name"), r.FormValue("move_to"))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+					return
+				}
+				h.renderProfileError(w, model, err)
+				return
+			}
+			if archived {
+				h.renderProfileError(w, model, errors.New("The category still has posts, it was archived instead"))
+				return
+			}
+			link := fmt.Sprintf("/profile/?id=%d", user.Id)
+			http.Redirect(w, r, link, http.StatusSeeOther)
+		} else if r.FormValue("form") == "addCat" || r.FormValue("form") == "editCat" { //-----------------------------add or edit category
+			if user.Rol != "king" {
+				h.ErrorPage(w, "your role is not suitable", http.StatusBadRequest)
+				return
+			}
+			position, _ := strconv.Atoi(r.FormValue("position"))
+			category := models.Category{
+				Name:        r.FormValue("text"),
+				Description: r.FormValue("description"),
+				Icon:        strings.TrimSpace(r.FormValue("icon")),
+				Parent:      r.FormValue("parent"),
+				Position:    position,
+				Archived:    r.FormValue("archived") == "on",
+			}
+			if r.FormValue("form") == "addCat" {
+				err = h.Service.AddCategory(category)
+			} else {
+				err = h.Service.UpdateCategory(category)
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				h.renderProfileError(w, model, err)
 				return
 			}
 			link := fmt.Sprintf("/profile/?id=%d", user.Id)
 			http.Redirect(w, r, link, http.StatusSeeOther)
-		} else if r.FormValue("form") == "addCat" { //---------------------------------------------------------------------ava edit
+		} else if r.FormValue("form") == "catMod" { //----------------------------------------------------------------category moderators
 			if user.Rol != "king" {
 				h.ErrorPage(w, "your role is not suitable", http.StatusBadRequest)
 				return
 			}
-			nameCategory := r.FormValue("text")
-			for _, exist := range model.AllCategory {
-				if nameCategory == exist.Name {
-					h.ErrorPage(w, "Category allready exists", http.StatusBadRequest)
+			add := r.FormValue("action") == "add"
+			if err := h.Service.SetCategoryModerator(r.FormValue("name"), r.FormValue("username"), add); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 					return
 				}
-			}
-			if err := h.Service.ServicePostIR.AddCategory(nameCategory); err != nil {
-				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				h.renderProfileError(w, model, err)
 				return
 			}
 			link := fmt.Sprintf("/profile/?id=%d", user.Id)
@@ -297,6 +355,10 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 			}
 			res := r.Form.Get("isLevel")
 			inf := strings.Split(res, ",")
+			if len(inf) != 3 {
+				h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
 			strId := inf[2]
 			oldRole := inf[1]
 			updown := inf[0]
@@ -309,18 +371,14 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 
 			id, err := strconv.Atoi(strId)
 			if err != nil {
-				models.ErrLog.Println(" Error strconv.Atoi: ", id)
+				h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 				return
 			}
 
 			if err := h.Service.CommunicationServiceIR.UpUserRole(id, newRole); err != nil {
-				info := models.ProfileInfo{
-					Error:       err.Error(),
-					User:        user,
-					ProfileUser: profileUser,
-					Posts:       posts,
-				}
-				if err := h.Temp.ExecuteTemplate(w, "profile.html", info); err != nil {
+				info := model
+				info.Error = err.Error()
+				if err := h.render(w, "profile.html", info); err != nil {
 					h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 					return
 				}
@@ -334,20 +392,20 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, link, http.StatusSeeOther)
 		} else if r.FormValue("form") == "roleUp" { //---------------------------------------------------------------------up role
 			res := r.Form.Get("isLevelUp")
-			if strings.Contains(res, "accept") {
+			if len(res) < len("accept") {
+				h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if strings.HasPrefix(res, "accept") {
 				id, err := strconv.Atoi(res[6:])
 				if err != nil {
-					models.ErrLog.Println(" Error strconv.Atoi: ", id)
+					h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 					return
 				}
 				if err := h.Service.CommunicationServiceIR.UpUserRole(id, user.Rol); err != nil {
-					info := models.ProfileInfo{
-						Error:       err.Error(),
-						User:        user,
-						ProfileUser: profileUser,
-						Posts:       posts,
-					}
-					if err := h.Temp.ExecuteTemplate(w, "profile.html", info); err != nil {
+					info := model
+					info.Error = err.Error()
+					if err := h.render(w, "profile.html", info); err != nil {
 						h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 						return
 					}
@@ -363,10 +421,10 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 				}
 				link := fmt.Sprintf("/profile/?id=%d", user.Id)
 				http.Redirect(w, r, link, http.StatusSeeOther)
-			} else if strings.Contains(res, "refuse") {
+			} else if strings.HasPrefix(res, "refuse") {
 				id, err := strconv.Atoi(res[6:])
 				if err != nil {
-					models.ErrLog.Println(" Error strconv.Atoi: ", id)
+					h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 					return
 				}
 				if err := h.Service.CommunicationServiceIR.DeleteAskRole(id); err != nil {
@@ -393,3 +451,42 @@ func (h *Handler) profilePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// loadControlSpace fills the staff panel shown on the owner's own profile.
+func (h *Handler) loadControlSpace(model *models.ProfileInfo) error {
+	var err error
+	if model.Askeds, err = h.Service.CommunicationServiceIR.GetAllAsks(model.User.Rol); err != nil {
+		return err
+	}
+	switch model.User.Rol {
+	case "king":
+		if model.AllUsers, err = h.Service.User.GetAllUser(model.User.Id); err != nil {
+			return err
+		}
+		model.AllCategory, err = h.Service.GetCategories()
+	case "moderator":
+		if model.WaitPosts, err = h.Service.ServicePostIR.GetAllWaitPosts(); err != nil {
+			return err
+		}
+		if model.RoleMsgs, err = h.Service.CommunicationServiceIR.GetCommunication("moderator"); err != nil {
+			return err
+		}
+		model.DMReports, err = h.Service.GetOpenDMReports()
+	case "admin":
+		model.RoleMsgs, err = h.Service.CommunicationServiceIR.GetCommunication("admin")
+	case "user":
+		if model.ModeratedCategories, err = h.Service.GetModeratedCategories(model.User.Id); err != nil || len(model.ModeratedCategories) == 0 {
+			return err
+		}
+		model.WaitPosts, err = h.Service.GetWaitPostsByModerator(model.User.Id)
+	}
+	return err
+}
+
+// renderProfileError shows the profile again with err above the forms.
+func (h *Handler) renderProfileError(w http.ResponseWriter, model models.ProfileInfo, err error) {
+	model.Error = err.Error()
+	if err := h.render(w, "profile.html", model); err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
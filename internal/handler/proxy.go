@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"forum/internal/models"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwarded believes the X-Forwarded-For and X-Forwarded-Proto headers of
+// requests coming from the proxies in tls.trustedproxies: RemoteAddr becomes
+// the address of the client, so the rate limits and the risk logs see it and
+// not the proxy, and a request the proxy took over HTTPS counts as one. The
+// headers of anybody else are ignored, as they are easily made up.
+func (h *Handler) forwarded(next http.Handler) http.Handler {
+	var trusted []netip.Prefix
+	for _, proxy := range h.Config.TLS.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			models.ErrLog.Printf("tls: trusted proxy %q: %s", proxy, err)
+			continue
+		}
+		trusted = append(trusted, prefix)
+	}
+	if len(trusted) == 0 {
+		return next
+	}
+	isTrusted := func(addr string) bool {
+		ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+		if err != nil {
+			return false
+		}
+		ip = ip.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isTrusted(clientIP(r.RemoteAddr)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		if client := forwardedFor(r.Header.Values("X-Forwarded-For"), isTrusted); client != "" {
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			r.URL.Scheme = "https"
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor is the client in the X-Forwarded-For chain: the last address
+// not added by a trusted proxy, as every proxy appends the one it saw.
+func forwardedFor(headers []string, isTrusted func(string) bool) string {
+	var hops []string
+	for _, header := range headers {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+		if !isTrusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// parsePrefix reads a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// secure tells whether the browser reached the forum over HTTPS, itself or
+// through a trusted proxy.
+func secure(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	svr "forum/internal/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarded(t *testing.T) {
+	h := &Handler{Config: svr.Config{TLS: svr.TLSConfig{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1", "bogus"}}}}
+	var got *http.Request
+	srv := h.forwarded(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	serve := func(remote, forwardedFor, proto string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		r.Header.Set("X-Forwarded-Proto", proto)
+		srv.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("10.1.2.3:5000", "203.0.113.7", "https")
+	require.Equal(t, "203.0.113.7", clientIP(got.RemoteAddr))
+	require.True(t, secure(got))
+
+	serve("192.0.2.1:5000", "1.1.1.1, 203.0.113.7, 10.0.0.5", "http")
+	require.Equal(t, "203.0.113.7", clientIP(got.RemoteAddr), "a made up first hop is not believed")
+	require.False(t, secure(got))
+
+	serve("198.51.100.9:5000", "203.0.113.7", "https")
+	require.Equal(t, "198.51.100.9", clientIP(got.RemoteAddr), "only trusted proxies are believed")
+	require.False(t, secure(got))
+
+	serve("10.1.2.3:5000", "", "")
+	require.Equal(t, "10.1.2.3", clientIP(got.RemoteAddr))
+
+	serve("10.1.2.3:5000", "2001:db8::1", "")
+	require.Equal(t, "2001:db8::1", clientIP(got.RemoteAddr))
+}
@@ -1,21 +1,43 @@
 -- atomic rate limit script
+--
+-- KEYS are counters, ARGV holds the limit and the window in seconds of each.
+-- Nothing is counted unless every counter is under its limit. Returns
+-- {allowed, limit, remaining, reset}: when allowed, the limit and what is
+-- left of the tightest counter and the seconds until it resets; when not,
+-- the seconds until every full counter has reset.
 
+local blocked, wait = false, 0
 for i = 1, #KEYS do
     local current = tonumber(redis.call("GET", KEYS[i]) or "0")
     local limit = tonumber(ARGV[(i - 1) * 2 + 1])
 
     if current >= limit then
-        return 0
+        local ttl = redis.call("TTL", KEYS[i])
+        if ttl < 0 then
+            ttl = tonumber(ARGV[(i - 1) * 2 + 2])
+        end
+        blocked = true
+        wait = math.max(wait, ttl)
     end
 end
+if blocked then
+    return {0, 0, 0, wait}
+end
 
+local tightest, remaining, reset = 0, -1, 0
 for i = 1, #KEYS do
     local count = redis.call("INCR", KEYS[i])
+    local limit = tonumber(ARGV[(i - 1) * 2 + 1])
     local ttl = tonumber(ARGV[(i - 1) * 2 + 2])
 
     if count == 1 then
         redis.call("EXPIRE", KEYS[i], ttl)
+    else
+        ttl = redis.call("TTL", KEYS[i])
+    end
+    if remaining < 0 or limit - count < remaining then
+        tightest, remaining, reset = limit, limit - count, ttl
     end
 end
 
-return 1
+return {1, tightest, remaining, reset}
@@ -3,6 +3,11 @@ package handler
 import (
 	"context"
 	_ "embed"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -19,8 +24,35 @@ type Rule struct {
 	Window time.Duration
 }
 
+// rateResult is what a limit check found. Limit, Remaining and Reset are of
+// the tightest counter when allowed; when not, Reset is how long to wait.
+type rateResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
 func (h *Handler) CheckAtomic(rdb *redis.Client, rules []Rule) (bool, error) {
-	ctx := context.Background()
+	return takeRate(rdb, rules).Allowed, nil
+}
+
+// takeRate counts a request against every rule in Redis. When Redis is
+// unavailable the counting goes on in memory, per process.
+func takeRate(rdb *redis.Client, rules []Rule) rateResult {
+	if rdb != nil {
+		res, err := redisRate(rdb, rules)
+		if err == nil {
+			return res
+		}
+		redisDown.warn(err)
+	}
+	return localRates.take(rules, time.Now())
+}
+
+func redisRate(rdb *redis.Client, rules []Rule) (rateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 
 	keys := make([]string, 0, len(rules))
 	args := make([]interface{}, 0, len(rules)*2)
@@ -30,10 +62,223 @@ func (h *Handler) CheckAtomic(rdb *redis.Client, rules []Rule) (bool, error) {
 		args = append(args, r.Limit, int(r.Window.Seconds()))
 	}
 
-	res, err := script.Run(ctx, rdb, keys, args...).Int()
+	res, err := script.Run(ctx, rdb, keys, args...).Int64Slice()
 	if err != nil {
-		return false, err
+		return rateResult{}, err
+	}
+	if len(res) != 4 {
+		return rateResult{}, redis.Nil
+	}
+	return rateResult{
+		Allowed:   res[0] == 1,
+		Limit:     int(res[1]),
+		Remaining: int(res[2]),
+		Reset:     time.Duration(res[3]) * time.Second,
+	}, nil
+}
+
+// redisWarning logs Redis failures of the limiter at most once a minute.
+type redisWarning struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+var redisDown redisWarning
+
+func (r *redisWarning) warn(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.last) < time.Minute {
+		return
+	}
+	r.last = time.Now()
+	models.ErrLog.Println("rate limit: redis unavailable, counting in memory:", err)
+}
+
+// memoryRates are fixed window counters like the Lua script keeps, for when
+// Redis is down.
+type memoryRates struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+	swept    time.Time
+}
+
+type memoryCounter struct {
+	count   int
+	expires time.Time
+}
+
+var localRates = &memoryRates{counters: map[string]*memoryCounter{}}
+
+func (m *memoryRates) take(rules []Rule, now time.Time) rateResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.swept) > time.Minute {
+		for key, c := range m.counters {
+			if !now.Before(c.expires) {
+				delete(m.counters, key)
+			}
+		}
+		m.swept = now
+	}
+
+	var wait time.Duration
+	for _, rule := range rules {
+		c := m.counters[rule.Key]
+		if c != nil && now.Before(c.expires) && c.count >= rule.Limit {
+			wait = max(wait, c.expires.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return rateResult{Reset: (wait + time.Second - 1).Truncate(time.Second)}
 	}
 
-	return res == 1, nil
+	res := rateResult{Allowed: true, Remaining: -1}
+	for _, rule := range rules {
+		c := m.counters[rule.Key]
+		if c == nil || !now.Before(c.expires) {
+			c = &memoryCounter{expires: now.Add(rule.Window)}
+			m.counters[rule.Key] = c
+		}
+		c.count++
+		if left := rule.Limit - c.count; res.Remaining < 0 || left < res.Remaining {
+			res.Limit, res.Remaining, res.Reset = rule.Limit, left, c.expires.Sub(now).Round(time.Second)
+		}
+	}
+	return res
+}
+
+// Rate is at most Limit requests in Window.
+type Rate struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RatePolicy limits the writes to one route: a short Burst against floods
+// and a long Sustained against slow abuse. Both count per IP and, once
+// signed in, per user as well. Reads counts GET requests too, for routes
+// that act on a GET, like the callbacks of login providers.
+type RatePolicy struct {
+	Burst     Rate
+	Sustained Rate
+	Reads     bool
+}
+
+// defaultRatePolicy limits the writes to routes missing in ratePolicies, so
+// a new route is never left unlimited.
+var defaultRatePolicy = RatePolicy{Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}}
+
+// ratePolicies are by route, as the route is registered in InitRoutes.
+// Reads are only limited where Reads is set.
+var ratePolicies = map[string]RatePolicy{
+	"/signup":      {Burst: Rate{3, time.Minute}, Sustained: Rate{10, time.Hour}},
+	"/signin":      {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/verify":      {Burst: Rate{5, time.Minute}, Sustained: Rate{30, time.Hour}},
+	"/verify/totp": {Burst: Rate{5, time.Minute}, Sustained: Rate{30, time.Hour}},
+	"/passkey3fa":  {Burst: Rate{5, time.Minute}, Sustained: Rate{30, time.Hour}},
+	"/forgot":      {Burst: Rate{5, time.Minute}, Sustained: Rate{20, time.Hour}},
+	"/reset":       {Burst: Rate{5, time.Minute}, Sustained: Rate{20, time.Hour}},
+	"/logout":      {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}, Reads: true},
+
+	"/auth/":                 {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}, Reads: true},
+	"/oauth2callback-google": {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}, Reads: true},
+	"/oauth2callback":        {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}, Reads: true},
+	"/login/github/callback": {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}, Reads: true},
+	"/security/not-me":       {Burst: Rate{5, time.Minute}, Sustained: Rate{20, time.Hour}},
+
+	"/webauthn/register/start":     {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/webauthn/register/finish":    {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/webauthn/login/start":        {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/webauthn/login/finish":       {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/webauthn/sudo/start":         {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/webauthn/sudo/finish":        {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+	"/webauthn/credentials/delete": {Burst: Rate{10, time.Minute}, Sustained: Rate{60, time.Hour}},
+
+	"/post/create":          {Burst: Rate{3, time.Minute}, Sustained: Rate{30, time.Hour}},
+	"/post/drafts":          {Burst: Rate{20, time.Minute}, Sustained: Rate{600, time.Hour}},
+	"/post/moderate":        {Burst: Rate{30, time.Minute}, Sustained: Rate{500, time.Hour}},
+	"/announcement/dismiss": {Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/change/post/":         {Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/delete/post/":         {Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/comment/":             {Burst: Rate{5, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/delete/comment/":      {Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/emotion/post/":        {Burst: Rate{30, time.Minute}, Sustained: Rate{500, time.Hour}},
+	"/emotion/comment/":     {Burst: Rate{30, time.Minute}, Sustained: Rate{500, time.Hour}},
+	"/poll/vote":            {Burst: Rate{10, time.Minute}, Sustained: Rate{200, time.Hour}},
+	"/messages/":            {Burst: Rate{10, time.Minute}, Sustained: Rate{200, time.Hour}},
+	"/messages/report":      {Burst: Rate{5, time.Minute}, Sustained: Rate{30, time.Hour}},
+	"/profile/":             {Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/settings/":            {Burst: Rate{10, time.Minute}, Sustained: Rate{100, time.Hour}},
+	"/security":             {Burst: Rate{3, time.Minute}, Sustained: Rate{10, time.Hour}},
+	"/admin/risk":           {Burst: Rate{30, time.Minute}, Sustained: Rate{500, time.Hour}},
+	"/admin/jobs":           {Burst: Rate{30, time.Minute}, Sustained: Rate{500, time.Hour}},
+}
+
+// rules are the counters a request to route counts against.
+func (p RatePolicy) rules(route, ip string, user models.User) []Rule {
+	who := []string{"ip:" + ip}
+	if user.IsAuth {
+		who = append(who, "user:"+strconv.Itoa(user.Id))
+	}
+	rules := make([]Rule, 0, len(who)*2)
+	for _, w := range who {
+		rules = append(rules,
+			Rule{Key: "rate:route:" + route + ":" + w + ":burst", Limit: p.Burst.Limit, Window: p.Burst.Window},
+			Rule{Key: "rate:route:" + route + ":" + w + ":sustained", Limit: p.Sustained.Limit, Window: p.Sustained.Window},
+		)
+	}
+	return rules
+}
+
+// rateLimit applies ratePolicies in front of every route, and
+// defaultRatePolicy to the writes of the others. Answers carry RateLimit-*
+// headers; a request over the limit gets 429 with Retry-After. Staff are not
+// limited.
+func (h *Handler) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := h.Mux.Handler(r)
+		policy, ok := ratePolicies[route]
+		if !ok {
+			policy = defaultRatePolicy
+		}
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if read && (!policy.Reads || r.Method == http.MethodOptions) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := h.requestUser(r)
+		if isStaff(user) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res := takeRate(storage.RDB, policy.rules(route, clientIP(r.RemoteAddr), user))
+		reset := strconv.Itoa(int(res.Reset.Seconds()))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", reset)
+			w.Header().Set("RateLimit-Remaining", "0")
+			w.Header().Set("RateLimit-Reset", reset)
+			h.ErrorPage(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", reset)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestUser is the signed in user of r, if any, as middleWareGetUser
+// finds them.
+func (h *Handler) requestUser(r *http.Request) models.User {
+	c, err := r.Cookie("token")
+	if err != nil {
+		return models.User{}
+	}
+	user, err := h.Service.GetUserByToken(c.Value)
+	if err != nil || user.ExpiresAt.Before(time.Now()) {
+		return models.User{}
+	}
+	user.IsAuth = true
+	return user
 }
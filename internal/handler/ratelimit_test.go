@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRates(t *testing.T) {
+	rates := &memoryRates{counters: map[string]*memoryCounter{}}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rules := []Rule{{Key: "burst", Limit: 2, Window: time.Minute}, {Key: "sustained", Limit: 3, Window: time.Hour}}
+
+	res := rates.take(rules, now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+	require.Equal(t, 2, res.Limit)
+	require.True(t, rates.take(rules, now).Allowed)
+
+	res = rates.take(rules, now.Add(10*time.Second))
+	require.False(t, res.Allowed)
+	require.Equal(t, 50*time.Second, res.Reset)
+
+	// the burst window is over, the sustained one is not
+	res = rates.take(rules, now.Add(time.Minute))
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 3, res.Limit)
+	res = rates.take(rules, now.Add(2*time.Minute))
+	require.False(t, res.Allowed)
+	require.Equal(t, 58*time.Minute, res.Reset)
+	require.Equal(t, 3, rates.counters["sustained"].count, "a refused request is not counted")
+}
+
+func TestRateLimit(t *testing.T) {
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir("../.."))
+	defer os.Chdir(wd)
+
+	h := &Handler{Mux: http.NewServeMux()}
+	h.Mux.HandleFunc("/poll/vote", func(w http.ResponseWriter, r *http.Request) {})
+	h.Mux.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {})
+	srv := h.rateLimit(h.Mux)
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = "192.0.2.9:4000"
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	limit := ratePolicies["/poll/vote"].Burst.Limit
+	for i := 1; i <= limit; i++ {
+		w := send(http.MethodPost, "/poll/vote")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+	}
+	w := send(http.MethodPost, "/poll/vote")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, send(http.MethodGet, "/poll/vote").Code, "reads are not limited")
+	w = send(http.MethodPost, "/about")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "10", w.Header().Get("RateLimit-Limit"), "the default policy")
+	require.Empty(t, send(http.MethodGet, "/about").Header().Get("RateLimit-Limit"))
+
+	h.Mux.HandleFunc("/auth/", func(w http.ResponseWriter, r *http.Request) {})
+	require.NotEmpty(t, send(http.MethodGet, "/auth/google/callback").Header().Get("RateLimit-Limit"), "callbacks act on GET")
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// render executes the template name with data into w, with the CSRF token
+// and the CSP nonce of the request in it.
+func (h *Handler) render(w http.ResponseWriter, name string, data any) error {
+	var buf bytes.Buffer
+	if err := h.Temp.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	_, err := w.Write(fillPage(w, buf.Bytes()))
+	return err
+}
+
+// fillPage puts the CSRF token and the CSP nonce of the request in place of
+// their placeholders in page. A browser without a CSRF token gets its cookie
+// here, so it is called before the status is written.
+func fillPage(w http.ResponseWriter, page []byte) []byte {
+	token, nonce := "", ""
+	if cw, ok := unwrapWriter[*csrfWriter](w); ok {
+		token = cw.token
+		if cw.fresh {
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			cw.fresh = false
+		}
+	}
+	if nw, ok := unwrapWriter[*nonceWriter](w); ok {
+		nonce = nw.nonce
+	}
+	page = bytes.ReplaceAll(page, []byte(csrfPlaceholder), []byte(token))
+	return bytes.ReplaceAll(page, []byte(noncePlaceholder), []byte(nonce))
+}
+
+// unwrapWriter finds the T the middlewares wrapped around w.
+func unwrapWriter[T http.ResponseWriter](w http.ResponseWriter) (T, bool) {
+	for {
+		if v, ok := w.(T); ok {
+			return v, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		w = u.Unwrap()
+	}
+}
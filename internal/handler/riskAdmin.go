@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// riskDashboard serves /admin/risk: users by risk level, or with ?user= the
+// timeline of one user and the forms to override their level.
+func (h *Handler) riskDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/risk" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	if !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Has("user") {
+			userID, err := strconv.Atoi(r.URL.Query().Get("user"))
+			if err != nil {
+				h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			h.renderRiskTimeline(w, user, userID, "")
+			return
+		}
+		level := r.URL.Query().Get("level")
+		users, err := h.Service.ListRiskUsers(user, level)
+		if err != nil {
+			h.riskAdminError(w, err)
+			return
+		}
+		if err := h.render(w, "riskAdmin.html", map[string]any{
+			"Users": users,
+			"Level": level,
+			"Now":   time.Now(),
+		}); err != nil {
+			h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		h.overrideRisk(w, r, user)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) overrideRisk(w http.ResponseWriter, r *http.Request, user models.User) {
+	if err := r.ParseForm(); err != nil {
+		h.ErrorPage(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.Atoi(r.FormValue("user"))
+	if err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	note := r.FormValue("note")
+	switch r.FormValue("action") {
+	case "pin":
+		period, perr := time.ParseDuration(r.FormValue("period"))
+		if perr != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		err = h.Service.PinRisk(user, userID, r.FormValue("level"), period, note)
+	case "unpin":
+		err = h.Service.UnpinRisk(user, userID, note)
+	case "reset":
+		err = h.Service.ResetRisk(user, userID, note)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrRiskPinLevel) || errors.Is(err, service.ErrRiskPinPeriod) || errors.Is(err, service.ErrOverrideNote) {
+		h.renderRiskTimeline(w, user, userID, err.Error())
+		return
+	}
+	if err != nil {
+		h.riskAdminError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/risk?user="+strconv.Itoa(userID), http.StatusSeeOther)
+}
+
+func (h *Handler) renderRiskTimeline(w http.ResponseWriter, user models.User, userID int, message string) {
+	assessment, events, overrides, err := h.Service.GetRiskTimeline(user, userID)
+	if err != nil {
+		h.riskAdminError(w, err)
+		return
+	}
+	target, err := h.Service.User.GetUserById(userID)
+	if err != nil {
+		h.riskAdminError(w, err)
+		return
+	}
+	if err := h.render(w, "riskAdmin.html", map[string]any{
+		"Target":     target,
+		"Assessment": assessment,
+		"Pinned":     assessment.Pinned(time.Now()),
+		"Events":     events,
+		"Overrides":  overrides,
+		"Message":    message,
+	}); err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// riskExport serves /admin/risk/export?user=, every event of the user as
+// CSV.
+func (h *Handler) riskExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	if !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	userID, err := strconv.Atoi(r.URL.Query().Get("user"))
+	if err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	events, err := h.Service.ExportEvents(user, userID)
+	if err != nil {
+		h.riskAdminError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="risk-events-%d.csv"`, userID))
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "time", "status", "method", "reason", "ip", "country", "city", "asn", "device"})
+	for _, event := range events {
+		out.Write([]string{
+			strconv.Itoa(event.ID),
+			event.Time.Format(time.RFC3339),
+			strconv.FormatBool(event.Status),
+			event.Method,
+			event.Reason,
+			event.IP,
+			event.Geo,
+			event.City,
+			event.ASN,
+			event.Device,
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		models.ErrLog.Println(err)
+	}
+}
+
+func (h *Handler) riskAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotAdmin):
+		h.ErrorPage(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, service.ErrRiskPinLevel):
+		h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+	default:
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
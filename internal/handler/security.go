@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"net/http"
+)
+
+// securedMessage is shown once the account is secured after "this wasn't me".
+const securedMessage = "Your account is secured: you are signed out everywhere and your password no longer works. Set a new one with the link we mailed you."
+
+// signInHistory is how many sign in attempts the security page lists.
+const signInHistory = 50
+
+// security serves /security: the latest sign ins of the user and the
+// "this wasn't me" button.
+func (h *Handler) security(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/security" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	user, _ := r.Context().Value("user").(models.User)
+	if !user.IsAuth {
+		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.renderSecurity(w, user)
+	case http.MethodPost:
+		if err := h.Service.SecureAccount(user.Id); err != nil {
+			models.ErrLog.Println(err)
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		h.accountSecured(w, r, user.Id)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// securityNotMe is where the "this wasn't me" link of a security alert
+// points to. The user is likely signed out, so the link is enough. GET only
+// asks to confirm, as mail scanners open every link; the button POSTs the
+// token back and that secures the account.
+func (h *Handler) securityNotMe(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if err := h.Service.CheckNotMeToken(token); err != nil {
+			h.notMeFailed(w, err)
+			return
+		}
+		if err := h.render(w, "notMe.html", map[string]any{"Token": token}); err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		userID, err := h.Service.SecureAccountByToken(r.FormValue("token"))
+		if err != nil {
+			h.notMeFailed(w, err)
+			return
+		}
+		h.accountSecured(w, r, userID)
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) notMeFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrTokenInvalid) {
+		h.renderSignIn(w, models.InfoSign{Error: err.Error()})
+		return
+	}
+	models.ErrLog.Println(err)
+	h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func (h *Handler) accountSecured(w http.ResponseWriter, r *http.Request, userID int) {
+	if err := h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: userID,
+		IP:     clientIP(r.RemoteAddr),
+		Device: getDevice(r),
+		Status: true,
+		Reason: "account secured by user"}); err != nil {
+		models.ErrLog.Println(err)
+	}
+	http.SetCookie(w, &http.Cookie{Name: "token", Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	h.renderSignIn(w, models.InfoSign{Error: securedMessage})
+}
+
+func (h *Handler) renderSecurity(w http.ResponseWriter, user models.User) {
+	signIns, err := h.Service.GetSignInsByUserID(user.Id, signInHistory)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Email":   user.Email,
+		"SignIns": signIns,
+	}
+	if err := h.render(w, "security.html", data); err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+	}
+}
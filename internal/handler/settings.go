@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"forum/internal/models"
+	"forum/internal/service"
 	"net/http"
+	"strconv"
 )
 
 func (h *Handler) settings(w http.ResponseWriter, r *http.Request) {
@@ -22,16 +26,277 @@ func (h *Handler) settings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Temp.ExecuteTemplate(w, "settings.html", map[string]any{
-		"Email":      user.Email,
-		"HasPasskey": h.Service.Auth.HasWebAuthn(user.Id),
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			h.ErrorPage(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.FormValue("form") {
+		case "digest":
+			h.saveDigestSettings(w, r, user)
+		case "privacy":
+			h.savePrivacySettings(w, r, user)
+		case "sudo":
+			h.sudoWithPassword(w, r, user)
+		case "password":
+			h.changePassword(w, r, user)
+		case "email":
+			h.requestEmailChange(w, r, user)
+		case "unlink":
+			if h.requireSudo(w, user) {
+				h.unlinkIdentity(w, r, user)
+			}
+		case "totp_start":
+			if h.requireSudo(w, user) {
+				h.startTOTPEnrollment(w, user)
+			}
+		case "totp_confirm":
+			h.confirmTOTP(w, r, user)
+		case "totp_disable":
+			h.disableTOTP(w, r, user)
+		case "totp_recovery":
+			h.regenerateRecoveryCodes(w, r, user)
+		case "second_factor":
+			if h.requireSudo(w, user) {
+				h.saveSecondFactor(w, r, user)
+			}
+		case "passkey_rename":
+			h.renamePasskey(w, r, user)
+		case "resend_verification":
+			h.resendVerification(w, r, user)
+		default:
+			h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		}
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderSettings(w, user, "")
+}
+
+func (h *Handler) renderSettings(w http.ResponseWriter, user models.User, message string) {
+	h.renderSettingsWith(w, user, message, nil)
+}
+
+// renderSettingsWith adds extra to the page data, for what is shown only
+// once: the QR code of an enrollment, freshly made recovery codes.
+func (h *Handler) renderSettingsWith(w http.ResponseWriter, user models.User, message string, extra map[string]any) {
+	digest, err := h.Service.GetDigestPreferences(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	categories, err := h.Service.GetCategories()
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	privacy, err := h.Service.GetPrivacy(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	identities, err := h.Service.GetIdentities(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	linked := make(map[string]models.UserIdentity, len(identities))
+	for _, identity := range identities {
+		linked[identity.Provider] = identity
+	}
+	totp, err := h.Service.GetTOTPStatus(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	passkeys, err := h.Service.Auth.ListCredentials(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sudo, err := h.Service.InSudo(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hasPassword, err := h.Service.HasPassword(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pendingEmail, err := h.Service.GetPendingEmail(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	followed := make(map[string]bool, len(digest.FollowedCategories))
+	for _, name := range digest.FollowedCategories {
+		followed[name] = true
+	}
+
+	data := map[string]any{
+		"Email":         user.Email,
+		"EmailVerified": user.EmailVerified,
+		"PendingEmail":  pendingEmail,
+		"Sudo":          sudo,
+		"HasPassword":   hasPassword,
+		"HasPasskey":    len(passkeys) > 0,
+		"Passkeys":      passkeys,
+		"Message":       message,
+		"Digest":        digest,
+		"AllCategory":   categories,
+		"Followed":      followed,
+		"Privacy":       privacy,
+		"Providers":     h.Service.OAuthProviders(),
+		"Linked":        linked,
+		"TOTP":          totp,
+		"TOTPAvailable": h.Service.TOTPAvailable(),
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+	if err := h.render(w, "settings.html", data); err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) saveDigestSettings(w http.ResponseWriter, r *http.Request, user models.User) {
+	digest, err := h.Service.GetDigestPreferences(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	digest.Digest = r.FormValue("digest")
+	digest.IncludeNotifications = r.FormValue("notifications") == "on"
+	digest.IncludeReplies = r.FormValue("replies") == "on"
+	digest.IncludeCategories = r.FormValue("categories") == "on"
+	digest.FollowedCategories = r.Form["follow"]
+
+	categories, err := h.Service.GetCategories()
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, name := range digest.FollowedCategories {
+		if !inSlice(name, categories) {
+			h.ErrorPage(w, "Not exist category", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.Service.SaveDigestPreferences(digest); err != nil {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	h.renderSettings(w, user, "Email preferences saved")
+}
+
+func (h *Handler) savePrivacySettings(w http.ResponseWriter, r *http.Request, user models.User) {
+	if err := h.Service.SavePrivacy(models.Privacy{
+		UserID:        user.Id,
+		PublicProfile: r.FormValue("public_profile") == "on",
+		ShowEmail:     r.FormValue("show_email") == "on",
+		ShowComments:  r.FormValue("show_comments") == "on",
+		ShowLiked:     r.FormValue("show_liked") == "on",
 	}); err != nil {
 		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	h.renderSettings(w, user, "Privacy settings saved")
+}
+
+func (h *Handler) unlinkIdentity(w http.ResponseWriter, r *http.Request, user models.User) {
+	err := h.Service.UnlinkIdentity(user.Id, r.FormValue("provider"))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrLastLogin) {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderSettings(w, user, "The account is unlinked")
+}
+
+func (h *Handler) startTOTPEnrollment(w http.ResponseWriter, user models.User) {
+	enrollment, err := h.Service.StartTOTPEnrollment(user)
+	if errors.Is(err, service.ErrTOTPEnabled) || errors.Is(err, service.ErrTOTPUnavailable) {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderSettingsWith(w, user, "", map[string]any{"Enrollment": enrollment})
+}
+
+func (h *Handler) confirmTOTP(w http.ResponseWriter, r *http.Request, user models.User) {
+	codes, err := h.Service.ConfirmTOTP(user.Id, r.FormValue("code"))
+	if errors.Is(err, service.ErrInvalidTOTP) {
+		// the pending secret is shown again so the user can retry
+		enrollment, err := h.Service.StartTOTPEnrollment(user)
+		if err != nil {
+			h.renderSettings(w, user, service.ErrInvalidTOTP.Error())
+			return
+		}
+		h.renderSettingsWith(w, user, service.ErrInvalidTOTP.Error(), map[string]any{"Enrollment": enrollment})
+		return
+	}
+	if errors.Is(err, service.ErrTOTPEnabled) {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderSettingsWith(w, user, "The authenticator app is enabled", map[string]any{"RecoveryCodes": codes})
+}
+
+func (h *Handler) disableTOTP(w http.ResponseWriter, r *http.Request, user models.User) {
+	err := h.Service.DisableTOTP(user.Id, r.FormValue("code"))
+	if errors.Is(err, service.ErrInvalidTOTP) {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderSettings(w, user, "The authenticator app is disabled")
 }
 
+func (h *Handler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, user models.User) {
+	codes, err := h.Service.RegenerateRecoveryCodes(user.Id, r.FormValue("code"))
+	if errors.Is(err, service.ErrInvalidTOTP) {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderSettingsWith(w, user, "New recovery codes are ready, the old ones no longer work", map[string]any{"RecoveryCodes": codes})
+}
+
+func (h *Handler) saveSecondFactor(w http.ResponseWriter, r *http.Request, user models.User) {
+	if err := h.Service.SetSecondFactor(user.Id, r.FormValue("factor")); err != nil {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	h.renderSettings(w, user, "Second factor saved")
+}
+
+// DeleteCredentials revokes the passkey with the id of the form.
 func (h *Handler) DeleteCredentials(w http.ResponseWriter, r *http.Request) {
 	userValue := r.Context().Value("user")
 	if userValue == nil {
@@ -48,11 +313,54 @@ func (h *Handler) DeleteCredentials(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if r.Method != http.MethodPost {
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 
-	if err := h.Service.Auth.DeleteAllCredentialsByUserID(int64(user.Id)); err != nil {
-		http.Error(w, "failed", http.StatusInternalServerError)
+	if !h.requireSudo(w, user) {
 		return
 	}
+	err = h.Service.Auth.DeleteCredential(user.Id, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrLastLogin) {
+		h.renderSettings(w, user, err.Error())
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+		IP:     clientIP(r.RemoteAddr),
+		Device: getDevice(r),
+		Status: true,
+		Reason: "passkey removed"})
+	h.renderSettings(w, user, "The passkey is removed")
+}
 
-	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+func (h *Handler) renamePasskey(w http.ResponseWriter, r *http.Request, user models.User) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		h.ErrorPage(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	err = h.Service.Auth.RenameCredential(user.Id, id, r.FormValue("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderSettings(w, user, "Passkey renamed")
 }
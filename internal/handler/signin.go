@@ -17,11 +17,7 @@ func (h *Handler) signIn(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		h.handleSignInPost(w, r)
 	case http.MethodGet:
-
-		if err := h.Temp.ExecuteTemplate(w, "signin.html", nil); err != nil {
-			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+		h.renderSignIn(w, models.InfoSign{})
 	default:
 		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
@@ -59,7 +55,8 @@ func (h *Handler) handleSignInPost(w http.ResponseWriter, r *http.Request) {
 				IP:     clientIP(r.RemoteAddr),
 				Device: getDevice(r),
 				Status: false,
-				Reason: "fail auth password does not match"}); err != nil {
+				Reason: "fail auth password does not match",
+				Method: "password"}); err != nil {
 			}
 		}
 		h.renderSignInFormError(w, err.Error(), username)
@@ -79,7 +76,7 @@ func (h *Handler) handleSignInPost(w http.ResponseWriter, r *http.Request) {
 		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	h.updateRiskLevelByLogsAsync(user.Id, riskState)
+	h.updateRiskLevelByLogsAsync(user.Id, riskState, currentAttempt(r))
 
 	switch riskState.RiskLevel {
 	case "GREEN":
@@ -87,18 +84,32 @@ func (h *Handler) handleSignInPost(w http.ResponseWriter, r *http.Request) {
 			IP:     clientIP(r.RemoteAddr),
 			Device: getDevice(r),
 			Status: true,
-			Reason: "success login by 1 step auth"}); err != nil {
+			Reason: "success login by 1 step auth",
+			Method: "password"}); err != nil {
 		}
 		h.SetCookieAndSuccess(w, r, token, expired)
-	case "YELLOW":
-		if err := h.startSecondFactor(w, username); err != nil {
+	case "YELLOW", "RED":
+		// the user picks the factor in settings; RED never settles for email
+		factor, err := h.Service.ChooseSecondFactor(user.Id, riskState.RiskLevel)
+		if err != nil {
 			log.Println(err)
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/verify", http.StatusSeeOther)
-	case "RED":
-		http.Redirect(w, r, "/passkey3fa", http.StatusSeeOther)
+		switch factor {
+		case models.FactorTOTP:
+			h.startTOTPFactor(w, username)
+			http.Redirect(w, r, "/verify/totp", http.StatusSeeOther)
+		case models.FactorPasskey:
+			http.Redirect(w, r, "/passkey3fa", http.StatusSeeOther)
+		default:
+			if err := h.startSecondFactor(w, username); err != nil {
+				log.Println(err)
+				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/verify", http.StatusSeeOther)
+		}
 	default:
 		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -31,7 +31,7 @@ func (h *Handler) signUp(w http.ResponseWriter, r *http.Request) {
 				RepeatPassword: rpassword,
 				Email:          email,
 			}
-			if err := h.Temp.ExecuteTemplate(w, "signup.html", info); err != nil {
+			if err := h.render(w, "signup.html", info); err != nil {
 				h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
@@ -56,17 +56,19 @@ func (h *Handler) signUp(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		info := models.InfoSign{
-			Error:    "You have successfully registered",
-			Username: username,
-		}
-		if err := h.Temp.ExecuteTemplate(w, "signin.html", info); err != nil {
-			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
+		message := "You have successfully registered, open the link we mailed you to confirm your email"
+		if err := h.Service.SendVerificationEmail(user); err != nil {
+			// the link can be sent again from settings
+			log.Println(err)
+			message = "You have successfully registered"
 		}
+		h.renderSignIn(w, models.InfoSign{
+			Error:    message,
+			Username: username,
+		})
 	case http.MethodGet:
 
-		if err := h.Temp.ExecuteTemplate(w, "signup.html", nil); err != nil {
+		if err := h.render(w, "signup.html", nil); err != nil {
 			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
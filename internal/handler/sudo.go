@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/service"
+	"forum/internal/storage"
+	"net/http"
+	"time"
+)
+
+// requireSudo lets sensitive settings through only in the sudo window. When
+// it is closed the settings page asks to confirm the password or a passkey.
+func (h *Handler) requireSudo(w http.ResponseWriter, user models.User) bool {
+	ok, err := h.Service.InSudo(user.Id)
+	if err != nil {
+		h.ErrorPage(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		h.renderSettings(w, user, service.ErrSudoRequired.Error())
+		return false
+	}
+	return true
+}
+
+// requireSudoAPI is requireSudo for the calls of the settings page scripts,
+// which show the text of the error themselves.
+func (h *Handler) requireSudoAPI(w http.ResponseWriter, user models.User) bool {
+	ok, err := h.Service.InSudo(user.Id)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, service.ErrSudoRequired.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) sudoWithPassword(w http.ResponseWriter, r *http.Request, user models.User) {
+	allowed, err := h.CheckAtomic(storage.RDB, []Rule{{
+		Key:    "rate:user:sudo:" + user.Username,
+		Limit:  5,
+		Window: 10 * time.Minute,
+	}})
+	if err != nil {
+		h.ErrorPage(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !allowed {
+		h.ErrorPage(w, "Too many attempts", http.StatusTooManyRequests)
+		return
+	}
+	if err := h.Service.SudoWithPassword(user, r.FormValue("password")); err != nil {
+		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+			IP:     clientIP(r.RemoteAddr),
+			Device: getDevice(r),
+			Status: false,
+			Reason: "fail sudo by Invalid password"})
+		h.renderSettings(w, user, "Wrong password")
+		return
+	}
+	h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+		IP:     clientIP(r.RemoteAddr),
+		Device: getDevice(r),
+		Status: true,
+		Reason: "success sudo by password"})
+	h.renderSettings(w, user, "Confirmed, security settings are open for 10 minutes")
+}
+
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request, user models.User) {
+	err := h.Service.ChangePassword(user.Id, r.FormValue("password"), r.FormValue("password1"))
+	switch {
+	case errors.Is(err, service.ErrSudoRequired), errors.Is(err, models.ErrShortPassword), errors.Is(err, models.ErrPasswordDoesNotMatch):
+		h.renderSettings(w, user, err.Error())
+	case err != nil:
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+			IP:     clientIP(r.RemoteAddr),
+			Device: getDevice(r),
+			Status: true,
+			Reason: "password changed"})
+		h.renderSettings(w, user, "Your password is changed")
+	}
+}
+
+func (h *Handler) requestEmailChange(w http.ResponseWriter, r *http.Request, user models.User) {
+	err := h.Service.RequestEmailChange(user, r.FormValue("email"))
+	switch {
+	case errors.Is(err, service.ErrSudoRequired), errors.Is(err, service.ErrSameEmail),
+		errors.Is(err, models.ErrInvalidEmail), errors.Is(err, storage.ErrEmailTaken):
+		h.renderSettings(w, user, err.Error())
+	case err != nil:
+		models.ErrLog.Println(err)
+		h.renderSettings(w, user, "The mail could not be sent, try again later")
+	default:
+		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+			IP:     clientIP(r.RemoteAddr),
+			Device: getDevice(r),
+			Status: true,
+			Reason: "email change requested"})
+		h.renderSettings(w, user, "Open the link we sent to the new address to finish the change")
+	}
+}
+
+// emailChangeConfirm is where the link mailed to the new address points to.
+func (h *Handler) emailChangeConfirm(w http.ResponseWriter, r *http.Request) {
+	h.finishEmailChange(w, r, true)
+}
+
+// emailChangeCancel is where the link mailed to the old address points to.
+func (h *Handler) emailChangeCancel(w http.ResponseWriter, r *http.Request) {
+	h.finishEmailChange(w, r, false)
+}
+
+// finishEmailChange asks on GET, as mail scanners open every link, and
+// confirms or cancels the change when the button POSTs the token back.
+func (h *Handler) finishEmailChange(w http.ResponseWriter, r *http.Request, confirm bool) {
+	user, _ := r.Context().Value("user").(models.User)
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		change, err := h.Service.CheckEmailChange(token, confirm)
+		if errors.Is(err, storage.ErrTokenInvalid) {
+			h.emailChangeDone(w, user, err.Error())
+			return
+		}
+		if err != nil {
+			models.ErrLog.Println(err)
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		data := map[string]any{"Token": token, "Confirm": confirm, "NewEmail": change.NewEmail}
+		if err := h.render(w, "emailChange.html", data); err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	case http.MethodPost:
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.FormValue("token")
+
+	var change models.EmailChange
+	var err error
+	reason, message := "email change cancelled", "The email change is cancelled"
+	if confirm {
+		change, err = h.Service.ConfirmEmailChange(token)
+		reason, message = "email changed", "Your email is changed"
+	} else {
+		change, err = h.Service.CancelEmailChange(token)
+	}
+	switch {
+	case errors.Is(err, storage.ErrTokenInvalid), errors.Is(err, storage.ErrEmailTaken):
+		message = err.Error()
+	case err != nil:
+		models.ErrLog.Println(err)
+		h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	default:
+		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: change.UserID,
+			IP:     clientIP(r.RemoteAddr),
+			Device: getDevice(r),
+			Status: true,
+			Reason: reason})
+		if confirm && change.UserID == user.Id {
+			user.Email = change.NewEmail
+			user.EmailVerified = true
+		}
+	}
+	h.emailChangeDone(w, user, message)
+}
+
+func (h *Handler) emailChangeDone(w http.ResponseWriter, user models.User, message string) {
+	if !user.IsAuth {
+		h.renderSignIn(w, models.InfoSign{Error: message})
+		return
+	}
+	h.renderSettings(w, user, message)
+}
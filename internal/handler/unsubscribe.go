@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"forum/internal/service"
+	"net/http"
+)
+
+// unsubscribe backs the link in digest emails. GET shows a confirmation
+// button; POST (also sent by mail clients supporting RFC 8058 one-click
+// unsubscribe) turns digests off.
+func (h *Handler) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/unsubscribe" {
+		h.ErrorPage(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	switch r.Method {
+	case http.MethodGet:
+		if err := h.render(w, "unsubscribe.html", map[string]any{"Token": token}); err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		if err := h.Service.Unsubscribe(token); err != nil {
+			if errors.Is(err, service.ErrUnsubscribeToken) {
+				h.ErrorPage(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err := h.render(w, "unsubscribe.html", map[string]any{"Done": true}); err != nil {
+			h.ErrorPage(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	default:
+		h.ErrorPage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -3,11 +3,14 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"forum/internal/models"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
@@ -30,8 +33,24 @@ func (h *Handler) WebAuthnRegisterStart(w http.ResponseWriter, r *http.Request)
 		h.ErrorPage(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	// a new way to sign in outlives a password reset, so it is only added
+	// by whoever just confirmed it is them
+	if !h.requireSudoAPI(w, user) {
+		return
+	}
 
-	user.Credentials = []webauthn.Credential{}
+	// the passkeys the user already has are excluded, so the same
+	// authenticator is not registered twice
+	creds, err := h.Service.Auth.GetCredentials(user.Id)
+	if err != nil {
+		http.Error(w, "credentials error", http.StatusInternalServerError)
+		return
+	}
+	user.Credentials = creds
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(creds))
+	for _, cred := range creds {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
 	options, sessionData, err := models.WebAuthn.BeginRegistration(
 		&user,
 		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
@@ -39,6 +58,7 @@ func (h *Handler) WebAuthnRegisterStart(w http.ResponseWriter, r *http.Request)
 			ResidentKey:      protocol.ResidentKeyRequirementRequired,
 		}),
 		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+		webauthn.WithExclusions(exclusions),
 	)
 	if err != nil {
 		http.Error(w, "begin registration failed", http.StatusInternalServerError)
@@ -89,6 +109,9 @@ func (h *Handler) WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request)
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if !h.requireSudoAPI(w, user) {
+		return
+	}
 
 	// 2️⃣ Читаем cookie с sessionID
 	cookie, err := r.Cookie("webauthn_reg")
@@ -125,15 +148,21 @@ func (h *Handler) WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request)
 		PublicKey:    credential.PublicKey,
 		SignCount:    credential.Authenticator.SignCount,
 		IsPasskey:    credential.Flags.BackupEligible,
+		Name:         r.URL.Query().Get("name"),
 
-		// BackupEligible: credential.Flags.BackupEligible,
-		// BackupState:    credential.Flags.BackupState,
+		BackupEligible: credential.Flags.BackupEligible,
+		BackupState:    credential.Flags.BackupState,
 	})
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, "failed to save credential", http.StatusInternalServerError)
 		return
 	}
+	h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+		IP:     clientIP(r.RemoteAddr),
+		Device: getDevice(r),
+		Status: true,
+		Reason: "passkey added"})
 
 	// 6️⃣ Удаляем session из Redis
 	_ = h.sessionStore.Delete(ctx, sessionID)
@@ -151,53 +180,71 @@ func (h *Handler) WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
+// WebAuthnLoginStart begins a passkey sign in. With an email the browser
+// is told which passkeys of that account to use; without one the login is
+// usernameless and the authenticator offers its discoverable passkeys,
+// from the autofill of the sign in form when conditional is set.
 func (h *Handler) WebAuthnLoginStart(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// 1️⃣ Пользователь может быть НЕ авторизован
-	// логин по email / username приходит из формы
 	var req struct {
-		Email string `json:"email"`
+		Email       string `json:"email"`
+		Conditional bool   `json:"conditional"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
-	user, err := h.Service.User.GetUserByEmail(req.Email)
-	if err != nil {
-		http.Error(w, "user not found", http.StatusNotFound)
-		return
-	}
 
-	// 2️⃣ Загружаем credentials пользователя
-	creds, err := h.Service.Auth.GetCredentials(user.Id)
-	if err != nil || len(creds) == 0 {
-		http.Error(w, "no passkeys on your device", http.StatusBadRequest)
-		return
-	}
-	user.Credentials = creds
-
-	// 3️⃣ BeginLogin
-	options, sessionData, err := models.WebAuthn.BeginLogin(&user)
-	if err != nil {
-		http.Error(w, "begin login failed", http.StatusInternalServerError)
-		return
+	var (
+		options     *protocol.CredentialAssertion
+		sessionData *webauthn.SessionData
+		userID      int
+	)
+	if req.Email == "" {
+		mediation := protocol.MediationDefault
+		if req.Conditional {
+			mediation = protocol.MediationConditional
+		}
+		var err error
+		options, sessionData, err = models.WebAuthn.BeginDiscoverableMediatedLogin(mediation)
+		if err != nil {
+			http.Error(w, "begin login failed", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		user, err := h.Service.User.GetUserByEmail(req.Email)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		creds, err := h.Service.Auth.GetCredentials(user.Id)
+		if err != nil || len(creds) == 0 {
+			http.Error(w, "no passkeys on your device", http.StatusBadRequest)
+			return
+		}
+		user.Credentials = creds
+
+		options, sessionData, err = models.WebAuthn.BeginLogin(&user)
+		if err != nil {
+			http.Error(w, "begin login failed", http.StatusInternalServerError)
+			return
+		}
+		userID = user.Id
 	}
 
-	// 4️⃣ sessionID
 	sessionID := fmt.Sprintf(
 		"webauthn:login:%d:%s",
-		user.Id,
+		userID,
 		uuid.NewString(),
 	)
 
-	// 5️⃣ save session
 	if err := h.sessionStore.Save(ctx, sessionID, sessionData); err != nil {
 		http.Error(w, "failed to save session", http.StatusInternalServerError)
 		return
 	}
 
-	// 6️⃣ cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "webauthn_login",
 		Value:    sessionID,
@@ -208,7 +255,6 @@ func (h *Handler) WebAuthnLoginStart(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   300,
 	})
 
-	// 7️⃣ send options
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(options)
 }
@@ -232,51 +278,29 @@ func (h *Handler) WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3️⃣ parse credential
-	bodyBytes, _ := io.ReadAll(r.Body)
-	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(bodyBytes))
-	if err != nil {
-		http.Error(w, "invalid assertion", http.StatusBadRequest)
-		return
-	}
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-	// 4️⃣ userHandle → user
-	userID, err := h.Service.Auth.GetUserIDByCredentialID(parsed.RawID)
-	if err != nil {
-		http.Error(w, "credential not found", http.StatusUnauthorized)
-		return
-	}
-
-	fmt.Println(userID)
-
-	user, err := h.Service.User.GetUserById(userID)
-	if err != nil {
-		http.Error(w, "user not found", http.StatusUnauthorized)
-		return
-	}
-	log.Println(user)
-	// 5️⃣ load credentials
-	creds, err := h.Service.Auth.GetCredentials(user.Id)
-	if err != nil {
-		http.Error(w, "credentials error", http.StatusInternalServerError)
-		return
-	}
-
-	user.Credentials = creds
-	// 6️⃣ FinishLogin
-	credential, err := models.WebAuthn.FinishLogin(
-		&user,
-		*sessionData,
-		r,
+	// 3️⃣ FinishLogin, usernameless when the session has no user
+	var (
+		user       models.User
+		credential *webauthn.Credential
 	)
-	if err != nil {
-		log.Println(err.Error())
-		http.Error(w, "assertion failed", http.StatusUnauthorized)
-		return
+	if len(sessionData.UserID) == 0 {
+		found, cred, err := models.WebAuthn.FinishPasskeyLogin(h.passkeyUser, *sessionData, r)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "assertion failed", http.StatusUnauthorized)
+			return
+		}
+		user, credential = *found.(*models.User), cred
+	} else {
+		user, credential, err = h.finishUserLogin(r, *sessionData)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "assertion failed", http.StatusUnauthorized)
+			return
+		}
 	}
 
-	// 7️⃣ update signCount
+	// 4️⃣ update signCount
 	if err := h.Service.Auth.UpdateSignCount(
 		credential.ID,
 		credential.Authenticator.SignCount,
@@ -284,11 +308,13 @@ func (h *Handler) WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 404)
 		return
 	}
-	log.Println("UpdateSignCount")
-	// 8️⃣ create auth session
+	// 5️⃣ create auth session
 	token, expired, err := h.Service.Auth.CreateSession(user.Username)
-	log.Println(expired)
-	// 9️⃣ cleanup
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// 6️⃣ cleanup
 	if err := h.sessionStore.Delete(ctx, sessionID); err != nil {
 		http.Error(w, err.Error(), 404)
 		return
@@ -307,11 +333,160 @@ func (h *Handler) WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
 		Expires:  expired,
 		HttpOnly: true,
 		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
 	})
 	http.Redirect(w, r, "/", http.StatusSeeOther)
-	h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: userID,
+	h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+		IP:     clientIP(r.RemoteAddr),
+		Device: getDevice(r),
+		Status: true,
+		Reason: "success login by webauthn",
+		Method: "passkey"})
+}
+
+// finishUserLogin checks an assertion for a login that started with the
+// email of the account.
+func (h *Handler) finishUserLogin(r *http.Request, sessionData webauthn.SessionData) (models.User, *webauthn.Credential, error) {
+	bodyBytes, _ := io.ReadAll(r.Body)
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(bodyBytes))
+	if err != nil {
+		return models.User{}, nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	userID, err := h.Service.Auth.GetUserIDByCredentialID(parsed.RawID)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+	user, err := h.Service.User.GetUserById(userID)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+	user.Credentials, err = h.Service.Auth.GetCredentials(user.Id)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+	credential, err := models.WebAuthn.FinishLogin(&user, sessionData, r)
+	return user, credential, err
+}
+
+// passkeyUser finds the owner of a discoverable passkey. The user handle the
+// authenticator returns is the WebAuthnID given at registration.
+func (h *Handler) passkeyUser(rawID, userHandle []byte) (webauthn.User, error) {
+	userID, err := strconv.Atoi(string(userHandle))
+	if err != nil {
+		return nil, err
+	}
+	owner, err := h.Service.Auth.GetUserIDByCredentialID(rawID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != userID {
+		return nil, errors.New("passkey belongs to another user")
+	}
+	user, err := h.Service.User.GetUserById(userID)
+	if err != nil {
+		return nil, err
+	}
+	user.Credentials, err = h.Service.Auth.GetCredentials(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// WebAuthnSudoStart asks the signed in user for a passkey assertion that
+// opens the sudo window, instead of typing the password again.
+func (h *Handler) WebAuthnSudoStart(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value("user").(models.User)
+	if !ok || !user.IsAuth {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	creds, err := h.Service.Auth.GetCredentials(user.Id)
+	if err != nil || len(creds) == 0 {
+		http.Error(w, "no passkeys on your account", http.StatusBadRequest)
+		return
+	}
+	user.Credentials = creds
+
+	options, sessionData, err := models.WebAuthn.BeginLogin(&user,
+		webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		http.Error(w, "begin sudo failed", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := fmt.Sprintf("webauthn:sudo:%d:%s", user.Id, uuid.NewString())
+	if err := h.sessionStore.Save(r.Context(), sessionID, sessionData); err != nil {
+		http.Error(w, "failed to save session", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "webauthn_sudo",
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   300,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+func (h *Handler) WebAuthnSudoFinish(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value("user").(models.User)
+	if !ok || !user.IsAuth {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie("webauthn_sudo")
+	if err != nil || !strings.HasPrefix(cookie.Value, fmt.Sprintf("webauthn:sudo:%d:", user.Id)) {
+		http.Error(w, "sudo session missing", http.StatusBadRequest)
+		return
+	}
+	sessionData, err := h.sessionStore.Get(ctx, cookie.Value)
+	if err != nil {
+		http.Error(w, "sudo session expired", http.StatusBadRequest)
+		return
+	}
+	_ = h.sessionStore.Delete(ctx, cookie.Value)
+	http.SetCookie(w, &http.Cookie{Name: "webauthn_sudo", Value: "", Path: "/", MaxAge: -1})
+
+	creds, err := h.Service.Auth.GetCredentials(user.Id)
+	if err != nil {
+		http.Error(w, "credentials error", http.StatusInternalServerError)
+		return
+	}
+	user.Credentials = creds
+
+	credential, err := models.WebAuthn.FinishLogin(&user, *sessionData, r)
+	if err != nil {
+		h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
+			IP:     clientIP(r.RemoteAddr),
+			Device: getDevice(r),
+			Status: false,
+			Reason: "fail sudo by Invalid passkey"})
+		http.Error(w, "assertion failed", http.StatusUnauthorized)
+		return
+	}
+	if err := h.Service.Auth.UpdateSignCount(credential.ID, credential.Authenticator.SignCount); err != nil {
+		http.Error(w, "credentials error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Service.StartSudo(user.Id); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	h.Service.AuthRiskIR.SaveAuthLog(models.AuthLog{UserID: user.Id,
 		IP:     clientIP(r.RemoteAddr),
 		Device: getDevice(r),
 		Status: true,
-		Reason: "success login by webauthn"})
+		Reason: "success sudo by webauthn"})
+	w.WriteHeader(http.StatusOK)
 }
@@ -5,12 +5,24 @@ type Info struct {
 	Post
 	Comment     []Comment
 	AllCategory []Category
+	Poll        *Poll
+	Flags       PostFlags
+	FlagAudit   []PostFlagAudit
+}
+
+type InfoCreatePost struct {
+	User
+	Categories []Category
+	Draft      Post
 }
 
 type InfoPosts struct {
 	User
-	Posts    []Post
-	Category []Category
+	Posts         []Post
+	Category      []Category
+	Current       Category
+	Pinned        []Post
+	Announcements []Post
 }
 
 type InfoMsg struct {
@@ -25,16 +37,40 @@ type InfoSign struct {
 	Password       string
 	RepeatPassword string
 	Email          string
+	Providers      []OAuthProvider
 }
 
 type ProfileInfo struct {
 	User
 	Error       string
 	ProfileUser User
-	Posts       []Post
-	WaitPosts   []Post
-	Askeds      []Communication
-	RoleMsgs    []Communication
-	AllUsers    []User
-	AllCategory []Category
+	IsOwner     bool
+	Hidden      bool
+	Tab         string
+	Reputation  int
+	Badges      []UserBadge
+	// set on the owner's profile once reputation unlocks the moderator role
+	ModeratorOffer bool
+	Privacy        Privacy
+	Posts          []Post
+	Comments       []Comment
+	WaitPosts      []Post
+	Askeds         []Communication
+	RoleMsgs       []Communication
+	AllUsers       []User
+	AllCategory    []Category
+	DMReports      []DMReport
+	// categories the owner moderates without the moderator role
+	ModeratedCategories []string
+}
+
+type InfoMessages struct {
+	User
+	Error         string
+	Conversations []Conversation
+	Conversation  Conversation
+	Messages      []DirectMessage
+	Blocked       []User
+	Report        DMReport
+	Unread        int
 }
@@ -3,16 +3,41 @@ package models
 import "time"
 
 type AuthLog struct {
-	ID     int       `json:"id"`
-	UserID int       `json:"user_id"`
-	IP     string    `json:"ip,omitempty"`
-	Device string    `json:"device,omitempty"`
-	Geo    string    `json:"geo,omitempty"`
-	Status bool      `json:"status,omitempty"`
-	Reason string    `json:"reason,omitempty"`
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	IP     string `json:"ip,omitempty"`
+	Device string `json:"device,omitempty"`
+	// Geo is the country of IP, City and ASN the city and the network
+	// operator, when the GeoIP databases know them.
+	Geo    string `json:"geo,omitempty"`
+	City   string `json:"city,omitempty"`
+	ASN    string `json:"asn,omitempty"`
+	Status bool   `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Method is how the user tried to sign in, like "password" or
+	// "passkey". Events that are not sign ins have none.
+	Method string    `json:"method,omitempty"`
 	Time   time.Time `json:"event_time,omitempty"`
 }
 
+// SignIn tells whether the event is a sign in attempt, the only events
+// the risk engine looks at; sudo, password and email changes are not.
+func (l AuthLog) SignIn() bool {
+	return l.Method != ""
+}
+
+// Location is the city and country of the event, as far as they are known.
+func (l AuthLog) Location() string {
+	switch {
+	case l.City != "" && l.Geo != "":
+		return l.City + ", " + l.Geo
+	case l.Geo != "":
+		return l.Geo
+	default:
+		return l.City
+	}
+}
+
 type RiskAssessment struct {
 	UserID            int       `json:"user_id"`
 	RiskLevel         string    `json:"risk_level"`
@@ -22,6 +47,93 @@ type RiskAssessment struct {
 	PrimaryDevice     string    `json:"primary_device"`
 	PrimaryOnlineTime time.Time `json:"primary_online_time"`
 	AuthLogs          []AuthLog `json:"authLogs,omitempty"`
+	// Explanation is how the risk engine came to RiskLevel.
+	Explanation RiskExplanation `json:"explanation"`
+	AssessedAt  time.Time       `json:"assessed_at"`
+	// PinnedUntil is set while an admin holds RiskLevel; the engine does
+	// not change it before then.
+	PinnedUntil time.Time `json:"pinned_until"`
+}
+
+// Pinned tells whether an admin holds the level at now.
+func (r RiskAssessment) Pinned(now time.Time) bool {
+	return now.Before(r.PinnedUntil)
+}
+
+// RiskUser is a row of the admin risk dashboard.
+type RiskUser struct {
+	RiskAssessment
+	Username    string
+	LastEventAt time.Time
+}
+
+// RiskOverride is an admin action on the risk level of a user, or an export
+// of their events. They are all kept.
+type RiskOverride struct {
+	Id        int
+	UserID    int
+	Action    string
+	Level     string
+	Note      string
+	ActorID   int
+	ActorName string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// RiskFactor is what one scorer of the risk engine found: Score is from 0
+// (nothing unusual) to 1, and counts Weight times in the total.
+type RiskFactor struct {
+	Name   string  `json:"name"`
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// RiskExplanation records the rule that decided a risk level and the
+// factors it looked at.
+type RiskExplanation struct {
+	Rule    string       `json:"rule"`
+	Score   float64      `json:"score"`
+	Factors []RiskFactor `json:"factors"`
 }
 
 type UserEvent = AuthLog
+
+// Second factors a user can pick for risky logins.
+const (
+	FactorEmail   = "email"
+	FactorTOTP    = "totp"
+	FactorPasskey = "passkey"
+)
+
+// TOTP is an authenticator app secret. Secret stays encrypted outside the
+// service; LastStep is the last time step accepted, so a code works once.
+type TOTP struct {
+	UserID    int
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// TOTPEnrollment is shown while the user scans the QR code.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+	QR     string
+}
+
+type TOTPStatus struct {
+	Enabled       bool
+	RecoveryLeft  int
+	SecondFactor  string
+	RecoveryCodes []string
+}
+
+// EmailChange is a pending move of an account to another address.
+type EmailChange struct {
+	UserID   int
+	OldEmail string
+	NewEmail string
+}
@@ -11,7 +11,7 @@ var (
 	ErrInvalidUserName      = errors.New("Invalid username - your username should consist at least 6 characters")
 	ErrInvalidEmail         = errors.New("Invalid email")
 	ErrPasswordDoesNotMatch = errors.New("Password Does Not Match")
-	ErrShortPassword        = errors.New("Incorrect password - your password should be a minimum of 8 characters and consist of at least:1 lower case letter, 1 upper case letter, 1 number, 1 special symbol")
+	ErrShortPassword        = errors.New("Incorrect password - your password should be 8 to 20 characters long and consist of at least:1 lower case letter, 1 upper case letter, 1 number, 1 special symbol")
 )
 
 type Error struct {
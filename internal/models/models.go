@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
-	"github.com/gofrs/uuid"
 )
 
 type User struct {
@@ -16,6 +15,7 @@ type User struct {
 	RepeatPassword string
 	ExpiresAt      *time.Time
 	IsAuth         bool
+	EmailVerified  bool
 	ImageBack      string
 	ImageURL       string
 	Rol            string
@@ -25,23 +25,41 @@ type User struct {
 	Credentials    []webauthn.Credential
 }
 
-type GoogleLoginUserData struct {
-	ID        uuid.UUID
-	Name      string
-	Email     string
-	Password  string
-	Role      string
-	Photo     string
-	Verified  bool
-	Provider  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+// OAuthProvider is a configured login provider as the templates list it.
+type OAuthProvider struct {
+	Name  string
+	Title string
+	Icon  string
+}
+
+// OAuthState travels in a signed cookie between the redirect to the
+// provider and the callback. LinkUserID is set when a signed in user links
+// the provider instead of logging in with it.
+type OAuthState struct {
+	Provider   string
+	State      string
+	Nonce      string
+	Verifier   string
+	LinkUserID int
+	Expires    int64
+}
+
+// OAuthClaims is what a provider told us about the user.
+type OAuthClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Picture       string
+	// LegacyID is what the old login stored as email (GitHub's node_id)
+	LegacyID string
 }
 
-type GithubUserData struct {
-	Login  string `json:"login"`
-	ID     int    `json:"id"`
-	NodeID string `json:"node_id"`
+type UserIdentity struct {
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
 }
 
 type Post struct {
@@ -56,6 +74,10 @@ type Post struct {
 	Dislikes    int
 	Status      string
 	CreateAt    time.Time
+	PublishAt   time.Time // set while the post is scheduled
+
+	AuthorReputation int
+	AuthorBadges     []Badge
 }
 
 type Message struct {
@@ -85,6 +107,8 @@ type Comment struct {
 	Dislikes   int
 	IsAuth     bool
 	Created_at time.Time
+
+	CreatorReputation int
 }
 
 type Like struct {
@@ -97,7 +121,16 @@ type Like struct {
 }
 
 type Category struct {
-	Name string
+	Name        string
+	Slug        string
+	Description string
+	Icon        string
+	// name of the parent category, empty for a top level one
+	Parent   string
+	Position int
+	// archived categories keep their posts but take no new ones
+	Archived   bool
+	Moderators []string
 }
 
 type Communication struct {
@@ -121,6 +154,7 @@ type Communication struct {
 type WebAuthnCredential struct {
 	ID             int64     // id
 	UserID         int64     // user_id
+	Name           string    // name, the nickname shown in settings
 	CredentialID   []byte    // credential_id (BLOB)
 	PublicKey      []byte    // public_key (BLOB)
 	SignCount      uint32    // sign_count
@@ -152,3 +186,187 @@ func (u *User) WebAuthnIcon() string {
 func (u *User) WebAuthnCredentials() []webauthn.Credential {
 	return u.Credentials
 }
+
+type DigestPreferences struct {
+	UserID               int
+	Email                string
+	Username             string
+	Digest               string
+	IncludeNotifications bool
+	IncludeReplies       bool
+	IncludeCategories    bool
+	UnsubscribeToken     string
+	LastSentAt           time.Time
+	FollowedCategories   []string
+}
+
+type Digest struct {
+	Username       string
+	Period         string
+	Notifications  []Message
+	Replies        []Message
+	CategoryPosts  []Post
+	BaseURL        string
+	UnsubscribeURL string
+}
+
+type Conversation struct {
+	Id          int
+	Title       string
+	Members     []User
+	LastMessage string
+	LastSender  string
+	UpdatedAt   time.Time
+	Unread      int
+}
+
+type DirectMessage struct {
+	Id             int
+	ConversationId int
+	SenderId       int
+	SenderName     string
+	SenderImage    string
+	Body           string
+	Image          string
+	CreatedAt      time.Time
+}
+
+type DMReport struct {
+	Id             int
+	ConversationId int
+	MessageId      int
+	ReporterId     int
+	ReporterName   string
+	Reason         string
+	Status         string
+	HandledBy      int
+	CreatedAt      time.Time
+}
+
+type Privacy struct {
+	UserID        int
+	PublicProfile bool
+	ShowEmail     bool
+	ShowComments  bool
+	ShowLiked     bool
+}
+
+type ReputationEvent struct {
+	UserID   int
+	ActorID  int
+	Source   string
+	SourceID int
+	Points   int
+}
+
+type Badge struct {
+	Code        string
+	Name        string
+	Description string
+	Icon        string
+}
+
+type UserBadge struct {
+	Badge
+	UserID    int
+	Period    string
+	AwardedAt time.Time
+}
+
+// Badges lists every badge that can be earned. The rules that award them live
+// in the service package; this is what the pages and notifications show.
+var Badges = []Badge{
+	{Code: "first_post", Name: "First post", Description: "Published a first post", Icon: "✍"},
+	{Code: "likes_100", Name: "Crowd favourite", Description: "Received 100 likes on posts and comments", Icon: "❤"},
+	{Code: "member_1y", Name: "Veteran", Description: "Has been a member for a year", Icon: "★"},
+	{Code: "top_commenter", Name: "Top commenter", Description: "Wrote the most comments in a month", Icon: "💬"},
+}
+
+func BadgeByCode(code string) (Badge, bool) {
+	for _, b := range Badges {
+		if b.Code == code {
+			return b, true
+		}
+	}
+	return Badge{}, false
+}
+
+// BadgeStats is what the badge rules look at for one user.
+type BadgeStats struct {
+	Posts         int
+	LikesReceived int
+	MemberDays    int
+}
+
+type Poll struct {
+	Id          int
+	PostId      int
+	Question    string
+	Multiple    bool
+	HideResults bool
+	ClosesAt    time.Time // zero when the poll never closes
+	Options     []PollOption
+
+	Voters      int
+	Voted       bool
+	Closed      bool
+	ShowResults bool
+}
+
+type PollOption struct {
+	Id      int
+	Text    string
+	Votes   int
+	Percent int
+	Chosen  bool
+}
+
+// PostFlags are the moderator settings of a post that is pinned, locked or
+// announced. Zero expiry times never expire.
+type PostFlags struct {
+	Pins            []PostPin
+	Locked          bool
+	LockExpires     time.Time
+	Announced       bool
+	AnnounceExpires time.Time
+}
+
+type PostPin struct {
+	Scope     string
+	PinnedBy  int
+	ExpiresAt time.Time
+}
+
+type PostFlagAudit struct {
+	Id        int
+	PostID    int
+	Action    string
+	Scope     string
+	ActorID   int
+	ActorName string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Job is a unit of background work. Payload is the JSON of what the handler
+// of Kind needs; a job is tried up to MaxAttempts times, then it is dead.
+type Job struct {
+	Id          int
+	Kind        string
+	Payload     string
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAt       time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// HealthCheck is how one dependency answered the readiness probe. Error is
+// only logged, the probe does not show it.
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"-"`
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"forum/internal/models"
 	"os"
+	"time"
 )
 
 type GoogleOAuthConfig struct {
@@ -24,14 +25,205 @@ type GithubOAuthConfig struct {
 	UserInfoURL  string
 }
 
+// OAuthProviderConfig describes one login provider. With an Issuer the
+// provider is treated as OpenID Connect: missing endpoints come from its
+// discovery document and the ID token is verified against its JWKS. Without
+// one it is plain OAuth2 and the user comes from UserInfoURL (GitHub).
+type OAuthProviderConfig struct {
+	Title        string
+	Icon         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Issuer       string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	JWKSURL      string
+	Scopes       []string
+}
+
 type OAuthConfig struct {
+	// StateSecret signs the state cookie of a login in progress. When empty
+	// a random key is used, so logins started before a restart fail.
+	StateSecret string
+	Providers   map[string]OAuthProviderConfig
+	// Google and Github are the settings from before providers were generic.
+	// They are still read when Providers has no entry with that name.
 	Google GoogleOAuthConfig
 	Github GithubOAuthConfig
 }
 
+// AllProviders returns the configured providers by name, the old Google and
+// Github blocks included.
+func (c OAuthConfig) AllProviders() map[string]OAuthProviderConfig {
+	providers := make(map[string]OAuthProviderConfig, len(c.Providers)+2)
+	for name, provider := range c.Providers {
+		providers[name] = provider
+	}
+	if _, ok := providers["google"]; !ok && c.Google.ClientID != "" {
+		providers["google"] = OAuthProviderConfig{
+			Title:        "Google",
+			Icon:         "google.png",
+			ClientID:     c.Google.ClientID,
+			ClientSecret: c.Google.ClientSecret,
+			RedirectURL:  c.Google.RedirectURL,
+			Issuer:       "https://accounts.google.com",
+			AuthURL:      c.Google.AuthURL,
+			TokenURL:     c.Google.TokenURL,
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+	}
+	if _, ok := providers["github"]; !ok && c.Github.ClientID != "" {
+		providers["github"] = OAuthProviderConfig{
+			Title:        "GitHub",
+			Icon:         "github.png",
+			ClientID:     c.Github.ClientID,
+			ClientSecret: c.Github.ClientSecret,
+			RedirectURL:  c.Github.RedirectURL,
+			AuthURL:      c.Github.AuthURL,
+			TokenURL:     c.Github.TokenURL,
+			UserInfoURL:  c.Github.UserInfoURL,
+			Scopes:       []string{"read:user", "user:email"},
+		}
+	}
+	return providers
+}
+
+// ReputationConfig holds the points a received reaction is worth, the most
+// points a user can gain per day and the reputation needed for each privilege.
+type ReputationConfig struct {
+	PostLike       int
+	PostDislike    int
+	CommentLike    int
+	CommentDislike int
+	DailyCap       int
+
+	NoModeration   int
+	CreatePolls    int
+	ModeratorOffer int
+}
+
+// SecurityConfig holds the keys for account protection. TOTPKey encrypts the
+// authenticator app secrets at rest and signs the second step of a login;
+// without it authenticator apps cannot be enrolled. TokenKey signs the links
+// of password reset and email verification mails; when empty a random key is
+// used, so links sent before a restart stop working.
+type SecurityConfig struct {
+	TOTPKey  string
+	TokenKey string
+	Issuer   string
+}
+
+// LLMConfig points at an Ollama style /api/generate endpoint, asked as one
+// of the login risk scorers when APIURL is set. Model defaults to mistral
+// and Timeout, a Go duration like "20s", to 40 seconds.
+type LLMConfig struct {
+	APIURL  string
+	Model   string
+	Timeout string
+}
+
+// RiskConfig holds the path of the rule file of the login risk engine. When
+// it is empty or missing the built in rules are used.
+type RiskConfig struct {
+	RulesFile string
+}
+
+// GeoIPConfig holds the paths of MaxMind format databases (GeoLite2 or
+// GeoIP2), read locally to place auth events: CityDB for the country and
+// city, ASNDB for the network. Either may be left empty.
+type GeoIPConfig struct {
+	CityDB string
+	ASNDB  string
+}
+
+// JobsConfig sets how many background jobs run at once. Workers defaults
+// to 4.
+type JobsConfig struct {
+	Workers int
+}
+
+// CORSConfig lists the origins, like "https://app.example.com", whose pages
+// may call the forum with the user's cookies. The forum's own pages never
+// need to be listed. Writes from a listed origin are also not taken for
+// cross-site request forgery.
+type CORSConfig struct {
+	AllowedOrigins []string
+}
+
+// HeadersConfig tunes the security headers of every answer. CSP replaces
+// the default Content-Security-Policy; "{nonce}" in it stands for the nonce
+// the inline scripts of the request carry. HSTSMaxAge is in seconds, 180
+// days when 0; a negative one sends no Strict-Transport-Security.
+// ReferrerPolicy defaults to strict-origin-when-cross-origin. UploadsPrefix,
+// like "/uploads/", is where uploaded images are linked from instead of
+// /static/data/, which keeps working for old links.
+type HeadersConfig struct {
+	CSP            string
+	HSTSMaxAge     int
+	ReferrerPolicy string
+	UploadsPrefix  string
+}
+
+// ServerConfig holds the timeouts of the HTTP server as Go durations, like
+// "15s". ReadHeaderTimeout defaults to 5 seconds, ReadTimeout to 30 (uploads
+// are up to 20 MB), WriteTimeout to 30 and IdleTimeout to 2 minutes.
+// ShutdownTimeout is how long requests in flight and background jobs get to
+// finish on SIGTERM, 30 seconds by default.
+type ServerConfig struct {
+	ReadHeaderTimeout string
+	ReadTimeout       string
+	WriteTimeout      string
+	IdleTimeout       string
+	ShutdownTimeout   string
+}
+
+// TLSConfig says how the forum gets its certificate. Mode "file", the
+// default, serves CertFile and KeyFile (secure/server.crt and
+// secure/server.key), read again when they change on disk. Mode "acme" gets
+// certificates for Domains from an ACME CA, Let's Encrypt unless
+// DirectoryURL names another one like a local Pebble, whose root certificate
+// CARoot then points at; they are kept and renewed in CacheDir, secure/acme
+// by default. Mode "proxy" serves plain HTTP behind a proxy that terminates
+// TLS. RedirectAddr, like ":80", is where plain HTTP is sent to HTTPS and
+// ACME HTTP challenges are answered. TrustedProxies are the addresses or
+// CIDRs whose X-Forwarded-For and X-Forwarded-Proto headers are believed.
+type TLSConfig struct {
+	Mode           string
+	CertFile       string
+	KeyFile        string
+	Domains        []string
+	Email          string
+	CacheDir       string
+	DirectoryURL   string
+	CARoot         string
+	RedirectAddr   string
+	TrustedProxies []string
+}
+
+// ShutdownGrace is how long a shutdown waits for what is still running.
+func (c ServerConfig) ShutdownGrace() time.Duration {
+	return duration(c.ShutdownTimeout, 30*time.Second)
+}
+
+// duration parses value, falling back to def when it is empty or invalid.
+func duration(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		models.ErrLog.Printf("config: invalid duration %q, using %s", value, def)
+		return def
+	}
+	return d
+}
+
 type Config struct {
-	Port string
-	DB   struct {
+	Port   string
+	Server ServerConfig
+	DB     struct {
 		Dsn    string
 		Driver string
 	}
@@ -41,15 +233,26 @@ type Config struct {
 		Host     string
 		Port     string
 	}
-	LLM struct {
-		APIURL string
+	Mail struct {
+		Driver    string
+		OutboxDir string
+		BaseURL   string
 	}
-	Redis struct {
+	Reputation ReputationConfig
+	LLM        LLMConfig
+	Risk       RiskConfig
+	GeoIP      GeoIPConfig
+	Jobs       JobsConfig
+	Redis      struct {
 		Addr     string
 		Password string
 		DB       int
 	}
-	OAuth OAuthConfig
+	OAuth    OAuthConfig
+	Security SecurityConfig
+	CORS     CORSConfig
+	Headers  HeadersConfig
+	TLS      TLSConfig
 }
 
 func NewConfig() (Config, error) {
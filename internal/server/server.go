@@ -1,45 +1,100 @@
 package server
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
 	"forum/internal/models"
-	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
 type Server struct {
-	httpServer *http.Server
+	mu      sync.Mutex
+	servers []*http.Server
+	stopped bool
 }
 
-func (s *Server) Run(port string, handler http.Handler) error {
-	cert, err := tls.LoadX509KeyPair("secure/server.crt", "secure/server.key")
-	if err != nil {
-		log.Fatal("Ошибка загрузки сертификата: ", err)
-	}
-	tlsConfig := &tls.Config{
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		},
-		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
-		Certificates:     []tls.Certificate{cert},
+// Run serves handler until Shutdown is called, when it returns nil: over
+// TLS with the certificate config.TLS says where to get, or over plain HTTP
+// behind a TLS proxy. The redirect listener, when configured, sends plain
+// HTTP to HTTPS and answers ACME challenges.
+func (s *Server) Run(config Config, handler http.Handler) error {
+	httpServer := &http.Server{
+		Addr:              config.Port,
+		Handler:           handler,
+		MaxHeaderBytes:    1 << 20,
+		ReadHeaderTimeout: duration(config.Server.ReadHeaderTimeout, 5*time.Second),
+		ReadTimeout:       duration(config.Server.ReadTimeout, 30*time.Second),
+		WriteTimeout:      duration(config.Server.WriteTimeout, 30*time.Second),
+		IdleTimeout:       duration(config.Server.IdleTimeout, 2*time.Minute),
+	}
+	listen := func() error { return httpServer.ListenAndServeTLS("", "") }
+	scheme := "https"
+	var redirect http.Handler
+	if config.TLS.Mode == "proxy" {
+		listen = httpServer.ListenAndServe
+		scheme = "http"
+	} else {
+		tlsConfig, manager, err := config.TLS.tlsConfig()
+		if err != nil {
+			return err
+		}
+		httpServer.TLSConfig = tlsConfig
+		redirect = redirectHandler(config.Port)
+		if manager != nil {
+			redirect = manager.HTTPHandler(redirect)
+		}
 	}
 
-	s.httpServer = &http.Server{
-		Addr:           port,
-		Handler:        handler,
-		MaxHeaderBytes: 1 << 20,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		TLSConfig:      tlsConfig,
-	}
-	models.InfoLog.Printf("Server run on https://localhost%s", port)
+	listeners := []func() error{listen}
+	servers := []*http.Server{httpServer}
+	if redirect != nil && config.TLS.RedirectAddr != "" {
+		redirectServer := &http.Server{
+			Addr:              config.TLS.RedirectAddr,
+			Handler:           redirect,
+			MaxHeaderBytes:    1 << 20,
+			ReadHeaderTimeout: httpServer.ReadHeaderTimeout,
+			ReadTimeout:       httpServer.ReadTimeout,
+			WriteTimeout:      httpServer.WriteTimeout,
+			IdleTimeout:       httpServer.IdleTimeout,
+		}
+		listeners = append(listeners, redirectServer.ListenAndServe)
+		servers = append(servers, redirectServer)
+	}
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.servers = servers
+	s.mu.Unlock()
+	models.InfoLog.Printf("Server run on %s://localhost%s", scheme, config.Port)
+	if len(servers) > 1 {
+		models.InfoLog.Printf("Redirecting http://localhost%s to HTTPS", config.TLS.RedirectAddr)
+	}
 
-	return s.httpServer.ListenAndServeTLS("", "")
+	// The first listener to stop decides; Shutdown closes the others.
+	errs := make(chan error, len(listeners))
+	for _, listen := range listeners {
+		go func() { errs <- listen() }()
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops taking connections and waits until the requests in flight
+// are answered or ctx ends.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	servers := s.servers
+	s.mu.Unlock()
+	var errs []error
+	for _, httpServer := range servers {
+		errs = append(errs, httpServer.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultCertFile = "secure/server.crt"
+	defaultKeyFile  = "secure/server.key"
+	defaultCacheDir = "secure/acme"
+
+	// reloadCheck is how often, at most, the certificate files are looked at.
+	reloadCheck = time.Second
+)
+
+// tlsConfig is the TLS setup of the main listener for mode, and for ACME
+// the manager whose challenges the redirect listener answers.
+func (c TLSConfig) tlsConfig() (*tls.Config, *autocert.Manager, error) {
+	tlsConfig := &tls.Config{
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+	switch c.Mode {
+	case "", "file":
+		certs, err := newCertReloader(orDefault(c.CertFile, defaultCertFile), orDefault(c.KeyFile, defaultKeyFile))
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetCertificate = certs.GetCertificate
+		return tlsConfig, nil, nil
+	case "acme":
+		manager, err := c.acmeManager()
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		return tlsConfig, manager, nil
+	}
+	return nil, nil, fmt.Errorf("tls: unknown mode %q", c.Mode)
+}
+
+// acmeManager gets the certificates of the domains from the ACME CA and
+// renews them before they expire.
+func (c TLSConfig) acmeManager() (*autocert.Manager, error) {
+	if len(c.Domains) == 0 {
+		return nil, errors.New("tls: acme mode needs domains")
+	}
+	client := &acme.Client{DirectoryURL: c.DirectoryURL}
+	if c.CARoot != "" {
+		pem, err := os.ReadFile(c.CARoot)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificate in %s", c.CARoot)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(orDefault(c.CacheDir, defaultCacheDir)),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+		Client:     client,
+	}, nil
+}
+
+// certReloader serves a certificate from files, loading them again when
+// they change so a renewed certificate is used without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := c.lastChange()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); now.Sub(c.checked) >= reloadCheck {
+		c.checked = now
+		modTime, err := c.lastChange()
+		if err == nil && !modTime.Equal(c.modTime) {
+			err = c.load(modTime)
+			if err == nil {
+				models.InfoLog.Printf("tls: reloaded %s", c.certFile)
+			}
+		}
+		if err != nil {
+			// A half written pair is read again on the next check; until
+			// then the certificate loaded before is still good.
+			models.ErrLog.Printf("tls: reload: %s", err)
+		}
+	}
+	return c.cert, nil
+}
+
+// lastChange is when the later of the two files was modified.
+func (c *certReloader) lastChange() (time.Time, error) {
+	var last time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// redirectHandler sends plain HTTP requests to the same URL over HTTPS on
+// port, the port of the TLS listener.
+func redirectHandler(port string) http.Handler {
+	_, port, _ = net.SplitHostPort(port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		switch {
+		case port != "" && port != "443":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCert(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	start := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, "old.example", start)
+
+	certs, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	name := func() string {
+		cert, err := certs.GetCertificate(nil)
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	require.Equal(t, "old.example", name())
+
+	writeCert(t, certFile, keyFile, "new.example", start.Add(time.Second))
+	require.Equal(t, "old.example", name(), "files are looked at once a second")
+	certs.checked = time.Time{}
+	require.Equal(t, "new.example", name())
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("half written"), 0o600))
+	certs.checked = time.Time{}
+	require.Equal(t, "new.example", name(), "a broken pair keeps the old certificate")
+
+	_, err = newCertReloader(filepath.Join(dir, "missing.crt"), keyFile)
+	require.Error(t, err)
+}
+
+func TestRedirect(t *testing.T) {
+	for _, c := range []struct {
+		port, method, target, location string
+		code                           int
+	}{
+		{":443", http.MethodGet, "http://forum.example/post/1?x=y", "https://forum.example/post/1?x=y", http.StatusMovedPermanently},
+		{":8443", http.MethodGet, "http://forum.example:8080/", "https://forum.example:8443/", http.StatusMovedPermanently},
+		{":443", http.MethodGet, "http://[::1]:80/", "https://[::1]/", http.StatusMovedPermanently},
+		{":443", http.MethodPost, "http://forum.example/signin", "https://forum.example/signin", http.StatusPermanentRedirect},
+	} {
+		w := httptest.NewRecorder()
+		redirectHandler(c.port).ServeHTTP(w, httptest.NewRequest(c.method, c.target, nil))
+		require.Equal(t, c.code, w.Code, c.target)
+		require.Equal(t, c.location, w.Header().Get("Location"), c.target)
+	}
+
+	_, _, err := TLSConfig{Mode: "acme"}.tlsConfig()
+	require.Error(t, err, "acme needs domains")
+	_, _, err = TLSConfig{Mode: "bogus"}.tlsConfig()
+	require.Error(t, err)
+}
@@ -0,0 +1,448 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/server"
+	"forum/internal/storage"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type AccountServiceIR interface {
+	RequestPasswordReset(email string) error
+	CheckResetToken(token string) error
+	ResetPassword(token, password, repeatPassword string) error
+	SendVerificationEmail(user models.User) error
+	VerifyEmail(token string) (int, error)
+
+	HasPassword(userID int) (bool, error)
+	StartSudo(userID int) error
+	SudoWithPassword(user models.User, password string) error
+	InSudo(userID int) (bool, error)
+	ChangePassword(userID int, password, repeatPassword string) error
+	RequestEmailChange(user models.User, newEmail string) error
+	GetPendingEmail(userID int) (string, error)
+	CheckEmailChange(token string, confirm bool) (models.EmailChange, error)
+	ConfirmEmailChange(token string) (models.EmailChange, error)
+	CancelEmailChange(token string) (models.EmailChange, error)
+
+	SendSecurityAlert(userID int, what string, event models.AuthLog) error
+	SecureAccount(userID int) error
+	CheckNotMeToken(token string) error
+	SecureAccountByToken(token string) (int, error)
+}
+
+var (
+	ErrEmailNotVerified = errors.New(" confirm your email first, the link can be sent again from settings")
+	ErrSudoRequired     = errors.New(" confirm it is you before changing security settings")
+	ErrSameEmail        = errors.New(" this is your current email")
+)
+
+const (
+	resetTokenTTL  = time.Hour
+	verifyTokenTTL = 48 * time.Hour
+	emailChangeTTL = 24 * time.Hour
+	notMeTokenTTL  = 7 * 24 * time.Hour
+	// sudoTTL is how long sensitive settings stay open after the user
+	// entered their password or used a passkey again.
+	sudoTTL = 10 * time.Minute
+)
+
+type AccountService struct {
+	storage *storage.Storage
+	mail    MailServiceIR
+	jobs    *JobQueue
+	key     []byte
+}
+
+func NewAccountService(storages *storage.Storage, mail MailServiceIR, jobs *JobQueue, config server.SecurityConfig) *AccountService {
+	key := []byte(config.TokenKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	a := &AccountService{
+		storage: storages,
+		mail:    mail,
+		jobs:    jobs,
+		key:     key,
+	}
+	jobs.Handle(JobLinkMail, 5, time.Minute, a.sendLinkMail)
+	return a
+}
+
+// RequestPasswordReset mails a reset link. An unknown email is not an
+// error, so the form does not tell which addresses have accounts.
+func (a *AccountService) RequestPasswordReset(email string) error {
+	user, err := a.storage.Auth.GetUserByEmail(strings.TrimSpace(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.sendResetLink(user)
+}
+
+func (a *AccountService) sendResetLink(user models.User) error {
+	seed, err := a.newToken(user.Id, "reset", resetTokenTTL)
+	if err != nil {
+		return err
+	}
+	return a.jobs.Enqueue(JobLinkMail, linkMail{
+		To:       user.Email,
+		Template: "reset",
+		Data:     map[string]string{"Username": user.Username},
+		Purpose:  "reset",
+		Path:     "/reset",
+		Seed:     seed,
+	})
+}
+
+func (a *AccountService) CheckResetToken(token string) error {
+	hash, err := a.tokenHash("reset", token)
+	if err != nil {
+		return err
+	}
+	_, err = a.storage.AccountIR.GetUserToken("reset", hash)
+	return err
+}
+
+// ResetPassword sets the new password and ends every session of the user.
+func (a *AccountService) ResetPassword(token, password, repeatPassword string) error {
+	hash, err := a.tokenHash("reset", token)
+	if err != nil {
+		return err
+	}
+	if !passIsValid(password) {
+		return models.ErrShortPassword
+	}
+	if password != repeatPassword {
+		return models.ErrPasswordDoesNotMatch
+	}
+	passwordHash, err := generateHashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = a.storage.AccountIR.ResetPassword(hash, passwordHash)
+	return err
+}
+
+func (a *AccountService) SendVerificationEmail(user models.User) error {
+	seed, err := a.newToken(user.Id, "verify", verifyTokenTTL)
+	if err != nil {
+		return err
+	}
+	return a.jobs.Enqueue(JobLinkMail, linkMail{
+		To:       user.Email,
+		Template: "verify",
+		Data:     map[string]string{"Username": user.Username},
+		Purpose:  "verify",
+		Path:     "/verify-email",
+		Seed:     seed,
+	})
+}
+
+// VerifyEmail confirms the email of the token's owner and returns their id.
+func (a *AccountService) VerifyEmail(token string) (int, error) {
+	hash, err := a.tokenHash("verify", token)
+	if err != nil {
+		return 0, err
+	}
+	return a.storage.AccountIR.VerifyEmail(hash)
+}
+
+func (a *AccountService) HasPassword(userID int) (bool, error) {
+	return a.storage.IdentityIR.HasPassword(userID)
+}
+
+// StartSudo opens the sudo window, after the handler checked a passkey.
+func (a *AccountService) StartSudo(userID int) error {
+	return a.storage.AccountIR.SetSudoUntil(userID, time.Now().Add(sudoTTL))
+}
+
+func (a *AccountService) SudoWithPassword(user models.User, password string) error {
+	hash, err := a.storage.Auth.GetPasswordByUsername(user.Username)
+	if err != nil {
+		return err
+	}
+	if err := compareHashAndPassword(hash, password); err != nil {
+		return err
+	}
+	return a.StartSudo(user.Id)
+}
+
+func (a *AccountService) InSudo(userID int) (bool, error) {
+	until, err := a.storage.AccountIR.GetSudoUntil(userID)
+	if err != nil {
+		return false, err
+	}
+	return time.Now().Before(until), nil
+}
+
+func (a *AccountService) ChangePassword(userID int, password, repeatPassword string) error {
+	if err := a.requireSudo(userID); err != nil {
+		return err
+	}
+	if !passIsValid(password) {
+		return models.ErrShortPassword
+	}
+	if password != repeatPassword {
+		return models.ErrPasswordDoesNotMatch
+	}
+	hash, err := generateHashPassword(password)
+	if err != nil {
+		return err
+	}
+	return a.storage.AccountIR.SetPassword(userID, hash)
+}
+
+// RequestEmailChange mails a confirmation link to the new address and a
+// notice with a cancel link to the current one. The email only changes
+// once the link is opened.
+func (a *AccountService) RequestEmailChange(user models.User, newEmail string) error {
+	if err := a.requireSudo(user.Id); err != nil {
+		return err
+	}
+	newEmail = strings.TrimSpace(newEmail)
+	if !emailIsValid(newEmail) {
+		return models.ErrInvalidEmail
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return ErrSameEmail
+	}
+	taken, err := a.storage.User.CheckUserByEmail(newEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return storage.ErrEmailTaken
+	}
+
+	confirm, cancel := randomToken(), randomToken()
+	confirmHash := hashToken(a.tokenRandom("email", confirm))
+	cancelHash := hashToken(a.tokenRandom("email-cancel", cancel))
+	if err := a.storage.AccountIR.CreateEmailChange(user.Id, newEmail, confirmHash, cancelHash, time.Now().Add(emailChangeTTL)); err != nil {
+		return err
+	}
+	if err := a.jobs.Enqueue(JobLinkMail, linkMail{
+		To:       newEmail,
+		Template: "email-confirm",
+		Data:     map[string]string{"Username": user.Username},
+		Purpose:  "email",
+		Path:     "/email/confirm",
+		Seed:     confirm,
+	}); err != nil {
+		return err
+	}
+	return a.jobs.Enqueue(JobLinkMail, linkMail{
+		To:       user.Email,
+		Template: "email-notice",
+		Data:     map[string]string{"Username": user.Username, "NewEmail": newEmail},
+		Purpose:  "email-cancel",
+		Path:     "/email/cancel",
+		Seed:     cancel,
+	})
+}
+
+func (a *AccountService) GetPendingEmail(userID int) (string, error) {
+	return a.storage.AccountIR.GetEmailChange(userID)
+}
+
+// CheckEmailChange returns the change the confirm or cancel link is for,
+// without acting on it yet.
+func (a *AccountService) CheckEmailChange(token string, confirm bool) (models.EmailChange, error) {
+	purpose := "email-cancel"
+	if confirm {
+		purpose = "email"
+	}
+	hash, err := a.tokenHash(purpose, token)
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	return a.storage.AccountIR.GetEmailChangeByHash(confirm, hash)
+}
+
+func (a *AccountService) ConfirmEmailChange(token string) (models.EmailChange, error) {
+	hash, err := a.tokenHash("email", token)
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	return a.storage.AccountIR.ConfirmEmailChange(hash)
+}
+
+func (a *AccountService) CancelEmailChange(token string) (models.EmailChange, error) {
+	hash, err := a.tokenHash("email-cancel", token)
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	return a.storage.AccountIR.CancelEmailChange(hash)
+}
+
+// SendSecurityAlert tells the user about event by mail and in their
+// notifications. The mail has a link for when it was not them.
+func (a *AccountService) SendSecurityAlert(userID int, what string, event models.AuthLog) error {
+	user, err := a.storage.User.GetUserById(userID)
+	if err != nil {
+		return err
+	}
+	if err := a.storage.NotificationIR.CreateMassageSystem(models.Message{ToUserId: userID, Message: "security:" + what}); err != nil {
+		return err
+	}
+	seed, err := a.newToken(userID, "not-me", notMeTokenTTL)
+	if err != nil {
+		return err
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return a.jobs.Enqueue(JobLinkMail, linkMail{
+		To:       user.Email,
+		Template: "security-alert",
+		Data: map[string]string{
+			"Username": user.Username,
+			"What":     what,
+			"When":     event.Time.Format("02 Jan 2006 15:04"),
+			"Where":    event.Location(),
+			"IP":       event.IP,
+			"Device":   event.Device,
+			"Security": a.mail.BaseURL() + "/security",
+		},
+		Purpose: "not-me",
+		Path:    "/security/not-me",
+		Seed:    seed,
+	})
+}
+
+// SecureAccount is the answer to "this wasn't me": every session ends, the
+// password stops working and a link to set a new one is mailed.
+func (a *AccountService) SecureAccount(userID int) error {
+	user, err := a.storage.User.GetUserById(userID)
+	if err != nil {
+		return err
+	}
+	if err := a.storage.AccountIR.LockAccount(userID); err != nil {
+		return err
+	}
+	return a.sendResetLink(user)
+}
+
+// CheckNotMeToken tells whether the link in a security alert still works,
+// without securing the account yet.
+func (a *AccountService) CheckNotMeToken(token string) error {
+	hash, err := a.tokenHash("not-me", token)
+	if err != nil {
+		return err
+	}
+	_, err = a.storage.AccountIR.GetUserToken("not-me", hash)
+	return err
+}
+
+// SecureAccountByToken secures the account of the link in a security alert
+// and returns its id.
+func (a *AccountService) SecureAccountByToken(token string) (int, error) {
+	hash, err := a.tokenHash("not-me", token)
+	if err != nil {
+		return 0, err
+	}
+	userID, err := a.storage.AccountIR.GetUserToken("not-me", hash)
+	if err != nil {
+		return 0, err
+	}
+	return userID, a.SecureAccount(userID)
+}
+
+func (a *AccountService) requireSudo(userID int) error {
+	ok, err := a.InSudo(userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSudoRequired
+	}
+	return nil
+}
+
+// newToken stores a token for purpose and returns its seed, which
+// linkToken turns into the token of the link.
+func (a *AccountService) newToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	seed := randomToken()
+	if err := a.storage.AccountIR.CreateUserToken(userID, purpose, hashToken(a.tokenRandom(purpose, seed)), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return seed, nil
+}
+
+// tokenRandom derives the random part of a token from its seed. Only the
+// key makes one from the other, so a seed waiting in the jobs table is not
+// a working link.
+func (a *AccountService) tokenRandom(purpose, seed string) string {
+	return a.sign("seed:"+purpose, seed)
+}
+
+// linkToken returns "<random>.<signature>" for seed. Only a hash of the
+// random part is stored; the signature lets forged links be refused without
+// a lookup.
+func (a *AccountService) linkToken(purpose, seed string) string {
+	random := a.tokenRandom(purpose, seed)
+	return random + "." + a.sign(purpose, random)
+}
+
+// linkMail is the payload of a mail with a one time link. It holds the seed
+// of the token and the template data without the link, which is only made
+// when the mail goes out.
+type linkMail struct {
+	To       string
+	Template string
+	Data     map[string]string
+	Purpose  string
+	Path     string
+	Seed     string
+}
+
+// sendLinkMail runs a link mail job.
+func (a *AccountService) sendLinkMail(ctx context.Context, payload []byte) error {
+	var mail linkMail
+	if err := json.Unmarshal(payload, &mail); err != nil {
+		return err
+	}
+	data := make(map[string]string, len(mail.Data)+1)
+	for k, v := range mail.Data {
+		data[k] = v
+	}
+	data["Link"] = a.mail.BaseURL() + mail.Path + "?token=" + url.QueryEscape(a.linkToken(mail.Purpose, mail.Seed))
+	return a.mail.SendTemplate(mail.To, mail.Template, data)
+}
+
+// tokenHash checks the signature and returns the hash the token is stored
+// under.
+func (a *AccountService) tokenHash(purpose, token string) (string, error) {
+	random, signature, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign(purpose, random))) {
+		return "", storage.ErrTokenInvalid
+	}
+	return hashToken(random), nil
+}
+
+func (a *AccountService) sign(purpose, random string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(purpose + ":" + random))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,10 +1,12 @@
 package service
 
 import (
+	"database/sql"
 	"errors"
 	"forum/internal/models"
 	"forum/internal/storage"
 	"regexp"
+	"strings"
 	"time"
 	"unicode"
 
@@ -20,8 +22,6 @@ type Auth interface {
 	GetTokenByUsername(username string) (string, time.Time, error)
 	DeleteToken(token string) error
 	DeleteTokenByUserID(userid int) error
-	CreateOrLoginByGoogle(user_g models.GoogleLoginUserData) (string, time.Time, error)
-	CreateOrLoginByGithub(user_g models.GithubUserData) (string, time.Time, error)
 
 	SaveEmailCode(username string, codeHash string, expiresAt time.Time) (string, error)
 	CheckEmailCode(username string, codeHash string) (bool, error)
@@ -31,7 +31,9 @@ type Auth interface {
 	HasWebAuthn(userID int) bool
 	GetUserIDByCredentialID(credentialID []byte) (int, error)
 	UpdateSignCount(credentialID []byte, signCount uint32) error
-	DeleteAllCredentialsByUserID(userID int64) error
+	ListCredentials(userID int) ([]models.WebAuthnCredential, error)
+	RenameCredential(userID int, id int64, name string) error
+	DeleteCredential(userID int, id int64) error
 	GetUserByUsername(username string) (models.User, error)
 }
 
@@ -121,11 +123,7 @@ func validUser(user models.User) error {
 			return models.ErrInvalidUserName
 		}
 	}
-	validEmail, err := regexp.MatchString(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`, user.Email)
-	if err != nil {
-		return err
-	}
-	if !validEmail {
+	if !emailIsValid(user.Email) {
 		return models.ErrInvalidEmail
 	}
 	if len(user.Username) < 6 || len(user.Username) >= 36 {
@@ -141,6 +139,12 @@ func validUser(user models.User) error {
 	return nil
 }
 
+var emailPattern = regexp.MustCompile(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`)
+
+func emailIsValid(email string) bool {
+	return emailPattern.MatchString(email)
+}
+
 func passIsValid(s string) bool {
 	var (
 		hasMinLen  = false
@@ -149,7 +153,7 @@ func passIsValid(s string) bool {
 		hasNumber  = false
 		hasSpecial = false
 	)
-	if len(s) >= 8 || len(s) <= 20 {
+	if len(s) >= 8 && len(s) <= 20 {
 		hasMinLen = true
 	}
 	for _, char := range s {
@@ -183,6 +187,7 @@ func (s *AuthService) CheckEmailCode(
 }
 
 func (s *AuthService) SaveCredentials(cred *models.WebAuthnCredential) error {
+	cred.Name = passkeyName(cred.Name)
 	return s.storage.Auth.SaveCredentials(cred)
 }
 
@@ -205,8 +210,54 @@ func (s *AuthService) UpdateSignCount(
 	return s.storage.Auth.UpdateSignCount(credentialID, signCount)
 }
 
-func (s *AuthService) DeleteAllCredentialsByUserID(userID int64) error {
-	return s.storage.Auth.DeleteAllCredentialsByUserID(userID)
+func (s *AuthService) ListCredentials(userID int) ([]models.WebAuthnCredential, error) {
+	return s.storage.Auth.ListCredentials(userID)
+}
+
+func (s *AuthService) RenameCredential(userID int, id int64, name string) error {
+	return s.storage.Auth.RenameCredential(userID, id, passkeyName(name))
+}
+
+// DeleteCredential revokes a passkey, but not the last way the user can
+// sign in.
+func (s *AuthService) DeleteCredential(userID int, id int64) error {
+	creds, err := s.storage.Auth.ListCredentials(userID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, cred := range creds {
+		found = found || cred.ID == id
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+	if len(creds) == 1 {
+		identities, err := s.storage.IdentityIR.GetIdentities(userID)
+		if err != nil {
+			return err
+		}
+		hasPassword, err := s.storage.IdentityIR.HasPassword(userID)
+		if err != nil {
+			return err
+		}
+		if !hasPassword && len(identities) == 0 {
+			return ErrLastLogin
+		}
+	}
+	return s.storage.Auth.DeleteCredential(userID, id)
+}
+
+// passkeyName cleans up the nickname typed for a passkey.
+func passkeyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Passkey"
+	}
+	if runes := []rune(name); len(runes) > 64 {
+		name = string(runes[:64])
+	}
+	return name
 }
 
 func (s *AuthService) GetUserByUsername(username string) (models.User, error) {
@@ -1,8 +1,12 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"forum/internal/models"
+	"forum/internal/server"
 	"forum/internal/storage"
+	"time"
 )
 
 type AuthRiskIR interface {
@@ -11,19 +15,61 @@ type AuthRiskIR interface {
 	GetRiskAssessmentByUserID(userID int) (models.RiskAssessment, error)
 	SaveAuthLog(log models.AuthLog) error
 	GetLogsByUserID(userID int) ([]models.AuthLog, error)
+	GetSignInsByUserID(userID, limit int) ([]models.AuthLog, error)
+	AlertRiskEscalated(assessment models.RiskAssessment, current models.AuthLog) error
+	AssessRisk(ctx context.Context, assessment models.RiskAssessment, current models.AuthLog) models.RiskAssessment
+	QueueRiskAssessment(assessment models.RiskAssessment, current models.AuthLog) error
+}
+
+// securityAlerter tells a user about a sign in that looks unusual.
+type securityAlerter interface {
+	SendSecurityAlert(userID int, what string, event models.AuthLog) error
+}
+
+// sessionEnder signs a user out everywhere.
+type sessionEnder interface {
+	DeleteTokenByUserID(userID int) error
+}
+
+// riskJob is the payload of a risk job: the assessment before the sign in,
+// the sign in itself and when the job was queued.
+type riskJob struct {
+	Assessment models.RiskAssessment
+	Current    models.AuthLog
+	Queued     time.Time
 }
 
 type AuthRiskService struct {
-	storage storage.AuthRiskIR
+	storage  storage.AuthRiskIR
+	sessions sessionEnder
+	alerts   securityAlerter
+	jobs     *JobQueue
+	engine   *riskEngine
+	geo      *geoIP
 }
 
-func NewAuthRiskService(storage storage.AuthRiskIR) AuthRiskIR {
-	return &AuthRiskService{
-		storage: storage,
+func NewAuthRiskService(storage storage.AuthRiskIR, sessions sessionEnder, alerts securityAlerter, jobs *JobQueue, config server.Config) AuthRiskIR {
+	rules, err := loadRiskRules(config.Risk.RulesFile)
+	if err != nil {
+		models.ErrLog.Printf("risk rules: %v, using the built in rules", err)
+		rules, _ = parseRiskRules(defaultRiskRules)
+	}
+	a := &AuthRiskService{
+		storage:  storage,
+		sessions: sessions,
+		alerts:   alerts,
+		jobs:     jobs,
+		engine:   newRiskEngine(rules, riskScorers(config.LLM)...),
+		geo:      openGeoIP(config.GeoIP),
 	}
+	jobs.Handle(JobRisk, 3, time.Minute, a.runRiskJob)
+	return a
 }
 
 func (a *AuthRiskService) CreateRiskAssessment(assessment models.RiskAssessment) error {
+	if assessment.PrimaryGeo == "" {
+		assessment.PrimaryGeo = a.geo.locate(models.AuthLog{IP: assessment.PrimaryIP}).Geo
+	}
 	return a.storage.CreateRiskAssessment(assessment)
 }
 
@@ -35,10 +81,129 @@ func (a *AuthRiskService) GetRiskAssessmentByUserID(userID int) (models.RiskAsse
 	return a.storage.GetRiskAssessmentByUserID(userID)
 }
 
+// SaveAuthLog records an auth event with the place of its IP. The first
+// sign in from a device, for a user who signed in before, is alerted.
 func (a *AuthRiskService) SaveAuthLog(log models.AuthLog) error {
-	return a.storage.SaveAuthLog(log)
+	log = a.geo.locate(log)
+	newDevice := false
+	if log.Status && log.SignIn() && log.Device != "" {
+		total, fromDevice, err := a.storage.CountSignIns(log.UserID, log.Device)
+		if err != nil {
+			models.ErrLog.Println(err)
+		}
+		newDevice = err == nil && total > 0 && fromDevice == 0
+	}
+	if err := a.storage.SaveAuthLog(log); err != nil {
+		return err
+	}
+	if newDevice && a.alerts != nil {
+		if err := a.alerts.SendSecurityAlert(log.UserID, "New sign in from a device you have not used before", log); err != nil {
+			models.ErrLog.Println(err)
+		}
+	}
+	return nil
 }
 
 func (a *AuthRiskService) GetLogsByUserID(userID int) ([]models.AuthLog, error) {
 	return a.storage.GetLogsByUserID(userID)
 }
+
+func (a *AuthRiskService) GetSignInsByUserID(userID, limit int) ([]models.AuthLog, error) {
+	return a.storage.GetSignInsByUserID(userID, limit)
+}
+
+// AlertRiskEscalated tells the user their sign in risk level went up with
+// current.
+func (a *AuthRiskService) AlertRiskEscalated(assessment models.RiskAssessment, current models.AuthLog) error {
+	if a.alerts == nil {
+		return nil
+	}
+	what := "A sign in to your account looked risky: " + assessment.Reason
+	return a.alerts.SendSecurityAlert(assessment.UserID, what, a.geo.locate(current))
+}
+
+// AssessRisk scores the sign in current against the assessment and its
+// logs and returns the assessment with the new level and why.
+func (a *AuthRiskService) AssessRisk(ctx context.Context, assessment models.RiskAssessment, current models.AuthLog) models.RiskAssessment {
+	now := time.Now()
+	current = a.geo.locate(current)
+	// jobs queued before the logs were read filtered may carry other events
+	logs := assessment.AuthLogs[:0:0]
+	for _, lg := range assessment.AuthLogs {
+		if lg.SignIn() {
+			logs = append(logs, lg)
+		}
+	}
+	assessment.AuthLogs = logs
+	// the first place the user is seen signing in from becomes their home
+	if assessment.PrimaryGeo == "" && current.Status {
+		assessment.PrimaryGeo = current.Geo
+	}
+	level, explanation := a.engine.Assess(ctx, RiskInput{
+		Assessment: assessment,
+		Current:    current,
+		Now:        now,
+	})
+	// a level an admin pinned stays; what the engine found is still kept
+	if !assessment.Pinned(now) {
+		assessment.RiskLevel = level
+		assessment.Reason = riskSummary(explanation)
+	}
+	assessment.Explanation = explanation
+	assessment.AssessedAt = now.Truncate(time.Second)
+	return assessment
+}
+
+// QueueRiskAssessment lets the risk engine judge the sign in current in the
+// background. The level is used from the next sign in on.
+func (a *AuthRiskService) QueueRiskAssessment(assessment models.RiskAssessment, current models.AuthLog) error {
+	return a.jobs.Enqueue(JobRisk, riskJob{Assessment: assessment, Current: current, Queued: time.Now()})
+}
+
+// runRiskJob assesses a sign in and saves the new level. When it rises the
+// sessions of the user end at once and the user is alerted. The assessment
+// is read again, as it may have changed since the job was queued: a job
+// that an admin override or a later assessment overtook is dropped. The
+// sessions end before the level is saved, so a retry still ends them.
+func (a *AuthRiskService) runRiskJob(ctx context.Context, payload []byte) error {
+	var job riskJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	queued := job.Queued
+	if queued.IsZero() {
+		// queued before the time was kept
+		queued = job.Assessment.AssessedAt
+	}
+	stored, err := a.storage.GetRiskAssessmentByUserID(job.Assessment.UserID)
+	if err != nil {
+		return err
+	}
+	overridden, err := a.storage.RiskOverriddenSince(stored.UserID, queued)
+	if err != nil {
+		return err
+	}
+	if overridden || stored.Pinned(time.Now()) || stored.AssessedAt.After(queued) {
+		models.InfoLog.Println("risk job dropped: the assessment of user", stored.UserID, "changed since it was queued")
+		return nil
+	}
+	assessment := a.AssessRisk(ctx, stored, job.Current)
+	escalated := riskPriority(assessment.RiskLevel) > riskPriority(stored.RiskLevel)
+	if escalated {
+		if err := a.sessions.DeleteTokenByUserID(assessment.UserID); err != nil {
+			return err
+		}
+	}
+	if err := a.storage.UpdateRiskAssessment(assessment); err != nil {
+		return err
+	}
+	models.InfoLog.Println("risk level updated:", assessment.RiskLevel, assessment.Reason)
+	if !escalated {
+		return nil
+	}
+	models.InfoLog.Println("risk escalated:", stored.RiskLevel, "→", assessment.RiskLevel)
+	if err := a.AlertRiskEscalated(assessment, job.Current); err != nil {
+		models.ErrLog.Println("risk alert:", err)
+	}
+	return nil
+}
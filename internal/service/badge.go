@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"time"
+)
+
+type BadgeServiceIR interface {
+	GetUserBadges(userID int) ([]models.UserBadge, error)
+	EvaluateBadges(userID int) error
+	AwardMonthlyBadges(now time.Time) error
+	RunBadges(ctx context.Context, interval time.Duration)
+}
+
+// badgeRule awards code once, as soon as earned holds for a user. Badges that
+// are won per period (top commenter) are handed out by AwardMonthlyBadges.
+type badgeRule struct {
+	code   string
+	earned func(stats models.BadgeStats) bool
+}
+
+var badgeRules = []badgeRule{
+	{code: "first_post", earned: func(s models.BadgeStats) bool { return s.Posts >= 1 }},
+	{code: "likes_100", earned: func(s models.BadgeStats) bool { return s.LikesReceived >= 100 }},
+	{code: "member_1y", earned: func(s models.BadgeStats) bool { return s.MemberDays >= 365 }},
+}
+
+type BadgeService struct {
+	storage storage.BadgeIR
+	notify  storage.NotificationIR
+}
+
+func NewBadgeService(storage storage.BadgeIR, notify storage.NotificationIR) *BadgeService {
+	return &BadgeService{
+		storage: storage,
+		notify:  notify,
+	}
+}
+
+func (b *BadgeService) GetUserBadges(userID int) ([]models.UserBadge, error) {
+	return b.storage.GetUserBadges(userID)
+}
+
+// EvaluateBadges checks every rule for userID. It is called after the events
+// that can earn a badge and for everyone by RunBadges.
+func (b *BadgeService) EvaluateBadges(userID int) error {
+	stats, err := b.storage.GetBadgeStats(userID)
+	if err != nil {
+		return err
+	}
+	for _, rule := range badgeRules {
+		if !rule.earned(stats) {
+			continue
+		}
+		if err := b.award(userID, rule.code, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AwardMonthlyBadges names the top commenter of the month before now.
+func (b *BadgeService) AwardMonthlyBadges(now time.Time) error {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0).Format("2006-01")
+	userID, err := b.storage.GetTopCommenter(month)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return b.award(userID, "top_commenter", month)
+}
+
+func (b *BadgeService) award(userID int, code, period string) error {
+	awarded, err := b.storage.AwardBadge(userID, code, period)
+	if err != nil || !awarded {
+		return err
+	}
+	models.InfoLog.Printf("badge: user %d earned %s %s", userID, code, period)
+	return b.notify.CreateMassageSystem(models.Message{ToUserId: userID, Message: "badge:" + code})
+}
+
+// RunBadges re-evaluates everyone every interval until ctx is cancelled. This
+// backfills badges for existing users and catches the time based ones.
+func (b *BadgeService) RunBadges(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.evaluateAll(time.Now()); err != nil {
+			models.ErrLog.Println("badges:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *BadgeService) evaluateAll(now time.Time) error {
+	ids, err := b.storage.GetUserIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := b.EvaluateBadges(id); err != nil {
+			return err
+		}
+	}
+	return b.AwardMonthlyBadges(now)
+}
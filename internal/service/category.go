@@ -0,0 +1,274 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type CategoryServiceIR interface {
+	GetCategories() ([]models.Category, error)
+	GetCategory(key string) (models.Category, error)
+	AddCategory(category models.Category) error
+	UpdateCategory(category models.Category) error
+	DeleteCategory(name, moveTo string) (archived bool, err error)
+	SetCategoryModerator(name, username string, add bool) error
+	CheckCategories(names []string) error
+	CanModeratePost(user models.User, post models.Post) (bool, error)
+	GetModeratedCategories(userID int) ([]string, error)
+	GetWaitPostsByModerator(userID int) ([]models.Post, error)
+}
+
+var (
+	ErrCategoryExists   = errors.New(" category already exists")
+	ErrCategoryArchived = errors.New(" this category is archived and takes no new posts")
+)
+
+type CategoryService struct {
+	storage storage.CategoryIR
+}
+
+func NewCategoryService(storage storage.CategoryIR) *CategoryService {
+	return &CategoryService{
+		storage: storage,
+	}
+}
+
+// GetCategories returns the categories ordered for display: every top level
+// category followed by its subcategories.
+func (c *CategoryService) GetCategories() ([]models.Category, error) {
+	categories, err := c.storage.Category()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, category := range categories {
+		names[category.Name] = true
+	}
+	children := map[string][]models.Category{}
+	var top []models.Category
+	for _, category := range categories {
+		if category.Parent != "" && names[category.Parent] {
+			children[category.Parent] = append(children[category.Parent], category)
+			continue
+		}
+		top = append(top, category)
+	}
+	ordered := make([]models.Category, 0, len(categories))
+	for _, category := range top {
+		ordered = append(ordered, category)
+		ordered = append(ordered, children[category.Name]...)
+	}
+	return ordered, nil
+}
+
+// GetCategory finds a category by slug, or by name for the old links.
+func (c *CategoryService) GetCategory(key string) (models.Category, error) {
+	categories, err := c.storage.Category()
+	if err != nil {
+		return models.Category{}, err
+	}
+	for _, category := range categories {
+		if category.Slug == key || category.Name == key {
+			return category, nil
+		}
+	}
+	return models.Category{}, sql.ErrNoRows
+}
+
+func (c *CategoryService) AddCategory(category models.Category) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if len(category.Name) == 0 || len(category.Name) >= 40 || strings.Contains(category.Name, ",") {
+		return errors.New(" category name should be shorter than 40 symbols, not empty and without commas")
+	}
+	category.Slug = slugify(category.Name)
+	if category.Slug == "" {
+		return errors.New(" category name needs at least one letter or digit")
+	}
+	categories, err := c.storage.Category()
+	if err != nil {
+		return err
+	}
+	for _, exist := range categories {
+		if strings.EqualFold(exist.Name, category.Name) || exist.Slug == category.Slug {
+			return ErrCategoryExists
+		}
+	}
+	if err := checkCategoryFields(category, categories); err != nil {
+		return err
+	}
+	return c.storage.AddCategory(category)
+}
+
+// UpdateCategory changes everything but the name, which posts refer to.
+func (c *CategoryService) UpdateCategory(category models.Category) error {
+	categories, err := c.storage.Category()
+	if err != nil {
+		return err
+	}
+	if err := checkCategoryFields(category, categories); err != nil {
+		return err
+	}
+	return c.storage.UpdateCategory(category)
+}
+
+// checkCategoryFields validates the editable fields. Categories nest one
+// level deep: the parent must be a top level category and a category with
+// subcategories cannot get a parent itself.
+func checkCategoryFields(category models.Category, categories []models.Category) error {
+	category.Description = strings.TrimSpace(category.Description)
+	if len(category.Description) > 200 {
+		return errors.New(" category description should be shorter than 200 symbols")
+	}
+	if utf8.RuneCountInString(category.Icon) > 8 {
+		return errors.New(" category icon should be an emoji or a few symbols")
+	}
+	if category.Parent == "" {
+		return nil
+	}
+	if category.Parent == category.Name {
+		return errors.New(" a category cannot be its own parent")
+	}
+	found := false
+	for _, exist := range categories {
+		if exist.Name == category.Parent {
+			if exist.Parent != "" {
+				return errors.New(" subcategories cannot have subcategories")
+			}
+			found = true
+		}
+		if exist.Parent == category.Name {
+			return errors.New(" a category with subcategories cannot have a parent")
+		}
+	}
+	if !found {
+		return fmt.Errorf(" parent category %q does not exist", category.Parent)
+	}
+	return nil
+}
+
+// DeleteCategory deletes a category safely. With moveTo its posts are
+// reassigned to that category first. Without it a category that still has
+// posts is archived instead, so no post loses its category.
+func (c *CategoryService) DeleteCategory(name, moveTo string) (bool, error) {
+	category, err := c.GetCategory(name)
+	if err != nil {
+		return false, err
+	}
+	if moveTo != "" {
+		target, err := c.GetCategory(moveTo)
+		if err != nil {
+			return false, fmt.Errorf(" category %q does not exist", moveTo)
+		}
+		if target.Name == category.Name {
+			return false, errors.New(" posts cannot be moved to the deleted category")
+		}
+		return false, c.storage.DeleteCategory(category.Name, target.Name)
+	}
+	count, err := c.storage.CountCategoryPosts(category.Name)
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, c.storage.DeleteCategory(category.Name, "")
+	}
+	category.Archived = true
+	return true, c.storage.UpdateCategory(category)
+}
+
+func (c *CategoryService) SetCategoryModerator(name, username string, add bool) error {
+	category, err := c.GetCategory(name)
+	if err != nil {
+		return err
+	}
+	username = strings.TrimSpace(username)
+	if !add {
+		return c.storage.RemoveCategoryModerator(category.Name, username)
+	}
+	if err := c.storage.AddCategoryModerator(category.Name, username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf(" user %q does not exist", username)
+		}
+		return err
+	}
+	return nil
+}
+
+// CheckCategories makes sure new posts only go to existing categories that
+// are not archived.
+func (c *CategoryService) CheckCategories(names []string) error {
+	categories, err := c.storage.Category()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, category := range categories {
+			if category.Name != name {
+				continue
+			}
+			if category.Archived {
+				return ErrCategoryArchived
+			}
+			found = true
+		}
+		if !found {
+			return errors.New("INVALID CATEGORY, please select existing categories ")
+		}
+	}
+	return nil
+}
+
+// CanModeratePost tells whether the user may approve or refuse the post:
+// moderators everywhere, category moderators in their categories only.
+func (c *CategoryService) CanModeratePost(user models.User, post models.Post) (bool, error) {
+	if !user.IsAuth {
+		return false, nil
+	}
+	if user.Rol == "moderator" {
+		return true, nil
+	}
+	moderated, err := c.storage.GetModeratedCategories(user.Id)
+	if err != nil {
+		return false, err
+	}
+	for _, category := range post.Category {
+		if containsString(moderated, strings.TrimSpace(category)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *CategoryService) GetModeratedCategories(userID int) ([]string, error) {
+	return c.storage.GetModeratedCategories(userID)
+}
+
+func (c *CategoryService) GetWaitPostsByModerator(userID int) ([]models.Post, error) {
+	return c.storage.GetWaitPostsByModerator(userID)
+}
+
+// slugify keeps letters and digits, lowercased, and joins the words with
+// dashes.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
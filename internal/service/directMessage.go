@@ -0,0 +1,240 @@
+package service
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"strings"
+	"unicode/utf8"
+)
+
+type DirectMessageServiceIR interface {
+	GetInbox(userID int) ([]models.Conversation, error)
+	StartConversation(userID int, usernames []string, title string) (int, error)
+	GetThread(conversationID, userID int) (models.Conversation, []models.DirectMessage, error)
+	SendDirectMessage(msg models.DirectMessage) error
+	CountUnreadMessages(userID int) (int, error)
+	BlockUser(userID int, username string) error
+	UnblockUser(userID, blockedID int) error
+	GetBlockedUsers(userID int) ([]models.User, error)
+	ReportConversation(report models.DMReport) error
+	GetOpenDMReports() ([]models.DMReport, error)
+	GetReportedConversation(reportID int) (models.DMReport, models.Conversation, []models.DirectMessage, error)
+	ResolveDMReport(reportID, moderatorID int) error
+}
+
+const (
+	maxDirectMessageLen = 1000
+	maxConversationSize = 8
+)
+
+var (
+	ErrNotConversationMember = errors.New(" conversation not found")
+	ErrUserBlocked           = errors.New(" you can not message this user")
+	ErrMessageNotFound       = errors.New(" message not found")
+	ErrReportResolved        = errors.New(" the report is resolved")
+)
+
+type DirectMessageService struct {
+	storage storage.DirectMessageIR
+}
+
+func NewDirectMessageService(storage storage.DirectMessageIR) *DirectMessageService {
+	return &DirectMessageService{
+		storage: storage,
+	}
+}
+
+func (d *DirectMessageService) GetInbox(userID int) ([]models.Conversation, error) {
+	return d.storage.GetConversations(userID)
+}
+
+// StartConversation opens a thread between userID and the given usernames.
+// A one-to-one thread is reused if it already exists.
+func (d *DirectMessageService) StartConversation(userID int, usernames []string, title string) (int, error) {
+	var memberIDs []int
+	seen := map[int]bool{userID: true}
+	for _, name := range usernames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, err := d.storage.GetUserIdByName(name)
+		if err != nil {
+			return 0, errors.New(" user " + name + " not found")
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		memberIDs = append(memberIDs, id)
+	}
+	if len(memberIDs) == 0 {
+		return 0, errors.New(" choose at least one recipient")
+	}
+	if len(memberIDs)+1 > maxConversationSize {
+		return 0, errors.New(" a conversation can have at most 8 members")
+	}
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > 60 {
+		return 0, errors.New(" conversation title should be shorter than 61 symbols")
+	}
+	blocked, err := d.storage.HasBlockBetween(userID, memberIDs)
+	if err != nil {
+		return 0, err
+	}
+	if blocked {
+		return 0, ErrUserBlocked
+	}
+
+	if len(memberIDs) == 1 {
+		id, err := d.storage.FindDirectConversation(userID, memberIDs[0])
+		if err != nil {
+			return 0, err
+		}
+		if id != 0 {
+			return id, nil
+		}
+		title = ""
+	}
+	return d.storage.CreateConversation(userID, title, append([]int{userID}, memberIDs...))
+}
+
+// GetThread returns a conversation with its messages and marks it as read.
+func (d *DirectMessageService) GetThread(conversationID, userID int) (models.Conversation, []models.DirectMessage, error) {
+	if err := d.checkMember(conversationID, userID); err != nil {
+		return models.Conversation{}, nil, err
+	}
+	conversation, err := d.storage.GetConversation(conversationID)
+	if err != nil {
+		return models.Conversation{}, nil, err
+	}
+	messages, err := d.storage.GetDirectMessages(conversationID)
+	if err != nil {
+		return models.Conversation{}, nil, err
+	}
+	if err := d.storage.MarkConversationRead(conversationID, userID); err != nil {
+		return models.Conversation{}, nil, err
+	}
+	return conversation, messages, nil
+}
+
+func (d *DirectMessageService) SendDirectMessage(msg models.DirectMessage) error {
+	msg.Body = strings.TrimSpace(msg.Body)
+	if msg.Body == "" && msg.Image == "" {
+		return errors.New(" message is empty")
+	}
+	if utf8.RuneCountInString(msg.Body) > maxDirectMessageLen {
+		return errors.New(" message should be shorter than 1001 symbols")
+	}
+	if err := d.checkMember(msg.ConversationId, msg.SenderId); err != nil {
+		return err
+	}
+	conversation, err := d.storage.GetConversation(msg.ConversationId)
+	if err != nil {
+		return err
+	}
+	var others []int
+	for _, member := range conversation.Members {
+		if member.Id != msg.SenderId {
+			others = append(others, member.Id)
+		}
+	}
+	blocked, err := d.storage.HasBlockBetween(msg.SenderId, others)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrUserBlocked
+	}
+	_, err = d.storage.CreateDirectMessage(msg)
+	return err
+}
+
+func (d *DirectMessageService) CountUnreadMessages(userID int) (int, error) {
+	return d.storage.CountUnreadMessages(userID)
+}
+
+func (d *DirectMessageService) BlockUser(userID int, username string) error {
+	id, err := d.storage.GetUserIdByName(strings.TrimSpace(username))
+	if err != nil {
+		return errors.New(" user " + username + " not found")
+	}
+	if id == userID {
+		return errors.New(" you can not block yourself")
+	}
+	return d.storage.BlockUser(userID, id)
+}
+
+func (d *DirectMessageService) UnblockUser(userID, blockedID int) error {
+	return d.storage.UnblockUser(userID, blockedID)
+}
+
+func (d *DirectMessageService) GetBlockedUsers(userID int) ([]models.User, error) {
+	return d.storage.GetBlockedUsers(userID)
+}
+
+func (d *DirectMessageService) ReportConversation(report models.DMReport) error {
+	if err := d.checkMember(report.ConversationId, report.ReporterId); err != nil {
+		return err
+	}
+	// a message of another conversation would open that one to staff
+	if report.MessageId != 0 {
+		ok, err := d.storage.IsConversationMessage(report.ConversationId, report.MessageId)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrMessageNotFound
+		}
+	}
+	report.Reason = strings.TrimSpace(report.Reason)
+	if report.Reason == "" {
+		return errors.New(" please describe the problem")
+	}
+	if utf8.RuneCountInString(report.Reason) > 500 {
+		return errors.New(" reason should be shorter than 501 symbols")
+	}
+	return d.storage.CreateDMReport(report)
+}
+
+func (d *DirectMessageService) GetOpenDMReports() ([]models.DMReport, error) {
+	return d.storage.GetOpenDMReports()
+}
+
+// GetReportedConversation is the only way staff can read a private
+// conversation: through an open report filed by one of its members. Once
+// the report is resolved the conversation is private again.
+func (d *DirectMessageService) GetReportedConversation(reportID int) (models.DMReport, models.Conversation, []models.DirectMessage, error) {
+	report, err := d.storage.GetDMReport(reportID)
+	if err != nil {
+		return models.DMReport{}, models.Conversation{}, nil, err
+	}
+	if report.Status != "open" {
+		return models.DMReport{}, models.Conversation{}, nil, ErrReportResolved
+	}
+	conversation, err := d.storage.GetConversation(report.ConversationId)
+	if err != nil {
+		return models.DMReport{}, models.Conversation{}, nil, err
+	}
+	messages, err := d.storage.GetDirectMessages(report.ConversationId)
+	if err != nil {
+		return models.DMReport{}, models.Conversation{}, nil, err
+	}
+	return report, conversation, messages, nil
+}
+
+func (d *DirectMessageService) ResolveDMReport(reportID, moderatorID int) error {
+	return d.storage.ResolveDMReport(reportID, moderatorID)
+}
+
+func (d *DirectMessageService) checkMember(conversationID, userID int) error {
+	ok, err := d.storage.IsConversationMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotConversationMember
+	}
+	return nil
+}
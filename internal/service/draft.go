@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"os"
+	"strings"
+	"time"
+)
+
+type DraftServiceIR interface {
+	SaveDraft(post models.Post) (int, error)
+	GetDraft(id, authorID int) (models.Post, error)
+	GetDrafts(authorID int) ([]models.Post, error)
+	DeleteDraft(id, authorID int) error
+	UnschedulePost(id, authorID int) error
+	SubmitDraft(post models.Post) error
+	SchedulePost(post models.Post, publishAt time.Time) (int, error)
+	PublishDuePosts() error
+	RunScheduler(ctx context.Context, interval time.Duration)
+}
+
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+var ErrScheduleTime = errors.New(" publish time should be in the future and within a year")
+
+type DraftService struct {
+	storage    storage.DraftIR
+	users      storage.User
+	reputation ReputationServiceIR
+	badges     BadgeServiceIR
+}
+
+func NewDraftService(storage storage.DraftIR, users storage.User, reputation ReputationServiceIR, badges BadgeServiceIR) *DraftService {
+	return &DraftService{
+		storage:    storage,
+		users:      users,
+		reputation: reputation,
+		badges:     badges,
+	}
+}
+
+// SaveDraft stores whatever the author has so far; only the upper limits of a
+// post apply. A new image replaces the old one.
+func (d *DraftService) SaveDraft(post models.Post) (int, error) {
+	post.Title = strings.TrimSpace(post.Title)
+	post.Description = strings.TrimSpace(post.Description)
+	if len(post.Title) >= 80 || len(post.Description) > 600 {
+		return 0, errors.New(" draft is too long for a post")
+	}
+	var old models.Post
+	if post.Id != 0 && post.Image != "" {
+		var err error
+		if old, err = d.storage.GetDraft(post.Id, post.UserId); err != nil {
+			return 0, err
+		}
+	}
+	id, err := d.storage.SaveDraft(post)
+	if err != nil {
+		return 0, err
+	}
+	if old.Image != "" && old.Image != post.Image {
+		removePostImage(old.Image)
+	}
+	return id, nil
+}
+
+func (d *DraftService) GetDraft(id, authorID int) (models.Post, error) {
+	return d.storage.GetDraft(id, authorID)
+}
+
+func (d *DraftService) GetDrafts(authorID int) ([]models.Post, error) {
+	return d.storage.GetDrafts(authorID)
+}
+
+func (d *DraftService) DeleteDraft(id, authorID int) error {
+	post, err := d.storage.GetDraft(id, authorID)
+	if err != nil {
+		return err
+	}
+	if err := d.storage.DeleteDraft(id, authorID); err != nil {
+		return err
+	}
+	removePostImage(post.Image)
+	return nil
+}
+
+func (d *DraftService) UnschedulePost(id, authorID int) error {
+	return d.storage.UnschedulePost(id, authorID)
+}
+
+// SubmitDraft sends a finished draft to the moderation queue, like a post
+// created in one go.
+func (d *DraftService) SubmitDraft(post models.Post) error {
+	if err := validatePost(&post); err != nil {
+		return err
+	}
+	return d.storage.SubmitDraft(post, "waiting", time.Time{})
+}
+
+// SchedulePost validates post as if it was published now and leaves it for
+// the scheduler. A post without an id is saved as a draft first.
+func (d *DraftService) SchedulePost(post models.Post, publishAt time.Time) (int, error) {
+	if err := validatePost(&post); err != nil {
+		return 0, err
+	}
+	if now := time.Now(); !publishAt.After(now) || publishAt.After(now.Add(maxScheduleAhead)) {
+		return 0, ErrScheduleTime
+	}
+	if post.Id == 0 {
+		id, err := d.storage.SaveDraft(post)
+		if err != nil {
+			return 0, err
+		}
+		post.Id = id
+	}
+	return post.Id, d.storage.SubmitDraft(post, "scheduled", publishAt)
+}
+
+// PublishDuePosts releases the scheduled posts whose time has come. Whether a
+// post skips moderation is decided now, with the author's current role and
+// reputation.
+func (d *DraftService) PublishDuePosts() error {
+	posts, err := d.storage.GetDuePosts()
+	if err != nil {
+		return err
+	}
+	for _, post := range posts {
+		author, err := d.users.GetUserById(post.UserId)
+		if err != nil {
+			return err
+		}
+		trusted, err := d.reputation.CanSkipModeration(author)
+		if err != nil {
+			return err
+		}
+		status := "waiting"
+		if trusted {
+			status = "done"
+		}
+		if err := d.storage.PublishScheduledPost(post.Id, status); err != nil {
+			return err
+		}
+		models.InfoLog.Printf("scheduler: post %d is now %s", post.Id, status)
+		if trusted {
+			if err := d.badges.EvaluateBadges(author.Id); err != nil {
+				models.ErrLog.Println("badges:", err)
+			}
+		}
+	}
+	return nil
+}
+
+// RunScheduler publishes due posts every interval until ctx is cancelled.
+func (d *DraftService) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.PublishDuePosts(); err != nil {
+			models.ErrLog.Println("scheduler:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func removePostImage(name string) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return
+	}
+	if err := os.Remove("./front/static/data/" + name); err != nil && !os.IsNotExist(err) {
+		models.ErrLog.Println("remove post image:", err)
+	}
+}
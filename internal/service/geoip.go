@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/server"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoLookup is what geoIP needs from a MaxMind database reader.
+type geoLookup interface {
+	Lookup(ip net.IP, result any) error
+}
+
+// geoIP resolves addresses to a place and a network from local MaxMind
+// format databases, GeoLite2 or GeoIP2. Either database may be missing;
+// nothing is asked over the network.
+type geoIP struct {
+	city geoLookup
+	asn  geoLookup
+}
+
+type geoCityRecord struct {
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type geoASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// openGeoIP opens the configured databases. One that cannot be opened is
+// logged and skipped, so sign ins go on without places.
+func openGeoIP(config server.GeoIPConfig) *geoIP {
+	g := &geoIP{}
+	if config.CityDB != "" {
+		if reader, err := maxminddb.Open(config.CityDB); err != nil {
+			models.ErrLog.Printf("geoip city database: %v", err)
+		} else {
+			g.city = reader
+		}
+	}
+	if config.ASNDB != "" {
+		if reader, err := maxminddb.Open(config.ASNDB); err != nil {
+			models.ErrLog.Printf("geoip asn database: %v", err)
+		} else {
+			g.asn = reader
+		}
+	}
+	return g
+}
+
+// locate fills the country, city and network of log from its IP. Fields
+// already set are kept.
+func (g *geoIP) locate(log models.AuthLog) models.AuthLog {
+	ip := net.ParseIP(log.IP)
+	if g == nil || ip == nil || ip.IsLoopback() || ip.IsPrivate() {
+		return log
+	}
+	if g.city != nil && log.Geo == "" {
+		var record geoCityRecord
+		if err := g.city.Lookup(ip, &record); err != nil {
+			models.ErrLog.Printf("geoip lookup %s: %v", log.IP, err)
+		} else {
+			log.Geo = record.Country.Names["en"]
+			log.City = record.City.Names["en"]
+		}
+	}
+	if g.asn != nil && log.ASN == "" {
+		var record geoASNRecord
+		if err := g.asn.Lookup(ip, &record); err != nil {
+			models.ErrLog.Printf("geoip lookup %s: %v", log.IP, err)
+		} else if record.Number != 0 {
+			log.ASN = strings.TrimSpace(fmt.Sprintf("AS%d %s", record.Number, record.Organization))
+		}
+	}
+	return log
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"forum/internal/models"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeGeoDB map[string]any
+
+func (f fakeGeoDB) Lookup(ip net.IP, result any) error {
+	record, ok := f[ip.String()]
+	if !ok {
+		return nil
+	}
+	switch result := result.(type) {
+	case *geoCityRecord:
+		*result = record.(geoCityRecord)
+	case *geoASNRecord:
+		*result = record.(geoASNRecord)
+	default:
+		return errors.New("unexpected record")
+	}
+	return nil
+}
+
+func TestGeoIPLocate(t *testing.T) {
+	var city geoCityRecord
+	city.Country.Names = map[string]string{"en": "Kazakhstan"}
+	city.City.Names = map[string]string{"en": "Almaty"}
+	g := &geoIP{
+		city: fakeGeoDB{"203.0.113.5": city},
+		asn:  fakeGeoDB{"203.0.113.5": geoASNRecord{Number: 9198, Organization: "JSC Kazakhtelecom"}},
+	}
+
+	log := g.locate(models.AuthLog{IP: "203.0.113.5"})
+	require.Equal(t, "Kazakhstan", log.Geo)
+	require.Equal(t, "Almaty", log.City)
+	require.Equal(t, "AS9198 JSC Kazakhtelecom", log.ASN)
+	require.Equal(t, "Almaty, Kazakhstan", log.Location())
+
+	require.Empty(t, g.locate(models.AuthLog{IP: "198.51.100.1"}).Location(), "unknown address")
+	require.Empty(t, g.locate(models.AuthLog{IP: "192.168.1.10"}).Location(), "private address")
+	require.Equal(t, "Brazil", g.locate(models.AuthLog{IP: "203.0.113.5", Geo: "Brazil"}).Geo, "already set")
+
+	var none *geoIP
+	require.Equal(t, "203.0.113.5", none.locate(models.AuthLog{IP: "203.0.113.5"}).IP)
+}
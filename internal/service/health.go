@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"time"
+)
+
+type HealthServiceIR interface {
+	Readiness(ctx context.Context) (bool, []models.HealthCheck)
+}
+
+// healthTimeout bounds each readiness check, so a hung dependency does not
+// hang the probe.
+const healthTimeout = 2 * time.Second
+
+type HealthService struct {
+	storage storage.HealthIR
+}
+
+func NewHealthService(storage storage.HealthIR) *HealthService {
+	return &HealthService{storage: storage}
+}
+
+// Readiness checks the database, Redis and the schema. The forum is ready
+// when all of them are fine.
+func (h *HealthService) Readiness(ctx context.Context) (bool, []models.HealthCheck) {
+	checks := []struct {
+		name  string
+		check func(context.Context) error
+	}{
+		{"db", h.storage.PingDB},
+		{"redis", h.storage.PingRedis},
+		{"migrations", h.storage.CheckSchema},
+	}
+	ready := true
+	results := make([]models.HealthCheck, 0, len(checks))
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, healthTimeout)
+		err := c.check(checkCtx)
+		cancel()
+		result := models.HealthCheck{Name: c.name, OK: err == nil}
+		if err != nil {
+			ready = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return ready, results
+}
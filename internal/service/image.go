@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	AvatarDir = "./front/static/useravatars/"
+	BannerDir = "./front/static/jpg/art/"
+	// PendingImageDir keeps uploads until an image job has cropped them.
+	PendingImageDir = "./uploads/"
+
+	avatarSize   = 256
+	bannerWidth  = 1500
+	bannerHeight = 500
+	// uploads larger than this (in pixels per side) are refused before decoding
+	maxImageSide = 8000
+)
+
+var ErrImageTooLarge = errors.New(" image is too large")
+
+// checkImageSize reads the header of an upload and refuses what cannot be
+// decoded or is too large, then rewinds it.
+func checkImageSize(r io.ReadSeeker) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if cfg.Width > maxImageSide || cfg.Height > maxImageSide {
+		return ErrImageTooLarge
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
+
+// stageImage copies an upload into PendingImageDir for an image job and
+// returns its name there.
+func stageImage(userID int, r io.Reader) (string, error) {
+	if err := os.MkdirAll(PendingImageDir, 0o755); err != nil {
+		return "", err
+	}
+	name := uploadedImageName(userID, ".upload")
+	f, err := os.Create(filepath.Join(PendingImageDir, name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+// cropResize decodes an image, cuts the largest centred area with the
+// width:height ratio out of it and scales that area to exactly width x height.
+func cropResize(r io.ReadSeeker, width, height int) (image.Image, error) {
+	if err := checkImageSize(r); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	cropW, cropH := b.Dx(), b.Dx()*height/width
+	if cropH > b.Dy() {
+		cropW, cropH = b.Dy()*width/height, b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-cropW)/2
+	y0 := b.Min.Y + (b.Dy()-cropH)/2
+	crop := image.Rect(x0, y0, x0+cropW, y0+cropH)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return dst, nil
+}
+
+// saveImage writes img into dir as PNG or JPEG depending on the extension of
+// name.
+func saveImage(img image.Image, dir, name string) error {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(name, ".png") {
+		return png.Encode(f, img)
+	}
+	return jpeg.Encode(f, img, &jpeg.Options{Quality: 88})
+}
+
+// uploadedImageName names a processed upload. The user id prefix is what
+// removeUploadedImage relies on to never touch the bundled default images.
+func uploadedImageName(userID int, ext string) string {
+	return fmt.Sprintf("%d_%s%s", userID, randomHex(8), ext)
+}
+
+func removeUploadedImage(userID int, dir, name string) {
+	if name == "" || !strings.HasPrefix(name, fmt.Sprintf("%d_", userID)) || filepath.Base(name) != name {
+		return
+	}
+	if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+		models.ErrLog.Println("remove old image:", err)
+	}
+}
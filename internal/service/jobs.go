@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/server"
+	"forum/internal/storage"
+	"sync"
+	"time"
+)
+
+type JobServiceIR interface {
+	CountJobs(actor models.User) (map[string]int, error)
+	ListJobs(actor models.User, status string) ([]models.Job, error)
+	RetryJob(actor models.User, id int) error
+	DiscardJob(actor models.User, id int) error
+	StopJobs()
+}
+
+// Kinds of background jobs.
+const (
+	JobRisk     = "risk"
+	JobMail     = "mail"
+	JobLinkMail = "link-mail"
+	JobImage    = "image"
+)
+
+const (
+	defaultJobWorkers = 4
+	// maxJobBackoff caps the wait between two attempts of a job.
+	maxJobBackoff = time.Hour
+	// jobListLength is how many jobs the admin page lists.
+	jobListLength = 100
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job kind")
+	ErrJobStatus  = errors.New(" pick queued, running or dead")
+)
+
+// JobHandler does the work of one kind of job. ctx ends when the job runs
+// longer than its kind allows.
+type JobHandler func(ctx context.Context, payload []byte) error
+
+type jobKind struct {
+	handler  JobHandler
+	attempts int
+	timeout  time.Duration
+}
+
+// JobQueue runs background work stored in the database, so it survives a
+// restart. A failed job is tried again after a backoff that doubles every
+// attempt; once it used up its attempts it is kept as dead for an admin to
+// look at. At most workers jobs run at once.
+type JobQueue struct {
+	storage storage.JobIR
+	kinds   map[string]jobKind
+	workers int
+	poll    time.Duration
+	backoff time.Duration
+
+	wake      chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func NewJobQueue(storage storage.JobIR, config server.JobsConfig) *JobQueue {
+	workers := config.Workers
+	if workers < 1 {
+		workers = defaultJobWorkers
+	}
+	return &JobQueue{
+		storage: storage,
+		kinds:   map[string]jobKind{},
+		workers: workers,
+		poll:    5 * time.Second,
+		backoff: 30 * time.Second,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Handle sets what runs the jobs of kind. It is called before Start.
+func (q *JobQueue) Handle(kind string, attempts int, timeout time.Duration, handler JobHandler) {
+	q.kinds[kind] = jobKind{handler: handler, attempts: attempts, timeout: timeout}
+}
+
+// Enqueue stores a job of kind with payload as JSON and wakes a worker.
+func (q *JobQueue) Enqueue(kind string, payload any) error {
+	k, ok := q.kinds[kind]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownJob, kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := q.storage.CreateJob(kind, string(data), k.attempts, time.Now()); err != nil {
+		return err
+	}
+	q.nudge()
+	return nil
+}
+
+// nudge wakes the queue to look for due jobs now.
+func (q *JobQueue) nudge() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start takes back the jobs a previous run left unfinished and starts
+// running jobs.
+func (q *JobQueue) Start() {
+	q.startOnce.Do(func() {
+		if n, err := q.storage.RequeueRunningJobs(); err != nil {
+			models.ErrLog.Println("jobs:", err)
+		} else if n > 0 {
+			models.InfoLog.Printf("jobs: %d unfinished jobs queued again", n)
+		}
+		q.wg.Add(1)
+		go q.run()
+	})
+}
+
+// Stop stops taking jobs and waits for the running ones to finish. Queued
+// jobs stay in the database for the next start.
+func (q *JobQueue) Stop() {
+	q.stopOnce.Do(func() { close(q.stop) })
+	q.wg.Wait()
+}
+
+func (q *JobQueue) StopJobs() {
+	q.Stop()
+}
+
+func (q *JobQueue) run() {
+	defer q.wg.Done()
+	slots := make(chan struct{}, q.workers)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-q.stop:
+			return
+		}
+		job, err := q.storage.ClaimJob()
+		if err != nil {
+			<-slots
+			if !errors.Is(err, sql.ErrNoRows) {
+				models.ErrLog.Println("jobs:", err)
+			}
+			select {
+			case <-q.wake:
+			case <-time.After(q.poll):
+			case <-q.stop:
+				return
+			}
+			continue
+		}
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			defer func() { <-slots }()
+			q.process(job)
+		}()
+	}
+}
+
+// process runs job and records how it went.
+func (q *JobQueue) process(job models.Job) {
+	err := q.execute(job)
+	if err == nil {
+		if err := q.storage.CompleteJob(job.Id); err != nil {
+			models.ErrLog.Println("jobs:", err)
+		}
+		return
+	}
+	// a job of a kind nothing runs is dead at once
+	if job.Attempts >= job.MaxAttempts || errors.Is(err, ErrUnknownJob) {
+		models.ErrLog.Printf("job %d (%s) is dead after %d attempts: %v", job.Id, job.Kind, job.Attempts, err)
+		err = q.storage.BuryJob(job.Id, err.Error())
+	} else {
+		models.ErrLog.Printf("job %d (%s) failed (attempt %d): %v", job.Id, job.Kind, job.Attempts, err)
+		err = q.storage.RescheduleJob(job.Id, err.Error(), time.Now().Add(q.retryAfter(job.Attempts)))
+	}
+	if err != nil {
+		models.ErrLog.Println("jobs:", err)
+	}
+}
+
+func (q *JobQueue) execute(job models.Job) (err error) {
+	k, ok := q.kinds[job.Kind]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownJob, job.Kind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	return k.handler(ctx, []byte(job.Payload))
+}
+
+// retryAfter is the wait after the given failed attempt.
+func (q *JobQueue) retryAfter(attempt int) time.Duration {
+	wait := q.backoff
+	for i := 1; i < attempt && wait < maxJobBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxJobBackoff)
+}
+
+func (q *JobQueue) CountJobs(actor models.User) (map[string]int, error) {
+	if !isAdmin(actor) {
+		return nil, ErrNotAdmin
+	}
+	return q.storage.CountJobs()
+}
+
+// ListJobs returns the latest jobs with status, dead ones by default.
+func (q *JobQueue) ListJobs(actor models.User, status string) ([]models.Job, error) {
+	if !isAdmin(actor) {
+		return nil, ErrNotAdmin
+	}
+	switch status {
+	case "":
+		status = "dead"
+	case "queued", "running", "dead":
+	default:
+		return nil, ErrJobStatus
+	}
+	return q.storage.ListJobs(status, jobListLength)
+}
+
+// RetryJob queues a dead job again with all its attempts.
+func (q *JobQueue) RetryJob(actor models.User, id int) error {
+	if !isAdmin(actor) {
+		return ErrNotAdmin
+	}
+	if err := q.storage.RetryJob(id); err != nil {
+		return err
+	}
+	q.nudge()
+	return nil
+}
+
+func (q *JobQueue) DiscardJob(actor models.User, id int) error {
+	if !isAdmin(actor) {
+		return ErrNotAdmin
+	}
+	return q.storage.DeleteDeadJob(id)
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/server"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memJobs is an in-memory storage.JobIR that runs rescheduled jobs at once.
+type memJobs struct {
+	mu   sync.Mutex
+	jobs map[int]*models.Job
+	next int
+}
+
+func newMemJobs() *memJobs {
+	return &memJobs{jobs: map[int]*models.Job{}}
+}
+
+func (m *memJobs) CreateJob(kind, payload string, maxAttempts int, runAt time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	m.jobs[m.next] = &models.Job{Id: m.next, Kind: kind, Payload: payload, Status: "queued", MaxAttempts: maxAttempts, RunAt: runAt}
+	return m.next, nil
+}
+
+func (m *memJobs) ClaimJob() (models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := 1; id <= m.next; id++ {
+		if job, ok := m.jobs[id]; ok && job.Status == "queued" {
+			job.Status = "running"
+			job.Attempts++
+			return *job, nil
+		}
+	}
+	return models.Job{}, sql.ErrNoRows
+}
+
+func (m *memJobs) CompleteJob(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memJobs) RescheduleJob(id int, lastError string, runAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id].Status, m.jobs[id].LastError, m.jobs[id].RunAt = "queued", lastError, runAt
+	return nil
+}
+
+func (m *memJobs) BuryJob(id int, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id].Status, m.jobs[id].LastError = "dead", lastError
+	return nil
+}
+
+func (m *memJobs) RequeueRunningJobs() (int64, error) { return 0, nil }
+
+func (m *memJobs) CountJobs() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[string]int{}
+	for _, job := range m.jobs {
+		counts[job.Status]++
+	}
+	return counts, nil
+}
+
+func (m *memJobs) ListJobs(status string, limit int) ([]models.Job, error) { return nil, nil }
+func (m *memJobs) RetryJob(id int) error                                   { return nil }
+func (m *memJobs) DeleteDeadJob(id int) error                              { return nil }
+
+// settle waits until no job is queued or running.
+func (m *memJobs) settle(t *testing.T) map[string]int {
+	t.Helper()
+	var counts map[string]int
+	require.Eventually(t, func() bool {
+		counts, _ = m.CountJobs()
+		return counts["queued"] == 0 && counts["running"] == 0
+	}, 5*time.Second, 5*time.Millisecond)
+	return counts
+}
+
+func TestJobQueue(t *testing.T) {
+	store := newMemJobs()
+	q := NewJobQueue(store, server.JobsConfig{Workers: 2})
+	q.poll = 10 * time.Millisecond
+	defer q.Stop()
+
+	var mu sync.Mutex
+	var done []string
+	tries := 0
+	q.Handle("ok", 3, time.Second, func(ctx context.Context, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, string(payload))
+		return nil
+	})
+	q.Handle("flaky", 3, time.Second, func(ctx context.Context, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if tries++; tries < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	q.Handle("broken", 2, time.Second, func(ctx context.Context, payload []byte) error {
+		panic("boom")
+	})
+	q.Start()
+
+	require.ErrorIs(t, q.Enqueue("nope", nil), ErrUnknownJob)
+	require.NoError(t, q.Enqueue("ok", "a"))
+	require.NoError(t, q.Enqueue("flaky", nil))
+	require.NoError(t, q.Enqueue("broken", nil))
+	counts := store.settle(t)
+
+	require.Equal(t, []string{`"a"`}, done)
+	require.Equal(t, 3, tries, "retried until it worked")
+	require.Equal(t, map[string]int{"dead": 1}, counts)
+	require.Equal(t, 2, store.jobs[3].Attempts)
+	require.Equal(t, "panic: boom", store.jobs[3].LastError)
+
+	// a job whose kind nothing handles is dead at once
+	store.CreateJob("gone", "{}", 5, time.Now())
+	q.nudge()
+	store.settle(t)
+	require.Equal(t, "dead", store.jobs[4].Status)
+	require.Equal(t, 1, store.jobs[4].Attempts)
+}
+
+func TestJobQueue_StopWaitsForRunningJobs(t *testing.T) {
+	store := newMemJobs()
+	q := NewJobQueue(store, server.JobsConfig{})
+	started, finished := make(chan struct{}), false
+	q.Handle("slow", 1, time.Second, func(ctx context.Context, payload []byte) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		finished = true
+		return nil
+	})
+	q.Start()
+	require.NoError(t, q.Enqueue("slow", nil))
+	<-started
+	q.Stop()
+	require.True(t, finished)
+	require.Empty(t, store.jobs)
+}
+
+func TestJobQueue_RetryAfter(t *testing.T) {
+	q := NewJobQueue(newMemJobs(), server.JobsConfig{})
+	require.Equal(t, 30*time.Second, q.retryAfter(1))
+	require.Equal(t, 2*time.Minute, q.retryAfter(3))
+	require.Equal(t, maxJobBackoff, q.retryAfter(20))
+}
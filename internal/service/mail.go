@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/server"
+	"forum/internal/storage"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type MailServiceIR interface {
+	SendTemplate(to, name string, data any) error
+	BaseURL() string
+	QueueTemplate(to, name string, data any) error
+	GetDigestPreferences(userID int) (models.DigestPreferences, error)
+	SaveDigestPreferences(pref models.DigestPreferences) error
+	Unsubscribe(token string) error
+	SendDueDigests(now time.Time) error
+	RunDigests(ctx context.Context, interval time.Duration)
+}
+
+var ErrUnsubscribeToken = errors.New("unsubscribe link is invalid or has already been used")
+
+type MailService struct {
+	storage   storage.DigestIR
+	mailer    Mailer
+	jobs      *JobQueue
+	templates *MailTemplates
+	baseURL   string
+}
+
+func NewMailService(storage storage.DigestIR, mailer Mailer, jobs *JobQueue, config server.Config) *MailService {
+	baseURL := strings.TrimRight(config.Mail.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = "https://localhost" + config.Port
+	}
+	m := &MailService{
+		storage:   storage,
+		mailer:    mailer,
+		jobs:      jobs,
+		templates: NewMailTemplates("./front/email"),
+		baseURL:   baseURL,
+	}
+	jobs.Handle(JobMail, 5, time.Minute, m.sendQueued)
+	return m
+}
+
+// SendTemplate renders and sends a message right away, for mail the user is
+// actively waiting for (e.g. a login code).
+func (m *MailService) SendTemplate(to, name string, data any) error {
+	mail, err := m.templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	mail.To = to
+	return m.mailer.Send(mail)
+}
+
+// BaseURL is where links in mails point to.
+func (m *MailService) BaseURL() string {
+	return m.baseURL
+}
+
+// QueueTemplate renders a message and leaves sending it to a background
+// job, tried again when SMTP fails.
+func (m *MailService) QueueTemplate(to, name string, data any) error {
+	mail, err := m.templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	mail.To = to
+	return m.jobs.Enqueue(JobMail, mail)
+}
+
+// sendQueued runs a mail job.
+func (m *MailService) sendQueued(ctx context.Context, payload []byte) error {
+	var mail Mail
+	if err := json.Unmarshal(payload, &mail); err != nil {
+		return err
+	}
+	return m.mailer.Send(mail)
+}
+
+func (m *MailService) GetDigestPreferences(userID int) (models.DigestPreferences, error) {
+	return m.storage.GetDigestPreferences(userID)
+}
+
+func (m *MailService) SaveDigestPreferences(pref models.DigestPreferences) error {
+	switch pref.Digest {
+	case "off", "daily", "weekly":
+	default:
+		return errors.New(" unknown digest frequency")
+	}
+	if pref.UnsubscribeToken == "" {
+		pref.UnsubscribeToken = randomHex(24)
+	}
+	if err := m.storage.SaveDigestPreferences(pref); err != nil {
+		return err
+	}
+	return m.storage.SetFollowedCategories(pref.UserID, pref.FollowedCategories)
+}
+
+func (m *MailService) Unsubscribe(token string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrUnsubscribeToken
+	}
+	ok, err := m.storage.Unsubscribe(token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnsubscribeToken
+	}
+	return nil
+}
+
+var digestPeriods = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// SendDueDigests queues a digest for every user whose daily or weekly period
+// has elapsed. Users with nothing new are skipped but still marked as sent so
+// the next digest covers a single period.
+func (m *MailService) SendDueDigests(now time.Time) error {
+	for digest, period := range digestPeriods {
+		prefs, err := m.storage.GetDueDigests(digest, now.Add(-period))
+		if err != nil {
+			return err
+		}
+		for _, pref := range prefs {
+			since := pref.LastSentAt
+			if since.IsZero() {
+				since = now.Add(-period)
+			}
+			if err := m.sendDigest(pref, since); err != nil {
+				models.ErrLog.Printf("digest for user %d: %v", pref.UserID, err)
+				continue
+			}
+			if err := m.storage.MarkDigestSent(pref.UserID, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MailService) sendDigest(pref models.DigestPreferences, since time.Time) error {
+	data := models.Digest{
+		Username:       pref.Username,
+		Period:         pref.Digest,
+		BaseURL:        m.baseURL,
+		UnsubscribeURL: m.baseURL + "/unsubscribe?token=" + url.QueryEscape(pref.UnsubscribeToken),
+	}
+	var err error
+	if pref.IncludeNotifications {
+		if data.Notifications, err = m.storage.GetNotificationsSince(pref.UserID, since); err != nil {
+			return err
+		}
+	}
+	if pref.IncludeReplies {
+		if data.Replies, err = m.storage.GetRepliesSince(pref.UserID, since); err != nil {
+			return err
+		}
+	}
+	if pref.IncludeCategories {
+		if data.CategoryPosts, err = m.storage.GetFollowedPostsSince(pref.UserID, since); err != nil {
+			return err
+		}
+	}
+	if len(data.Notifications) == 0 && len(data.Replies) == 0 && len(data.CategoryPosts) == 0 {
+		return nil
+	}
+
+	mail, err := m.templates.Render("digest", data)
+	if err != nil {
+		return err
+	}
+	mail.To = pref.Email
+	mail.Headers = map[string]string{
+		"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	return m.jobs.Enqueue(JobMail, mail)
+}
+
+// RunDigests checks for due digests every interval until ctx is cancelled.
+func (m *MailService) RunDigests(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.SendDueDigests(time.Now()); err != nil {
+			models.ErrLog.Println("digest:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
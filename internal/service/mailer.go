@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/server"
+	htmltemplate "html/template"
+	"mime"
+	"mime/quotedprintable"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Mail is a single outgoing message. HTML is optional; when it is set the
+// message is sent as multipart/alternative together with the Text part.
+type Mail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// NewMailer picks the implementation by config.Mail.Driver: "outbox" writes
+// messages to disk (handy for local runs and tests), anything else uses SMTP.
+func NewMailer(config server.Config) Mailer {
+	if config.Mail.Driver == "outbox" {
+		return NewOutboxMailer(config.Mail.OutboxDir, config.SMTP.From)
+	}
+	return &SMTPMailer{
+		From:     config.SMTP.From,
+		Password: config.SMTP.Password,
+		Host:     config.SMTP.Host,
+		Port:     config.SMTP.Port,
+	}
+}
+
+type SMTPMailer struct {
+	From     string
+	Password string
+	Host     string
+	Port     string
+}
+
+func (s *SMTPMailer) Send(mail Mail) error {
+	if s.From == "" || s.Password == "" || s.Host == "" || s.Port == "" {
+		return fmt.Errorf("smtp config is not fully specified")
+	}
+	msg, err := buildMessage(s.From, mail)
+	if err != nil {
+		return err
+	}
+	auth := smtp.PlainAuth("", s.From, s.Password, s.Host)
+	return smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{mail.To}, msg)
+}
+
+// OutboxMailer keeps every message it is asked to send. With a directory
+// configured each message is also written there as an .eml file, otherwise
+// it is only logged.
+type OutboxMailer struct {
+	dir  string
+	from string
+
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func NewOutboxMailer(dir, from string) *OutboxMailer {
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	return &OutboxMailer{dir: dir, from: from}
+}
+
+func (o *OutboxMailer) Send(mail Mail) error {
+	o.mu.Lock()
+	o.sent = append(o.sent, mail)
+	o.mu.Unlock()
+
+	if o.dir == "" {
+		models.InfoLog.Printf("outbox: to=%s subject=%q\n%s", mail.To, mail.Subject, mail.Text)
+		return nil
+	}
+	msg, err := buildMessage(o.from, mail)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(o.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), randomHex(4))
+	return os.WriteFile(filepath.Join(o.dir, name), msg, 0o644)
+}
+
+// Sent returns a copy of every message passed to Send.
+func (o *OutboxMailer) Sent() []Mail {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Mail(nil), o.sent...)
+}
+
+func buildMessage(from string, mail Mail) ([]byte, error) {
+	if strings.ContainsAny(mail.To, "\r\n") || strings.ContainsAny(mail.Subject, "\r\n") {
+		return nil, fmt.Errorf("invalid mail header")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	keys := make([]string, 0, len(mail.Headers))
+	for k := range mail.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.ContainsAny(k+mail.Headers[k], "\r\n") {
+			return nil, fmt.Errorf("invalid mail header")
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, mail.Headers[k])
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if mail.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=\"UTF-8\"\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, mail.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	boundary := "elestial-" + randomHex(12)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=\"%s\"\r\n\r\n", boundary)
+	parts := []struct{ contentType, body string }{
+		{"text/plain", mail.Text},
+		{"text/html", mail.HTML},
+	}
+	for _, part := range parts {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=\"UTF-8\"\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, part.body); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, body string) error {
+	w := quotedprintable.NewWriter(buf)
+	if _, err := w.Write([]byte(body)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// MailTemplates renders emails from front/email. Every message has a
+// <name>.txt template that defines a "subject" block and the plain text
+// body, and an optional <name>.html template for the HTML part.
+type MailTemplates struct {
+	dir string
+}
+
+func NewMailTemplates(dir string) *MailTemplates {
+	return &MailTemplates{dir: dir}
+}
+
+func (m *MailTemplates) Render(name string, data any) (Mail, error) {
+	textTmpl, err := template.ParseFiles(filepath.Join(m.dir, name+".txt"))
+	if err != nil {
+		return Mail{}, err
+	}
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Mail{}, err
+	}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return Mail{}, err
+	}
+	mail := Mail{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+
+	htmlPath := filepath.Join(m.dir, name+".html")
+	if _, err := os.Stat(htmlPath); err == nil {
+		htmlTmpl, err := htmltemplate.ParseFiles(htmlPath)
+		if err != nil {
+			return Mail{}, err
+		}
+		var html bytes.Buffer
+		if err := htmlTmpl.Execute(&html, data); err != nil {
+			return Mail{}, err
+		}
+		mail.HTML = html.String()
+	}
+	return mail, nil
+}
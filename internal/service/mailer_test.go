@@ -0,0 +1,50 @@
+package service
+
+import (
+	"forum/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxMailer_WritesMultipartDigest(t *testing.T) {
+	dir := t.TempDir()
+	outbox := NewOutboxMailer(dir, "forum@example.com")
+
+	mail, err := NewMailTemplates("../../front/email").Render("digest", models.Digest{
+		Username:       "great_king",
+		Period:         "daily",
+		BaseURL:        "https://forum.example",
+		UnsubscribeURL: "https://forum.example/unsubscribe?token=abc",
+		CategoryPosts:  []models.Post{{Id: 7, Title: "Hello", Author: "prince"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Your daily Elestial digest", mail.Subject)
+	require.Contains(t, mail.Text, "https://forum.example/post/?id=7")
+	require.Contains(t, mail.HTML, "unsubscribe?token=abc")
+
+	mail.To = "king@example.com"
+	mail.Headers = map[string]string{"List-Unsubscribe": "<https://forum.example/unsubscribe?token=abc>"}
+	require.NoError(t, outbox.Send(mail))
+	require.Len(t, outbox.Sent(), 1)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	raw, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	msg := string(raw)
+	require.Contains(t, msg, "To: king@example.com\r\n")
+	require.Contains(t, msg, "List-Unsubscribe: <https://forum.example/unsubscribe?token=abc>\r\n")
+	require.Contains(t, msg, "multipart/alternative")
+	require.Equal(t, 2, strings.Count(msg, "Content-Transfer-Encoding: quoted-printable"))
+}
+
+func TestOutboxMailer_RejectsHeaderInjection(t *testing.T) {
+	outbox := NewOutboxMailer(t.TempDir(), "")
+	err := outbox.Send(Mail{To: "a@example.com\r\nBcc: b@example.com", Subject: "hi", Text: "hi"})
+	require.Error(t, err)
+}
@@ -0,0 +1,577 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/server"
+	"forum/internal/storage"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type OIDCServiceIR interface {
+	OAuthProviders() []models.OAuthProvider
+	StartOAuth(provider string, linkUserID int) (redirectURL, stateCookie string, err error)
+	FinishOAuth(ctx context.Context, stateCookie, state, code string) (models.OAuthState, models.OAuthClaims, error)
+	LoginWithIdentity(provider string, claims models.OAuthClaims) (models.User, error)
+	LinkIdentity(userID int, provider string, claims models.OAuthClaims) error
+	UnlinkIdentity(userID int, provider string) error
+	GetIdentities(userID int) ([]models.UserIdentity, error)
+}
+
+var (
+	ErrUnknownProvider = errors.New(" unknown login provider")
+	ErrOAuthState      = errors.New(" the login request expired or was tampered with, please try again")
+	ErrOAuthEmailTaken = errors.New(" an account with this email already exists, sign in and link the provider in settings")
+	ErrLastLogin       = errors.New(" set a password or link another provider before removing the last way to sign in")
+)
+
+// oauthStateTTL is how long the user has to come back from the provider.
+const oauthStateTTL = 10 * time.Minute
+
+type OIDCService struct {
+	storage   *storage.Storage
+	providers map[string]*oidcProvider
+	stateKey  []byte
+}
+
+func NewOIDCService(storages *storage.Storage, config server.OAuthConfig) *OIDCService {
+	key := []byte(config.StateSecret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	providers := map[string]*oidcProvider{}
+	for name, cfg := range config.AllProviders() {
+		providers[name] = newOIDCProvider(name, cfg)
+	}
+	return &OIDCService{
+		storage:   storages,
+		providers: providers,
+		stateKey:  key,
+	}
+}
+
+func (o *OIDCService) OAuthProviders() []models.OAuthProvider {
+	list := make([]models.OAuthProvider, 0, len(o.providers))
+	for name, p := range o.providers {
+		title := p.cfg.Title
+		if title == "" {
+			title = name
+		}
+		list = append(list, models.OAuthProvider{Name: name, Title: title, Icon: p.cfg.Icon})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// StartOAuth returns the provider URL to send the user to and the signed
+// state to keep in a cookie until the callback.
+func (o *OIDCService) StartOAuth(provider string, linkUserID int) (string, string, error) {
+	p, ok := o.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	state := models.OAuthState{
+		Provider:   provider,
+		State:      randomToken(),
+		Nonce:      randomToken(),
+		Verifier:   randomToken() + randomToken(),
+		LinkUserID: linkUserID,
+		Expires:    time.Now().Add(oauthStateTTL).Unix(),
+	}
+	authURL, err := p.authCodeURL(state)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, o.signState(state), nil
+}
+
+// FinishOAuth checks the callback against the state cookie, redeems the code
+// and returns who the provider says the user is.
+func (o *OIDCService) FinishOAuth(ctx context.Context, stateCookie, state, code string) (models.OAuthState, models.OAuthClaims, error) {
+	s, err := o.parseState(stateCookie)
+	if err != nil {
+		return models.OAuthState{}, models.OAuthClaims{}, err
+	}
+	if state == "" || !hmac.Equal([]byte(s.State), []byte(state)) || code == "" {
+		return models.OAuthState{}, models.OAuthClaims{}, ErrOAuthState
+	}
+	p, ok := o.providers[s.Provider]
+	if !ok {
+		return models.OAuthState{}, models.OAuthClaims{}, ErrUnknownProvider
+	}
+	claims, err := p.exchange(ctx, code, s)
+	if err != nil {
+		return models.OAuthState{}, models.OAuthClaims{}, err
+	}
+	if claims.Subject == "" {
+		return models.OAuthState{}, models.OAuthClaims{}, errors.New("oidc: provider returned no subject")
+	}
+	return s, claims, nil
+}
+
+func (o *OIDCService) signState(state models.OAuthState) string {
+	payload, _ := json.Marshal(state)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, o.stateKey)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (o *OIDCService) parseState(value string) (models.OAuthState, error) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return models.OAuthState{}, ErrOAuthState
+	}
+	mac := hmac.New(sha256.New, o.stateKey)
+	mac.Write([]byte(encoded))
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return models.OAuthState{}, ErrOAuthState
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return models.OAuthState{}, ErrOAuthState
+	}
+	var state models.OAuthState
+	if err := json.Unmarshal(payload, &state); err != nil || time.Now().Unix() > state.Expires {
+		return models.OAuthState{}, ErrOAuthState
+	}
+	return state, nil
+}
+
+// LoginWithIdentity finds the user linked to the provider account, or
+// registers a new one. Accounts are never matched by email alone: an
+// existing user has to link the provider from settings first. The only
+// exception are accounts the old Google/GitHub login created, and those are
+// only matched by email when the provider verified it.
+func (o *OIDCService) LoginWithIdentity(provider string, claims models.OAuthClaims) (models.User, error) {
+	user, err := o.storage.IdentityIR.GetUserByIdentity(provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, err
+	}
+
+	legacy, byEmail := claims.LegacyID, false
+	if legacy == "" {
+		legacy, byEmail = claims.Email, true
+	}
+	if legacy != "" {
+		user, err := o.storage.IdentityIR.GetLegacyOAuthUser(legacy, provider)
+		if err == nil {
+			if byEmail && !claims.EmailVerified {
+				return models.User{}, ErrOAuthEmailTaken
+			}
+			return user, o.storage.IdentityIR.LinkIdentity(user.Id, provider, claims.Subject, claims.Email)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, err
+		}
+	}
+
+	email := claims.Email
+	verified := email != "" && claims.EmailVerified
+	if !verified {
+		// keep the unique email column filled without claiming an address
+		// the provider did not verify
+		email = fmt.Sprintf("%s-%s@users.invalid", provider, claims.Subject)
+	} else {
+		exist, err := o.storage.User.CheckUserByEmail(email)
+		if err != nil {
+			return models.User{}, err
+		}
+		if exist {
+			return models.User{}, ErrOAuthEmailTaken
+		}
+	}
+	username, err := o.freeUsername(provider, claims)
+	if err != nil {
+		return models.User{}, err
+	}
+	return o.storage.IdentityIR.CreateIdentityUser(username, email, provider, claims.Subject, verified)
+}
+
+// freeUsername turns the provider's name for the user into one validUser
+// would accept and that is not taken yet.
+func (o *OIDCService) freeUsername(provider string, claims models.OAuthClaims) (string, error) {
+	base := claims.Username
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	var b strings.Builder
+	for _, r := range base {
+		switch {
+		case r == ' ':
+			b.WriteByte('_')
+		case r > 32 && r < 127:
+			b.WriteRune(r)
+		}
+	}
+	name := b.String()
+	if len(name) < 6 {
+		name = name + "_" + provider
+	}
+	if len(name) > 30 {
+		name = name[:30]
+	}
+	candidate := name
+	for n := 2; n < 100; n++ {
+		taken, err := o.storage.User.CheckUserByName(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%d", name, n)
+	}
+	return name + "_" + randomToken()[:4], nil
+}
+
+func (o *OIDCService) LinkIdentity(userID int, provider string, claims models.OAuthClaims) error {
+	if _, ok := o.providers[provider]; !ok {
+		return ErrUnknownProvider
+	}
+	return o.storage.IdentityIR.LinkIdentity(userID, provider, claims.Subject, claims.Email)
+}
+
+// UnlinkIdentity refuses to remove the last way the user can sign in.
+func (o *OIDCService) UnlinkIdentity(userID int, provider string) error {
+	identities, err := o.storage.IdentityIR.GetIdentities(userID)
+	if err != nil {
+		return err
+	}
+	if len(identities) <= 1 {
+		hasPassword, err := o.storage.IdentityIR.HasPassword(userID)
+		if err != nil {
+			return err
+		}
+		if !hasPassword && !o.storage.Auth.HasWebAuthn(userID) {
+			return ErrLastLogin
+		}
+	}
+	return o.storage.IdentityIR.UnlinkIdentity(userID, provider)
+}
+
+func (o *OIDCService) GetIdentities(userID int) ([]models.UserIdentity, error) {
+	return o.storage.IdentityIR.GetIdentities(userID)
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcProvider talks to one provider. Discovery and the signing keys are
+// fetched lazily and cached.
+type oidcProvider struct {
+	name   string
+	cfg    server.OAuthProviderConfig
+	client *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	issuer     string
+	keys       map[string]any
+}
+
+func newOIDCProvider(name string, cfg server.OAuthProviderConfig) *oidcProvider {
+	return &oidcProvider{
+		name:   name,
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		issuer: strings.TrimSuffix(cfg.Issuer, "/"),
+	}
+}
+
+// isOIDC providers return an ID token that has to be verified.
+func (p *oidcProvider) isOIDC() bool {
+	return p.cfg.Issuer != ""
+}
+
+func (p *oidcProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered || !p.isOIDC() {
+		return nil
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return fmt.Errorf("oidc: discovery for %s: %w", p.name, err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return fmt.Errorf("oidc: %s discovery issuer %q does not match %q", p.name, doc.Issuer, p.issuer)
+	}
+	p.issuer = doc.Issuer
+	if p.cfg.AuthURL == "" {
+		p.cfg.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.cfg.TokenURL == "" {
+		p.cfg.TokenURL = doc.TokenEndpoint
+	}
+	if p.cfg.UserInfoURL == "" {
+		p.cfg.UserInfoURL = doc.UserinfoEndpoint
+	}
+	if p.cfg.JWKSURL == "" {
+		p.cfg.JWKSURL = doc.JWKSURI
+	}
+	p.discovered = true
+	return nil
+}
+
+func (p *oidcProvider) authCodeURL(state models.OAuthState) (string, error) {
+	if err := p.discover(context.Background()); err != nil {
+		return "", err
+	}
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 && p.isOIDC() {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.isOIDC() {
+		query.Set("nonce", state.Nonce)
+	}
+	separator := "?"
+	if strings.Contains(p.cfg.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.cfg.AuthURL + separator + query.Encode(), nil
+}
+
+func (p *oidcProvider) exchange(ctx context.Context, code string, state models.OAuthState) (models.OAuthClaims, error) {
+	if err := p.discover(ctx); err != nil {
+		return models.OAuthClaims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"code_verifier": {state.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return models.OAuthClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return models.OAuthClaims{}, fmt.Errorf("oidc: token request to %s: %w", p.name, err)
+	}
+	defer res.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return models.OAuthClaims{}, fmt.Errorf("oidc: token response from %s: %w", p.name, err)
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" || token.AccessToken == "" {
+		return models.OAuthClaims{}, fmt.Errorf("oidc: %s refused the code: %s %s", p.name, res.Status, token.Error)
+	}
+
+	values := map[string]any{}
+	if p.isOIDC() {
+		if token.IDToken == "" {
+			return models.OAuthClaims{}, fmt.Errorf("oidc: %s returned no id_token", p.name)
+		}
+		if values, err = p.verifyIDToken(ctx, token.IDToken, state.Nonce); err != nil {
+			return models.OAuthClaims{}, err
+		}
+	}
+	if p.cfg.UserInfoURL != "" {
+		info := map[string]any{}
+		if err := p.getJSON(ctx, p.cfg.UserInfoURL, token.AccessToken, &info); err != nil {
+			return models.OAuthClaims{}, fmt.Errorf("oidc: userinfo from %s: %w", p.name, err)
+		}
+		if sub, ok := values["sub"]; ok && claimString(info, "sub") != "" && claimString(info, "sub") != fmt.Sprint(sub) {
+			return models.OAuthClaims{}, fmt.Errorf("oidc: %s userinfo is about another subject", p.name)
+		}
+		for key, value := range info {
+			if _, ok := values[key]; !ok {
+				values[key] = value
+			}
+		}
+	}
+	claims := models.OAuthClaims{
+		Subject:  claimString(values, "sub", "id"),
+		Email:    claimString(values, "email"),
+		Username: claimString(values, "preferred_username", "login", "nickname", "name"),
+		Picture:  claimString(values, "picture", "avatar_url"),
+		LegacyID: claimString(values, "node_id"),
+	}
+	verified, ok := values["email_verified"].(bool)
+	// plain OAuth2 providers only hand out the addresses they verified
+	claims.EmailVerified = verified || (!ok && !p.isOIDC() && claims.Email != "")
+	return claims, nil
+}
+
+// verifyIDToken checks signature, issuer, audience, expiry and nonce.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+		jwt.WithJSONNumber(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %s id_token: %w", p.name, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("oidc: %s id_token has no claims", p.name)
+	}
+	if got, _ := claims["nonce"].(string); !hmac.Equal([]byte(got), []byte(nonce)) {
+		return nil, fmt.Errorf("oidc: %s id_token nonce does not match", p.name)
+	}
+	return claims, nil
+}
+
+// key returns the signing key with the id kid, fetching the JWKS again once
+// when the provider rotated its keys.
+func (p *oidcProvider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.cfg.JWKSURL, "", &set); err != nil {
+		return nil, fmt.Errorf("oidc: jwks of %s: %w", p.name, err)
+	}
+	p.keys = map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			p.keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: %s has no signing key %q", p.name, kid)
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, target, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", target, res.Status)
+	}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// claimString returns the first of keys that is set, numbers included
+// (GitHub's numeric user id).
+func claimString(values map[string]any, keys ...string) string {
+	for _, key := range keys {
+		switch v := values[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case json.Number:
+			return v.String()
+		case float64:
+			return fmt.Sprint(int64(v))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"forum/internal/server"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS, an authorize
+// endpoint that approves at once, a token endpoint checking PKCE, userinfo.
+type mockIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge map[string]string
+	nonce     map[string]string
+	badNonce  bool
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp := &mockIdP{key: key, challenge: map[string]string{}, nonce: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"userinfo_endpoint":      idp.URL + "/userinfo",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := "code-" + q.Get("state")
+		idp.challenge[code] = q.Get("code_challenge")
+		idp.nonce[code] = q.Get("nonce")
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.FormValue("code")
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if idp.challenge[code] == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge[code] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		nonce := idp.nonce[code]
+		if idp.badNonce {
+			nonce = "other"
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            idp.URL,
+			"aud":            "forum",
+			"sub":            "user-1",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          nonce,
+			"email":          "alice@example.com",
+			"email_verified": true,
+		})
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": signed, "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"sub": "user-1", "preferred_username": "alice"})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize follows the redirect to the mock provider and returns the state
+// and code it sends back.
+func authorize(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	require.NoError(t, err)
+	res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestOIDC_LoginAgainstMockIdP(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := NewOIDCService(nil, server.OAuthConfig{
+		StateSecret: "secret",
+		Providers: map[string]server.OAuthProviderConfig{
+			"mock": {ClientID: "forum", ClientSecret: "s", Issuer: idp.URL, RedirectURL: "https://forum.test/auth/mock/callback"},
+		},
+	})
+
+	authURL, cookie, err := svc.StartOAuth("mock", 0)
+	require.NoError(t, err)
+	require.Contains(t, authURL, "code_challenge_method=S256")
+	require.Contains(t, authURL, "nonce=")
+
+	state, code := authorize(t, authURL)
+	s, claims, err := svc.FinishOAuth(context.Background(), cookie, state, code)
+	require.NoError(t, err)
+	require.Equal(t, "mock", s.Provider)
+	require.Equal(t, "user-1", claims.Subject)
+	require.Equal(t, "alice@example.com", claims.Email)
+	require.True(t, claims.EmailVerified)
+	require.Equal(t, "alice", claims.Username)
+}
+
+func TestOIDC_RejectsBadStateAndNonce(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := NewOIDCService(nil, server.OAuthConfig{
+		StateSecret: "secret",
+		Providers: map[string]server.OAuthProviderConfig{
+			"mock": {ClientID: "forum", Issuer: idp.URL, RedirectURL: "https://forum.test/cb"},
+		},
+	})
+	_, _, err := svc.StartOAuth("nope", 0)
+	require.ErrorIs(t, err, ErrUnknownProvider)
+
+	authURL, cookie, err := svc.StartOAuth("mock", 0)
+	require.NoError(t, err)
+	state, code := authorize(t, authURL)
+
+	_, _, err = svc.FinishOAuth(context.Background(), cookie+"x", state, code)
+	require.ErrorIs(t, err, ErrOAuthState)
+	_, _, err = svc.FinishOAuth(context.Background(), cookie, "forged", code)
+	require.ErrorIs(t, err, ErrOAuthState)
+
+	// state signed with another key, as after a restart without StateSecret
+	other := NewOIDCService(nil, server.OAuthConfig{StateSecret: "other"})
+	_, _, err = other.FinishOAuth(context.Background(), cookie, state, code)
+	require.ErrorIs(t, err, ErrOAuthState)
+
+	idp.badNonce = true
+	_, _, err = svc.FinishOAuth(context.Background(), cookie, state, code)
+	require.ErrorContains(t, err, "nonce")
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type PollServiceIR interface {
+	ValidatePoll(poll *models.Poll) error
+	CreatePoll(poll models.Poll) error
+	GetPoll(postID int, viewer models.User, authorID int) (*models.Poll, error)
+	VotePoll(postID, userID int, optionIDs []int) error
+}
+
+const (
+	maxPollOptions     = 10
+	maxPollQuestionLen = 200
+	maxPollOptionLen   = 100
+)
+
+var (
+	ErrPollClosed = errors.New(" this poll is closed")
+	ErrPollVote   = errors.New(" choose one option")
+)
+
+type PollService struct {
+	storage storage.PollIR
+}
+
+func NewPollService(storage storage.PollIR) *PollService {
+	return &PollService{
+		storage: storage,
+	}
+}
+
+// ValidatePoll trims the poll in place and drops empty options, so the form
+// can always offer a few spare option fields.
+func (p *PollService) ValidatePoll(poll *models.Poll) error {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if poll.Question == "" || utf8.RuneCountInString(poll.Question) > maxPollQuestionLen {
+		return errors.New(" poll question should be shorter than 201 symbols and not empty")
+	}
+	var options []models.PollOption
+	for _, option := range poll.Options {
+		option.Text = strings.TrimSpace(option.Text)
+		if option.Text == "" {
+			continue
+		}
+		if utf8.RuneCountInString(option.Text) > maxPollOptionLen {
+			return errors.New(" poll option should be shorter than 101 symbols")
+		}
+		options = append(options, option)
+	}
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return errors.New(" poll needs from 2 to 10 options")
+	}
+	poll.Options = options
+	if !poll.ClosesAt.IsZero() && !poll.ClosesAt.After(time.Now()) {
+		return errors.New(" poll close time should be in the future")
+	}
+	return nil
+}
+
+func (p *PollService) CreatePoll(poll models.Poll) error {
+	if err := p.ValidatePoll(&poll); err != nil {
+		return err
+	}
+	_, err := p.storage.CreatePoll(poll)
+	return err
+}
+
+// GetPoll returns nil when the post has no poll. Results of a poll that hides
+// them stay hidden until the viewer has voted or the poll is closed; the
+// author can always see them.
+func (p *PollService) GetPoll(postID int, viewer models.User, authorID int) (*models.Poll, error) {
+	poll, err := p.storage.GetPollByPost(postID, viewer.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	poll.Closed = !poll.ClosesAt.IsZero() && !time.Now().Before(poll.ClosesAt)
+	poll.ShowResults = !poll.HideResults || poll.Voted || poll.Closed || (viewer.IsAuth && viewer.Id == authorID)
+
+	total := 0
+	for _, option := range poll.Options {
+		total += option.Votes
+	}
+	for i := range poll.Options {
+		if !poll.ShowResults {
+			poll.Options[i].Votes = 0
+			continue
+		}
+		if total > 0 {
+			poll.Options[i].Percent = poll.Options[i].Votes * 100 / total
+		}
+	}
+	if !poll.ShowResults {
+		poll.Voters = 0
+	}
+	return &poll, nil
+}
+
+// VotePoll records or replaces the vote of userID while the poll is open.
+func (p *PollService) VotePoll(postID, userID int, optionIDs []int) error {
+	poll, err := p.storage.GetPollByPost(postID, userID)
+	if err != nil {
+		return err
+	}
+	if !poll.ClosesAt.IsZero() && !time.Now().Before(poll.ClosesAt) {
+		return ErrPollClosed
+	}
+	seen := map[int]bool{}
+	var ids []int
+	for _, id := range optionIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || (!poll.Multiple && len(ids) > 1) {
+		return ErrPollVote
+	}
+	return p.storage.SavePollVote(poll.Id, userID, ids)
+}
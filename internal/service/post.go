@@ -8,12 +8,9 @@ import (
 )
 
 type ServicePostIR interface {
-	CreatePost(post models.Post) error
+	CreatePost(post models.Post) (int, error)
 	GetPostId(id int) (models.Post, error)
 	GetAllPosts() ([]models.Post, error)
-	GetCategories() ([]models.Category, error)
-	AddCategory(name string) error
-	DeleteCategory(name string) error
 
 	GetAllPostsByCategories(category string) ([]models.Post, error)
 	GetMyPost(int) ([]models.Post, error)
@@ -34,14 +31,6 @@ func NewPostService(postIR storage.PostIR) ServicePostIR {
 	}
 }
 
-func (p *PostService) DeleteCategory(name string) error {
-	return p.storage.DeleteCategory(name)
-}
-
-func (p *PostService) AddCategory(name string) error {
-	return p.storage.AddCategory(name)
-}
-
 func (p *PostService) DeletePost(id int) error {
 	return p.storage.DeletePost(id)
 }
@@ -50,7 +39,39 @@ func (p *PostService) GetAllWaitPosts() ([]models.Post, error) {
 	return p.storage.GetAllWaitPosts()
 }
 
-func (p *PostService) CreatePost(post models.Post) error {
+func (p *PostService) CreatePost(post models.Post) (int, error) {
+	if err := validatePost(&post); err != nil {
+		return 0, err
+	}
+	return p.storage.CreatePost(post)
+}
+func (p *PostService) UpdatePost(post models.Post) error {
+	return p.storage.UpdatePost(post)
+}
+
+func (p *PostService) GetPostId(id int) (models.Post, error) {
+	return p.storage.GetPostByID(id)
+}
+
+func (p *PostService) GetAllPosts() ([]models.Post, error) {
+	return p.storage.GetAllPosts()
+}
+
+func (p *PostService) GetAllPostsByCategories(category string) ([]models.Post, error) {
+	return p.storage.GetAllPostsByCategories(category)
+}
+
+func (p *PostService) GetMyPost(id int) ([]models.Post, error) {
+	return p.storage.GetMyPost(id)
+}
+
+func (p *PostService) GetMyLikePost(id int) ([]models.Post, error) {
+	return p.storage.GetMyLikedPost(id)
+}
+
+// validatePost trims the post in place and checks it is ready to go to the
+// moderators.
+func validatePost(post *models.Post) error {
 	for x := range post.Category {
 		post.Category[x] = strings.TrimSpace(post.Category[x])
 		if len(post.Category[x]) == 0 {
@@ -82,33 +103,5 @@ func (p *PostService) CreatePost(post models.Post) error {
 		return fmt.Errorf("INVALID TITLE, title should be shorter than 35 symbols and not empty")
 
 	}
-
-	return p.storage.CreatePost(post)
-}
-func (p *PostService) UpdatePost(post models.Post) error {
-	return p.storage.UpdatePost(post)
-}
-
-func (p *PostService) GetPostId(id int) (models.Post, error) {
-	return p.storage.GetPostByID(id)
-}
-
-func (p *PostService) GetAllPosts() ([]models.Post, error) {
-	return p.storage.GetAllPosts()
-}
-
-func (p *PostService) GetCategories() ([]models.Category, error) {
-	return p.storage.Category()
-}
-
-func (p *PostService) GetAllPostsByCategories(category string) ([]models.Post, error) {
-	return p.storage.GetAllPostsByCategories(category)
-}
-
-func (p *PostService) GetMyPost(id int) ([]models.Post, error) {
-	return p.storage.GetMyPost(id)
-}
-
-func (p *PostService) GetMyLikePost(id int) ([]models.Post, error) {
-	return p.storage.GetMyLikedPost(id)
+	return nil
 }
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"time"
+)
+
+type PostFlagServiceIR interface {
+	SetPostFlag(actor models.User, post models.Post, action, scope string, expiresAt time.Time) error
+	GetPostFlags(postID int) (models.PostFlags, error)
+	GetPostFlagAudit(postID int) ([]models.PostFlagAudit, error)
+	GetPinnedPosts(scope string) ([]models.Post, error)
+	GetAnnouncements(userID int) ([]models.Post, error)
+	DismissAnnouncement(userID, postID int) error
+	IsPostLocked(postID int) (bool, error)
+}
+
+var (
+	ErrNotStaff   = errors.New(" your role is not suitable")
+	ErrPostLocked = errors.New(" this post is locked, new comments are not accepted")
+)
+
+type PostFlagService struct {
+	storage storage.PostFlagIR
+}
+
+func NewPostFlagService(storage storage.PostFlagIR) *PostFlagService {
+	return &PostFlagService{
+		storage: storage,
+	}
+}
+
+func isStaff(user models.User) bool {
+	return user.Rol == "king" || user.Rol == "admin" || user.Rol == "moderator"
+}
+
+// SetPostFlag pins, locks or announces a published post, or undoes it. Pins
+// go to the front page (empty scope) or to one of the post's categories;
+// the other flags have no scope.
+func (f *PostFlagService) SetPostFlag(actor models.User, post models.Post, action, scope string, expiresAt time.Time) error {
+	if !actor.IsAuth || !isStaff(actor) {
+		return ErrNotStaff
+	}
+	if post.Status != "done" {
+		return errors.New(" only published posts can be pinned, locked or announced")
+	}
+	switch action {
+	case "pin", "unpin":
+		if scope != "" && !containsString(post.Category, scope) {
+			return errors.New(" a post can only be pinned in its own categories")
+		}
+	case "lock", "unlock", "announce", "unannounce":
+		scope = ""
+	default:
+		return errors.New(" unknown action")
+	}
+	if action == "unpin" || action == "unlock" || action == "unannounce" {
+		expiresAt = time.Time{}
+	} else if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return errors.New(" expiry should be in the future")
+	}
+	return f.storage.SetPostFlag(post.Id, action, scope, actor.Id, expiresAt)
+}
+
+func (f *PostFlagService) GetPostFlags(postID int) (models.PostFlags, error) {
+	return f.storage.GetPostFlags(postID)
+}
+
+func (f *PostFlagService) GetPostFlagAudit(postID int) ([]models.PostFlagAudit, error) {
+	return f.storage.GetPostFlagAudit(postID)
+}
+
+func (f *PostFlagService) GetPinnedPosts(scope string) ([]models.Post, error) {
+	return f.storage.GetPinnedPosts(scope)
+}
+
+func (f *PostFlagService) GetAnnouncements(userID int) ([]models.Post, error) {
+	return f.storage.GetAnnouncements(userID)
+}
+
+func (f *PostFlagService) DismissAnnouncement(userID, postID int) error {
+	return f.storage.DismissAnnouncement(userID, postID)
+}
+
+func (f *PostFlagService) IsPostLocked(postID int) (bool, error) {
+	flags, err := f.storage.GetPostFlags(postID)
+	return flags.Locked, err
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type ProfileServiceIR interface {
+	GetPublicProfile(profileID int, viewer models.User, tab string) (models.ProfileInfo, error)
+	GetPrivacy(userID int) (models.Privacy, error)
+	SavePrivacy(privacy models.Privacy) error
+	UpdateAvatar(userID int, file io.ReadSeeker) error
+	UpdateBanner(userID int, file io.ReadSeeker) error
+	UpdateBio(userID int, bio string) error
+}
+
+const maxBioLen = 300
+
+type ProfileService struct {
+	storage *storage.Storage
+	jobs    *JobQueue
+}
+
+// imageJob is the payload of an image job: an upload in PendingImageDir to
+// become the avatar or the banner of the user.
+type imageJob struct {
+	UserID int
+	Target string
+	File   string
+}
+
+func NewProfileService(storage *storage.Storage, jobs *JobQueue) *ProfileService {
+	p := &ProfileService{
+		storage: storage,
+		jobs:    jobs,
+	}
+	jobs.Handle(JobImage, 3, time.Minute, p.runImageJob)
+	return p
+}
+
+// GetPublicProfile loads what viewer is allowed to see of the profile. The
+// owner always sees everything; everyone else gets what the privacy settings
+// allow, and guests nothing at all when the profile is members only.
+func (p *ProfileService) GetPublicProfile(profileID int, viewer models.User, tab string) (models.ProfileInfo, error) {
+	profileUser, err := p.storage.User.GetUserById(profileID)
+	if err != nil {
+		return models.ProfileInfo{}, err
+	}
+	privacy, err := p.storage.ProfileIR.GetPrivacy(profileID)
+	if err != nil {
+		return models.ProfileInfo{}, err
+	}
+	info := models.ProfileInfo{
+		User:        viewer,
+		ProfileUser: profileUser,
+		IsOwner:     viewer.IsAuth && viewer.Id == profileID,
+		Privacy:     privacy,
+		Tab:         tab,
+	}
+	if !info.IsOwner && !privacy.ShowEmail {
+		info.ProfileUser.Email = ""
+	}
+	if !info.IsOwner && !viewer.IsAuth && !privacy.PublicProfile {
+		// an empty Tab tells the page that the whole profile is hidden
+		info.Hidden = true
+		info.Tab = ""
+		return info, nil
+	}
+	if info.Reputation, err = p.storage.ReputationIR.GetReputation(profileID); err != nil {
+		return models.ProfileInfo{}, err
+	}
+	if info.Badges, err = p.storage.BadgeIR.GetUserBadges(profileID); err != nil {
+		return models.ProfileInfo{}, err
+	}
+
+	switch tab {
+	case "comments":
+		if !info.IsOwner && !privacy.ShowComments {
+			info.Hidden = true
+			break
+		}
+		info.Comments, err = p.storage.ProfileIR.GetCommentsByUser(profileID)
+	case "liked":
+		if !info.IsOwner && !privacy.ShowLiked {
+			info.Hidden = true
+			break
+		}
+		info.Posts, err = p.storage.PostIR.GetMyLikedPost(profileID)
+	default:
+		info.Tab = "posts"
+		if info.IsOwner {
+			info.Posts, err = p.storage.PostIR.GetMyPost(profileID)
+		} else {
+			info.Posts, err = p.storage.ProfileIR.GetPublishedPosts(profileID)
+		}
+	}
+	if err != nil {
+		return models.ProfileInfo{}, err
+	}
+	return info, nil
+}
+
+func (p *ProfileService) GetPrivacy(userID int) (models.Privacy, error) {
+	return p.storage.ProfileIR.GetPrivacy(userID)
+}
+
+func (p *ProfileService) SavePrivacy(privacy models.Privacy) error {
+	return p.storage.ProfileIR.SavePrivacy(privacy)
+}
+
+// UpdateAvatar checks the upload and leaves cropping it to a square avatar
+// to an image job, which then replaces the previous one.
+func (p *ProfileService) UpdateAvatar(userID int, file io.ReadSeeker) error {
+	return p.queueImage(userID, "avatar", file)
+}
+
+// UpdateBanner is UpdateAvatar for the wide profile background.
+func (p *ProfileService) UpdateBanner(userID int, file io.ReadSeeker) error {
+	return p.queueImage(userID, "banner", file)
+}
+
+func (p *ProfileService) queueImage(userID int, target string, file io.ReadSeeker) error {
+	if err := checkImageSize(file); err != nil {
+		return err
+	}
+	name, err := stageImage(userID, file)
+	if err != nil {
+		return err
+	}
+	if err := p.jobs.Enqueue(JobImage, imageJob{UserID: userID, Target: target, File: name}); err != nil {
+		removeUploadedImage(userID, PendingImageDir, name)
+		return err
+	}
+	return nil
+}
+
+// runImageJob crops a staged upload and makes it the avatar or banner.
+func (p *ProfileService) runImageJob(ctx context.Context, payload []byte) error {
+	var job imageJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	if filepath.Base(job.File) != job.File {
+		return fmt.Errorf("image job: bad file name %q", job.File)
+	}
+	file, err := os.Open(filepath.Join(PendingImageDir, job.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	switch job.Target {
+	case "avatar":
+		err = p.saveAvatar(job.UserID, file)
+	case "banner":
+		err = p.saveBanner(job.UserID, file)
+	default:
+		err = fmt.Errorf("image job: unknown target %q", job.Target)
+	}
+	if err != nil {
+		return err
+	}
+	removeUploadedImage(job.UserID, PendingImageDir, job.File)
+	return nil
+}
+
+// saveAvatar crops the image to a square avatar and replaces the previous
+// one. Bundled default images are never removed.
+func (p *ProfileService) saveAvatar(userID int, file io.ReadSeeker) error {
+	user, err := p.storage.User.GetUserById(userID)
+	if err != nil {
+		return err
+	}
+	img, err := cropResize(file, avatarSize, avatarSize)
+	if err != nil {
+		return err
+	}
+	name := uploadedImageName(userID, ".png")
+	if err := saveImage(img, AvatarDir, name); err != nil {
+		return err
+	}
+	if err := p.storage.ProfileIR.UpdateAvatar(userID, name); err != nil {
+		removeUploadedImage(userID, AvatarDir, name)
+		return err
+	}
+	removeUploadedImage(userID, AvatarDir, user.ImageURL)
+	return nil
+}
+
+// saveBanner is saveAvatar for the wide profile background.
+func (p *ProfileService) saveBanner(userID int, file io.ReadSeeker) error {
+	user, err := p.storage.User.GetUserById(userID)
+	if err != nil {
+		return err
+	}
+	img, err := cropResize(file, bannerWidth, bannerHeight)
+	if err != nil {
+		return err
+	}
+	name := uploadedImageName(userID, ".jpg")
+	if err := saveImage(img, BannerDir, name); err != nil {
+		return err
+	}
+	if err := p.storage.ProfileIR.UpdateBanner(userID, name); err != nil {
+		removeUploadedImage(userID, BannerDir, name)
+		return err
+	}
+	removeUploadedImage(userID, BannerDir, user.ImageBack)
+	return nil
+}
+
+func (p *ProfileService) UpdateBio(userID int, bio string) error {
+	bio = strings.TrimSpace(strings.ReplaceAll(bio, "\r\n", "\n"))
+	if utf8.RuneCountInString(bio) > maxBioLen {
+		return errors.New(" bio should be shorter than 301 symbols")
+	}
+	return p.storage.ProfileIR.UpdateBio(userID, bio)
+}
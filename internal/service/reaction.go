@@ -11,16 +11,38 @@ type EmotionServiceIR interface {
 }
 
 type EmotionService struct {
-	storage storage.ReactionIR
+	storage    storage.ReactionIR
+	reputation ReputationServiceIR
 }
 
-func NewEmotionService(storage storage.ReactionIR) EmotionServiceIR {
+func NewEmotionService(storage storage.ReactionIR, reputation ReputationServiceIR) EmotionServiceIR {
 	return &EmotionService{
 		storage,
+		reputation,
 	}
 }
 
 func (e *EmotionService) CreateOrUpdateEmotionPost(postEmo models.Like) (error, bool) {
+	err, existEmo := e.createOrUpdateEmotionPost(postEmo)
+	if err == nil {
+		if err := e.reputation.RecordReaction("post", postEmo.PostID, postEmo.UserID); err != nil {
+			models.ErrLog.Println("reputation:", err)
+		}
+	}
+	return err, existEmo
+}
+
+func (e *EmotionService) CreateOrUpdateEmotionComment(commentEmo models.Like) (error, bool) {
+	err, existEmo := e.createOrUpdateEmotionComment(commentEmo)
+	if err == nil {
+		if err := e.reputation.RecordReaction("comment", commentEmo.CommentID, commentEmo.UserID); err != nil {
+			models.ErrLog.Println("reputation:", err)
+		}
+	}
+	return err, existEmo
+}
+
+func (e *EmotionService) createOrUpdateEmotionPost(postEmo models.Like) (error, bool) {
 	exists1, err := e.storage.EmotionPostExistsFull(postEmo)
 	if err != nil {
 		return err, true
@@ -51,7 +73,7 @@ func (e *EmotionService) CreateOrUpdateEmotionPost(postEmo models.Like) (error,
 	return nil, existEmo
 }
 
-func (e *EmotionService) CreateOrUpdateEmotionComment(commentEmo models.Like) (error, bool) {
+func (e *EmotionService) createOrUpdateEmotionComment(commentEmo models.Like) (error, bool) {
 	exists1, err := e.storage.EmotionCommentExistsFull(commentEmo)
 	if err != nil {
 		return err, true
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"forum/internal/models"
+	"forum/internal/server"
+	"forum/internal/storage"
+)
+
+type ReputationServiceIR interface {
+	GetReputation(userID int) (int, error)
+	HasPrivilege(userID int, privilege string) (bool, error)
+	PrivilegeThreshold(privilege string) int
+	CanSkipModeration(user models.User) (bool, error)
+	RecordReaction(source string, sourceID, actorID int) error
+	BackfillReputation() error
+}
+
+// Privileges unlocked by reputation.
+const (
+	PrivilegeNoModeration   = "no_moderation"
+	PrivilegeCreatePolls    = "create_polls"
+	PrivilegeModeratorOffer = "moderator_offer"
+)
+
+type ReputationService struct {
+	storage storage.ReputationIR
+	config  server.ReputationConfig
+}
+
+func NewReputationService(storage storage.ReputationIR, config server.ReputationConfig) *ReputationService {
+	if config == (server.ReputationConfig{}) {
+		config = server.ReputationConfig{
+			PostLike:       5,
+			PostDislike:    -2,
+			CommentLike:    2,
+			CommentDislike: -1,
+			DailyCap:       50,
+			NoModeration:   50,
+			CreatePolls:    100,
+			ModeratorOffer: 500,
+		}
+	}
+	return &ReputationService{
+		storage: storage,
+		config:  config,
+	}
+}
+
+func (r *ReputationService) GetReputation(userID int) (int, error) {
+	return r.storage.GetReputation(userID)
+}
+
+func (r *ReputationService) PrivilegeThreshold(privilege string) int {
+	switch privilege {
+	case PrivilegeNoModeration:
+		return r.config.NoModeration
+	case PrivilegeCreatePolls:
+		return r.config.CreatePolls
+	case PrivilegeModeratorOffer:
+		return r.config.ModeratorOffer
+	}
+	return 0
+}
+
+func (r *ReputationService) HasPrivilege(userID int, privilege string) (bool, error) {
+	threshold := r.PrivilegeThreshold(privilege)
+	if threshold <= 0 {
+		return false, nil
+	}
+	reputation, err := r.storage.GetReputation(userID)
+	if err != nil {
+		return false, err
+	}
+	return reputation >= threshold, nil
+}
+
+// CanSkipModeration reports whether posts of user are published without
+// going through the moderation queue: staff, or enough reputation.
+func (r *ReputationService) CanSkipModeration(user models.User) (bool, error) {
+	if isStaff(user) {
+		return true, nil
+	}
+	return r.HasPrivilege(user.Id, PrivilegeNoModeration)
+}
+
+// RecordReaction brings the ledger in line with actorID's current reaction on
+// a post or comment. Positive points are cut once the author reaches the
+// daily cap; reacting to your own content earns nothing.
+func (r *ReputationService) RecordReaction(source string, sourceID, actorID int) error {
+	authorID, like, err := r.storage.GetReaction(source, sourceID, actorID)
+	if err != nil {
+		return err
+	}
+	if err := r.storage.DeleteReputationEvent(actorID, source, sourceID); err != nil {
+		return err
+	}
+	if authorID == actorID || like < 0 {
+		return nil
+	}
+
+	points := r.weight(source, like == 1)
+	if points > 0 && r.config.DailyCap > 0 {
+		gained, err := r.storage.GetPointsGainedToday(authorID)
+		if err != nil {
+			return err
+		}
+		if left := r.config.DailyCap - gained; points > left {
+			points = max(left, 0)
+		}
+	}
+	return r.storage.SaveReputationEvent(models.ReputationEvent{
+		UserID:   authorID,
+		ActorID:  actorID,
+		Source:   source,
+		SourceID: sourceID,
+		Points:   points,
+	})
+}
+
+func (r *ReputationService) weight(source string, like bool) int {
+	switch {
+	case source == "post" && like:
+		return r.config.PostLike
+	case source == "post":
+		return r.config.PostDislike
+	case like:
+		return r.config.CommentLike
+	default:
+		return r.config.CommentDislike
+	}
+}
+
+func (r *ReputationService) BackfillReputation() error {
+	n, err := r.storage.BackfillReputation(r.config.PostLike, r.config.PostDislike, r.config.CommentLike, r.config.CommentDislike)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		models.InfoLog.Printf("reputation: backfilled %d events from existing reactions", n)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"errors"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"strings"
+	"time"
+)
+
+type RiskAdminServiceIR interface {
+	ListRiskUsers(actor models.User, level string) ([]models.RiskUser, error)
+	GetRiskTimeline(actor models.User, userID int) (models.RiskAssessment, []models.AuthLog, []models.RiskOverride, error)
+	PinRisk(actor models.User, userID int, level string, period time.Duration, note string) error
+	UnpinRisk(actor models.User, userID int, note string) error
+	ResetRisk(actor models.User, userID int, note string) error
+	ExportEvents(actor models.User, userID int) ([]models.AuthLog, error)
+}
+
+var (
+	ErrNotAdmin      = errors.New(" only admins can see and change risk levels")
+	ErrRiskPinLevel  = errors.New(" pick GREEN, YELLOW or RED")
+	ErrRiskPinPeriod = errors.New(" a pin lasts from an hour to 90 days")
+	ErrOverrideNote  = errors.New(" the note is too long")
+)
+
+const (
+	// riskTimelineLength is how many events the dashboard shows for a user.
+	riskTimelineLength = 100
+	maxRiskPin         = 90 * 24 * time.Hour
+	maxOverrideNote    = 200
+)
+
+type RiskAdminService struct {
+	storage *storage.Storage
+}
+
+func NewRiskAdminService(storages *storage.Storage) *RiskAdminService {
+	return &RiskAdminService{
+		storage: storages,
+	}
+}
+
+func isAdmin(user models.User) bool {
+	return user.IsAuth && (user.Rol == "king" || user.Rol == "admin")
+}
+
+func (r *RiskAdminService) ListRiskUsers(actor models.User, level string) ([]models.RiskUser, error) {
+	if !isAdmin(actor) {
+		return nil, ErrNotAdmin
+	}
+	if level != "" && riskPriority(level) == 0 {
+		return nil, ErrRiskPinLevel
+	}
+	return r.storage.RiskAdminIR.ListRiskUsers(level)
+}
+
+// GetRiskTimeline returns the assessment of the user with its explanation,
+// their latest events and the overrides of admins.
+func (r *RiskAdminService) GetRiskTimeline(actor models.User, userID int) (models.RiskAssessment, []models.AuthLog, []models.RiskOverride, error) {
+	if !isAdmin(actor) {
+		return models.RiskAssessment{}, nil, nil, ErrNotAdmin
+	}
+	assessment, err := r.storage.AuthRiskIR.GetRiskAssessmentByUserID(userID)
+	if err != nil {
+		return models.RiskAssessment{}, nil, nil, err
+	}
+	events, err := r.storage.RiskAdminIR.GetEventsByUserID(userID, riskTimelineLength)
+	if err != nil {
+		return models.RiskAssessment{}, nil, nil, err
+	}
+	overrides, err := r.storage.GetRiskOverrides(userID)
+	if err != nil {
+		return models.RiskAssessment{}, nil, nil, err
+	}
+	return assessment, events, overrides, nil
+}
+
+// PinRisk holds the level of the user for period, whatever the risk engine
+// finds meanwhile. Pinning RED also ends the sessions of the user, as the
+// engine does when the level rises.
+func (r *RiskAdminService) PinRisk(actor models.User, userID int, level string, period time.Duration, note string) error {
+	if !isAdmin(actor) {
+		return ErrNotAdmin
+	}
+	if riskPriority(level) == 0 {
+		return ErrRiskPinLevel
+	}
+	if period < time.Hour || period > maxRiskPin {
+		return ErrRiskPinPeriod
+	}
+	note, err := overrideNote(note)
+	if err != nil {
+		return err
+	}
+	if err := r.storage.OverrideRisk(userID, "pin", level, note, actor.Id, time.Now().Add(period)); err != nil {
+		return err
+	}
+	if level == "RED" {
+		return r.storage.Auth.DeleteTokenByUserID(userID)
+	}
+	return nil
+}
+
+// UnpinRisk lets the risk engine decide again from the next sign in on.
+func (r *RiskAdminService) UnpinRisk(actor models.User, userID int, note string) error {
+	if !isAdmin(actor) {
+		return ErrNotAdmin
+	}
+	note, err := overrideNote(note)
+	if err != nil {
+		return err
+	}
+	return r.storage.OverrideRisk(userID, "unpin", "", note, actor.Id, time.Time{})
+}
+
+// ResetRisk sets the user to GREEN and drops a pin.
+func (r *RiskAdminService) ResetRisk(actor models.User, userID int, note string) error {
+	if !isAdmin(actor) {
+		return ErrNotAdmin
+	}
+	note, err := overrideNote(note)
+	if err != nil {
+		return err
+	}
+	return r.storage.OverrideRisk(userID, "reset", "GREEN", note, actor.Id, time.Time{})
+}
+
+// ExportEvents returns every event of the user, and records who took them.
+func (r *RiskAdminService) ExportEvents(actor models.User, userID int) ([]models.AuthLog, error) {
+	if !isAdmin(actor) {
+		return nil, ErrNotAdmin
+	}
+	if _, err := r.storage.AuthRiskIR.GetRiskAssessmentByUserID(userID); err != nil {
+		return nil, err
+	}
+	if err := r.storage.OverrideRisk(userID, "export", "", "", actor.Id, time.Time{}); err != nil {
+		return nil, err
+	}
+	return r.storage.RiskAdminIR.GetEventsByUserID(userID, 0)
+}
+
+func overrideNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if len([]rune(note)) > maxOverrideNote {
+		return "", ErrOverrideNote
+	}
+	return note, nil
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/server"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RiskInput is what the scorers look at: the stored assessment with the
+// latest auth logs, newest first, and the sign in being judged.
+type RiskInput struct {
+	Assessment models.RiskAssessment
+	Current    models.AuthLog
+	Now        time.Time
+}
+
+// RiskScorer is one signal of the risk engine. Score returns 0 when nothing
+// is unusual and up to 1, with a short reason when it is above 0.
+type RiskScorer interface {
+	Name() string
+	Score(ctx context.Context, in RiskInput) (float64, string, error)
+}
+
+// riskEngine runs the scorers that have a weight in the rules and lets the
+// rules turn the results into a level.
+type riskEngine struct {
+	scorers []RiskScorer
+	rules   riskRules
+}
+
+// riskScorers returns the built in scorers, the LLM included when it is
+// configured.
+func riskScorers(config server.LLMConfig) []RiskScorer {
+	scorers := []RiskScorer{failureVelocity{}, newIP{}, newDevice{}, newCountry{}, impossibleTravel{}, unusualHour{}}
+	if llm := newLLMScorer(config); llm != nil {
+		scorers = append(scorers, llm)
+	}
+	return scorers
+}
+
+func newRiskEngine(rules riskRules, scorers ...RiskScorer) *riskEngine {
+	engine := &riskEngine{rules: rules}
+	for _, scorer := range scorers {
+		if _, ok := rules.weights[scorer.Name()]; ok {
+			engine.scorers = append(engine.scorers, scorer)
+		}
+	}
+	return engine
+}
+
+// Assess returns the level with its explanation. A scorer that fails counts
+// as 0, so a broken LLM endpoint does not block sign ins.
+func (e *riskEngine) Assess(ctx context.Context, in RiskInput) (string, models.RiskExplanation) {
+	if in.Now.IsZero() {
+		in.Now = time.Now()
+	}
+	features := make(map[string]float64, len(e.scorers)+1)
+	explanation := models.RiskExplanation{}
+	for _, scorer := range e.scorers {
+		score, reason, err := scorer.Score(ctx, in)
+		if err != nil {
+			models.ErrLog.Printf("risk scorer %s: %v", scorer.Name(), err)
+			score, reason = 0, "unavailable"
+		}
+		score = math.Max(0, math.Min(1, score))
+		weight := e.rules.weights[scorer.Name()]
+		features[scorer.Name()] = score
+		explanation.Score += score * weight
+		explanation.Factors = append(explanation.Factors, models.RiskFactor{
+			Name:   scorer.Name(),
+			Score:  score,
+			Weight: weight,
+			Reason: reason,
+		})
+	}
+	explanation.Score = math.Round(explanation.Score*100) / 100
+	features["score"] = explanation.Score
+
+	level, rule := e.rules.decide(features)
+	explanation.Rule = rule
+	return level, explanation
+}
+
+// riskSummary is the short reason shown next to a level: the factors that
+// scored, strongest first.
+func riskSummary(explanation models.RiskExplanation) string {
+	factors := make([]models.RiskFactor, 0, len(explanation.Factors))
+	for _, factor := range explanation.Factors {
+		if factor.Score > 0 && factor.Reason != "" {
+			factors = append(factors, factor)
+		}
+	}
+	if len(factors) == 0 {
+		return "no risk signals"
+	}
+	sort.SliceStable(factors, func(i, j int) bool {
+		return factors[i].Score*factors[i].Weight > factors[j].Score*factors[j].Weight
+	})
+	reasons := make([]string, len(factors))
+	for i, factor := range factors {
+		reasons[i] = factor.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+const failureWindow = 15 * time.Minute
+
+// failureVelocity scores failed attempts of the last minutes: five of them,
+// or three in a row, give 1.
+type failureVelocity struct{}
+
+func (failureVelocity) Name() string { return "failure_velocity" }
+
+func (failureVelocity) Score(_ context.Context, in RiskInput) (float64, string, error) {
+	fails, streak, inStreak := 0, 0, true
+	for _, lg := range in.Assessment.AuthLogs {
+		if lg.Status {
+			inStreak = false
+			continue
+		}
+		if inStreak {
+			streak++
+		}
+		if !lg.Time.IsZero() && in.Now.Sub(lg.Time) <= failureWindow {
+			fails++
+		}
+	}
+	score := math.Max(float64(fails)/5, float64(streak)/3)
+	if score == 0 {
+		return 0, "", nil
+	}
+	return score, fmt.Sprintf("%d failed attempts in %d minutes, %d in a row", fails, int(failureWindow.Minutes()), streak), nil
+}
+
+// newIP scores a sign in from an address the user never signed in from.
+type newIP struct{}
+
+func (newIP) Name() string { return "new_ip" }
+
+func (newIP) Score(_ context.Context, in RiskInput) (float64, string, error) {
+	ip := in.Current.IP
+	if ip == "" || ip == in.Assessment.PrimaryIP || seenOnSuccess(in.Assessment.AuthLogs, func(lg models.AuthLog) bool { return lg.IP == ip }) {
+		return 0, "", nil
+	}
+	return 1, "first sign in from " + ip, nil
+}
+
+// newDevice scores a sign in from a browser the user never signed in from.
+type newDevice struct{}
+
+func (newDevice) Name() string { return "new_device" }
+
+func (newDevice) Score(_ context.Context, in RiskInput) (float64, string, error) {
+	device := in.Current.Device
+	if device == "" || device == in.Assessment.PrimaryDevice || seenOnSuccess(in.Assessment.AuthLogs, func(lg models.AuthLog) bool { return lg.Device == device }) {
+		return 0, "", nil
+	}
+	return 1, "first sign in from this device", nil
+}
+
+// newCountry scores a sign in from a country that is neither the user's
+// home nor one they signed in from before.
+type newCountry struct{}
+
+func (newCountry) Name() string { return "new_country" }
+
+func (newCountry) Score(_ context.Context, in RiskInput) (float64, string, error) {
+	geo := in.Current.Geo
+	if geo == "" || geo == in.Assessment.PrimaryGeo || seenOnSuccess(in.Assessment.AuthLogs, func(lg models.AuthLog) bool { return lg.Geo == geo }) {
+		return 0, "", nil
+	}
+	return 1, "first sign in from " + geo, nil
+}
+
+func seenOnSuccess(logs []models.AuthLog, same func(models.AuthLog) bool) bool {
+	for _, lg := range logs {
+		if lg.Status && same(lg) {
+			return true
+		}
+	}
+	return false
+}
+
+const travelWindow = 2 * time.Hour
+
+// impossibleTravel scores a sign in from another place than the last
+// successful one, sooner than anyone could travel between them.
+type impossibleTravel struct{}
+
+func (impossibleTravel) Name() string { return "impossible_travel" }
+
+func (impossibleTravel) Score(_ context.Context, in RiskInput) (float64, string, error) {
+	if in.Current.Geo == "" {
+		return 0, "", nil
+	}
+	for _, lg := range in.Assessment.AuthLogs {
+		if !lg.Status || lg.Geo == "" || lg.Time.IsZero() {
+			continue
+		}
+		since := in.Now.Sub(lg.Time)
+		if lg.Geo == in.Current.Geo || since > travelWindow {
+			return 0, "", nil
+		}
+		return 1, fmt.Sprintf("signed in from %s %d minutes after %s", in.Current.Geo, int(since.Minutes()), lg.Geo), nil
+	}
+	return 0, "", nil
+}
+
+// unusualHour scores a sign in far from the hour the user is usually
+// online: up to three hours off is normal, twelve gives 1.
+type unusualHour struct{}
+
+func (unusualHour) Name() string { return "unusual_hour" }
+
+func (unusualHour) Score(_ context.Context, in RiskInput) (float64, string, error) {
+	usual := in.Assessment.PrimaryOnlineTime
+	if usual.IsZero() {
+		return 0, "", nil
+	}
+	diff := in.Now.Hour() - usual.Hour()
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > 12 {
+		diff = 24 - diff
+	}
+	if diff <= 3 {
+		return 0, "", nil
+	}
+	return float64(diff-3) / 9, fmt.Sprintf("signed in at %02d:00, usually around %02d:00", in.Now.Hour(), usual.Hour()), nil
+}
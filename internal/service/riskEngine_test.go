@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"forum/internal/models"
+	"forum/internal/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRiskRules(t *testing.T) {
+	rules, err := parseRiskRules(defaultRiskRules)
+	require.NoError(t, err)
+	require.Equal(t, 4.0, rules.weights["failure_velocity"])
+
+	level, rule := rules.decide(map[string]float64{"failure_velocity": 1, "score": 4})
+	require.Equal(t, "RED", level)
+	require.Equal(t, "RED if failure_velocity >= 1", rule)
+	level, _ = rules.decide(map[string]float64{"score": 1})
+	require.Equal(t, "YELLOW", level)
+	level, _ = rules.decide(map[string]float64{})
+	require.Equal(t, "GREEN", level)
+
+	rules, err = parseRiskRules("weight a 1\nRED if a >= 1 and b < 0.5 # comment\n")
+	require.NoError(t, err)
+	level, _ = rules.decide(map[string]float64{"a": 1, "b": 0.2})
+	require.Equal(t, "RED", level)
+	level, _ = rules.decide(map[string]float64{"a": 1, "b": 0.7})
+	require.Equal(t, "YELLOW", level, "no rule matched")
+
+	for _, bad := range []string{
+		"",
+		"weight a",
+		"weight a x",
+		"BLUE",
+		"RED when a > 1",
+		"RED if a ~ 1",
+		"RED if a > 1 or b > 1",
+		"RED if a >",
+	} {
+		_, err := parseRiskRules(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestRiskEngine_Assess(t *testing.T) {
+	rules, err := parseRiskRules(defaultRiskRules)
+	require.NoError(t, err)
+	engine := newRiskEngine(rules, riskScorers(server.LLMConfig{})...)
+	now := time.Date(2026, 3, 1, 14, 0, 0, 0, time.UTC)
+	known := models.AuthLog{IP: "10.0.0.1", Device: "firefox", Status: true, Time: now.Add(-24 * time.Hour)}
+	assessment := models.RiskAssessment{
+		PrimaryIP:         "10.0.0.1",
+		PrimaryDevice:     "firefox",
+		PrimaryOnlineTime: now.Add(-time.Hour),
+		AuthLogs:          []models.AuthLog{known},
+	}
+
+	level, explanation := engine.Assess(context.Background(), RiskInput{Assessment: assessment, Current: known, Now: now})
+	require.Equal(t, "GREEN", level)
+	require.Zero(t, explanation.Score)
+	require.Len(t, explanation.Factors, 6)
+
+	level, explanation = engine.Assess(context.Background(), RiskInput{
+		Assessment: assessment,
+		Current:    models.AuthLog{IP: "192.0.2.7", Device: "firefox"},
+		Now:        now,
+	})
+	require.Equal(t, "YELLOW", level)
+	require.Equal(t, "first sign in from 192.0.2.7", riskSummary(explanation))
+
+	home := assessment
+	home.PrimaryGeo = "Kazakhstan"
+	level, explanation = engine.Assess(context.Background(), RiskInput{
+		Assessment: home,
+		Current:    models.AuthLog{IP: "10.0.0.1", Device: "firefox", Geo: "Brazil"},
+		Now:        now,
+	})
+	require.Equal(t, "YELLOW", level)
+	require.Equal(t, "first sign in from Brazil", riskSummary(explanation))
+
+	failed := assessment
+	failed.AuthLogs = nil
+	for i := 0; i < 3; i++ {
+		failed.AuthLogs = append(failed.AuthLogs, models.AuthLog{IP: "10.0.0.1", Time: now.Add(-time.Minute)})
+	}
+	failed.AuthLogs = append(failed.AuthLogs, known)
+	level, explanation = engine.Assess(context.Background(), RiskInput{Assessment: failed, Current: known, Now: now})
+	require.Equal(t, "RED", level)
+	require.Equal(t, "RED if failure_velocity >= 1", explanation.Rule)
+
+	travel := assessment
+	travel.AuthLogs = []models.AuthLog{{IP: "10.0.0.1", Device: "firefox", Geo: "Kazakhstan", Status: true, Time: now.Add(-30 * time.Minute)}}
+	level, explanation = engine.Assess(context.Background(), RiskInput{
+		Assessment: travel,
+		Current:    models.AuthLog{IP: "10.0.0.1", Device: "firefox", Geo: "Brazil"},
+		Now:        now,
+	})
+	require.Equal(t, "RED", level)
+	require.Contains(t, riskSummary(explanation), "Brazil 30 minutes after Kazakhstan")
+}
+
+func TestUnusualHour(t *testing.T) {
+	usual := time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)
+	for hour, want := range map[int]float64{22: 0, 1: 0, 3: 2.0 / 9, 10: 1} {
+		score, _, err := unusualHour{}.Score(context.Background(), RiskInput{
+			Assessment: models.RiskAssessment{PrimaryOnlineTime: usual},
+			Now:        time.Date(2026, 3, 2, hour, 0, 0, 0, time.UTC),
+		})
+		require.NoError(t, err)
+		require.InDelta(t, want, score, 1e-9, "hour %d", hour)
+	}
+}
+
+func TestLLMScorer(t *testing.T) {
+	var model string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		model, _ = payload["model"].(string)
+		json.NewEncoder(w).Encode(ollamaGenerateResp{Response: `Sure: {"risk_level":"red","reason":"burst of failures"}`, Done: true})
+	}))
+	defer srv.Close()
+
+	require.Nil(t, newLLMScorer(server.LLMConfig{}))
+	scorer := newLLMScorer(server.LLMConfig{APIURL: srv.URL, Model: "llama3", Timeout: "5s"})
+	require.Equal(t, 5*time.Second, scorer.timeout)
+	score, reason, err := scorer.Score(context.Background(), RiskInput{})
+	require.NoError(t, err)
+	require.Equal(t, "llama3", model)
+	require.Equal(t, 1.0, score)
+	require.Equal(t, "llm: burst of failures", reason)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	rules, err := parseRiskRules(defaultRiskRules)
+	require.NoError(t, err)
+	engine := newRiskEngine(rules, newLLMScorer(server.LLMConfig{APIURL: slow.URL, Timeout: "50ms"}))
+	level, explanation := engine.Assess(context.Background(), RiskInput{})
+	require.Equal(t, "GREEN", level, "a failing scorer counts as 0")
+	require.Equal(t, "unavailable", explanation.Factors[0].Reason)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"forum/internal/server"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLLMModel   = "mistral"
+	defaultLLMTimeout = 40 * time.Second
+)
+
+// llmScorer asks an Ollama style endpoint to judge the logs. Its level is
+// turned into a score: GREEN 0, YELLOW 0.5, RED 1.
+type llmScorer struct {
+	apiURL  string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// newLLMScorer returns nil when no endpoint is configured.
+func newLLMScorer(config server.LLMConfig) *llmScorer {
+	if strings.TrimSpace(config.APIURL) == "" {
+		return nil
+	}
+	scorer := &llmScorer{
+		apiURL:  config.APIURL,
+		model:   config.Model,
+		timeout: defaultLLMTimeout,
+		client:  &http.Client{},
+	}
+	if scorer.model == "" {
+		scorer.model = defaultLLMModel
+	}
+	if timeout, err := time.ParseDuration(config.Timeout); err == nil && timeout > 0 {
+		scorer.timeout = timeout
+	}
+	return scorer
+}
+
+func (l *llmScorer) Name() string { return "llm" }
+
+type ollamaGenerateResp struct {
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error"`
+}
+
+type llmMini struct {
+	RiskLevel string `json:"risk_level"`
+	Reason    string `json:"reason"`
+}
+
+func (l *llmScorer) Score(ctx context.Context, in RiskInput) (float64, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	r := in.Assessment
+	logs := r.AuthLogs
+	if len(logs) > 10 {
+		logs = logs[:10]
+	}
+
+	b, _ := json.Marshal(logs)
+	current, _ := json.Marshal(in.Current)
+
+	prompt := `
+Evaluate authentication risk using logs and current login data.
+Return ONLY valid JSON (no extra text):
+{"risk_level":"GREEN|YELLOW|RED","reason":"short technical reason"}
+Use highest detected severity. If insufficient data -> YELLOW.
+Primary IP: ` + r.PrimaryIP + `
+Primary Device: ` + r.PrimaryDevice + `
+Primary Geo: ` + r.PrimaryGeo + `
+Current login: ` + string(current) + `
+Logs: ` + string(b)
+
+	payload := map[string]interface{}{
+		"model":  l.model,
+		"prompt": prompt,
+		"stream": false,
+	}
+
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		l.apiURL,
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<10))
+		return 0, "", errors.New("ollama http status: " + resp.Status + " body: " + string(raw))
+	}
+
+	var gr ollamaGenerateResp
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return 0, "", err
+	}
+	if gr.Error != "" {
+		return 0, "", errors.New("ollama error: " + gr.Error)
+	}
+
+	txt := strings.TrimSpace(gr.Response)
+	if txt == "" {
+		return 0, "", errors.New("ollama empty response")
+	}
+
+	start := strings.IndexByte(txt, '{')
+	end := strings.LastIndexByte(txt, '}')
+	if start == -1 || end == -1 || end <= start {
+		return 0, "", errors.New("llm returned non-json: " + txt)
+	}
+	jsonPart := txt[start : end+1]
+
+	var mini llmMini
+	if err := json.Unmarshal([]byte(jsonPart), &mini); err != nil {
+		return 0, "", errors.New("bad json from llm: " + err.Error() + " raw: " + jsonPart)
+	}
+
+	mini.RiskLevel = strings.ToUpper(strings.TrimSpace(mini.RiskLevel))
+	mini.Reason = strings.TrimSpace(mini.Reason)
+
+	if mini.Reason == "" {
+		return 0, "", errors.New("empty reason from llm")
+	}
+	switch mini.RiskLevel {
+	case "GREEN":
+		return 0, "", nil
+	case "YELLOW":
+		return 0.5, "llm: " + mini.Reason, nil
+	case "RED":
+		return 1, "llm: " + mini.Reason, nil
+	default:
+		return 0, "", errors.New("invalid risk_level from llm: " + mini.RiskLevel)
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultRiskRules are used when no rule file is configured, and are the
+// example to start one from. Counted over the last 15 minutes, five
+// failures or three in a row since the last success are RED, as before,
+// and so is impossible travel; two failures or an unknown IP, device or
+// country are YELLOW.
+//
+//go:embed risk.rules
+var defaultRiskRules string
+
+type riskRules struct {
+	weights   map[string]float64
+	decisions []riskDecision
+}
+
+type riskDecision struct {
+	level string
+	conds []riskCondition
+	text  string
+}
+
+type riskCondition struct {
+	feature string
+	op      string
+	value   float64
+}
+
+// loadRiskRules reads the rule file at path, or the built in rules when
+// path is empty.
+func loadRiskRules(path string) (riskRules, error) {
+	if path == "" {
+		return parseRiskRules(defaultRiskRules)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return riskRules{}, err
+	}
+	return parseRiskRules(string(data))
+}
+
+func parseRiskRules(text string) (riskRules, error) {
+	rules := riskRules{weights: make(map[string]float64)}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := 0
+	for scanner.Scan() {
+		line++
+		content, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(content)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "weight" {
+			if len(fields) != 3 {
+				return riskRules{}, fmt.Errorf("risk rules line %d: want weight <scorer> <number>", line)
+			}
+			weight, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return riskRules{}, fmt.Errorf("risk rules line %d: %w", line, err)
+			}
+			rules.weights[fields[1]] = weight
+			continue
+		}
+
+		level := strings.ToUpper(fields[0])
+		if riskPriority(level) == 0 {
+			return riskRules{}, fmt.Errorf("risk rules line %d: unknown level %q", line, fields[0])
+		}
+		decision := riskDecision{level: level, text: strings.Join(fields, " ")}
+		if len(fields) > 1 {
+			if fields[1] != "if" {
+				return riskRules{}, fmt.Errorf("risk rules line %d: want %s if <condition>", line, level)
+			}
+			conds, err := parseRiskConditions(fields[2:])
+			if err != nil {
+				return riskRules{}, fmt.Errorf("risk rules line %d: %w", line, err)
+			}
+			decision.conds = conds
+		}
+		rules.decisions = append(rules.decisions, decision)
+	}
+	if err := scanner.Err(); err != nil {
+		return riskRules{}, err
+	}
+	if len(rules.decisions) == 0 {
+		return riskRules{}, fmt.Errorf("risk rules: no decisions")
+	}
+	return rules, nil
+}
+
+func parseRiskConditions(fields []string) ([]riskCondition, error) {
+	var conds []riskCondition
+	for {
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("want <feature> <op> <number>")
+		}
+		switch fields[1] {
+		case ">=", ">", "<=", "<", "==":
+		default:
+			return nil, fmt.Errorf("unknown operator %q", fields[1])
+		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, riskCondition{feature: fields[0], op: fields[1], value: value})
+
+		fields = fields[3:]
+		if len(fields) == 0 {
+			return conds, nil
+		}
+		if fields[0] != "and" {
+			return nil, fmt.Errorf("want and, got %q", fields[0])
+		}
+		fields = fields[1:]
+	}
+}
+
+// decide returns the first decision whose conditions all hold. Without
+// one the level is YELLOW, as when there is not enough to go on.
+func (r riskRules) decide(features map[string]float64) (string, string) {
+	for _, decision := range r.decisions {
+		if decision.matches(features) {
+			return decision.level, decision.text
+		}
+	}
+	return "YELLOW", ""
+}
+
+func (d riskDecision) matches(features map[string]float64) bool {
+	for _, cond := range d.conds {
+		value := features[cond.feature]
+		var ok bool
+		switch cond.op {
+		case ">=":
+			ok = value >= cond.value
+		case ">":
+			ok = value > cond.value
+		case "<=":
+			ok = value <= cond.value
+		case "<":
+			ok = value < cond.value
+		case "==":
+			ok = value == cond.value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func riskPriority(level string) int {
+	switch level {
+	case "GREEN":
+		return 1
+	case "YELLOW":
+		return 2
+	case "RED":
+		return 3
+	default:
+		return 0
+	}
+}
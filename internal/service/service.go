@@ -1,6 +1,9 @@
 package service
 
-import "forum/internal/storage"
+import (
+	"forum/internal/server"
+	"forum/internal/storage"
+)
 
 type Service struct {
 	Auth
@@ -11,17 +14,56 @@ type Service struct {
 	EmotionServiceIR
 	ServiceMsgIR
 	CommunicationServiceIR
+	MailServiceIR
+	DirectMessageServiceIR
+	ProfileServiceIR
+	ReputationServiceIR
+	BadgeServiceIR
+	PollServiceIR
+	DraftServiceIR
+	PostFlagServiceIR
+	CategoryServiceIR
+	OIDCServiceIR
+	TOTPServiceIR
+	AccountServiceIR
+	RiskAdminServiceIR
+	JobServiceIR
+	HealthServiceIR
 }
 
-func NewService(storages *storage.Storage) *Service {
+func NewService(storages *storage.Storage, config server.Config) *Service {
+	reputation := NewReputationService(storages.ReputationIR, config.Reputation)
+	badges := NewBadgeService(storages.BadgeIR, storages.NotificationIR)
+	jobs := NewJobQueue(storages.JobIR, config.Jobs)
+	mail := NewMailService(storages.DigestIR, NewMailer(config), jobs, config)
+	account := NewAccountService(storages, mail, jobs, config.Security)
+	risk := NewAuthRiskService(storages.AuthRiskIR, storages.Auth, account, jobs, config)
+	profile := NewProfileService(storages, jobs)
+	// every kind of job has its handler by now
+	jobs.Start()
 	return &Service{
 		Auth:                   NewAuthService(storages),
-		AuthRiskIR:             NewAuthRiskService(storages.AuthRiskIR),
+		AuthRiskIR:             risk,
 		ServicePostIR:          NewPostService(storages.PostIR),
 		User:                   NewUserService(storages),
 		CommentServiceIR:       newCommentServ(storages.CommentIR),
-		EmotionServiceIR:       NewEmotionService(storages.ReactionIR),
+		EmotionServiceIR:       NewEmotionService(storages.ReactionIR, reputation),
 		ServiceMsgIR:           NewServiceMsg(storages.NotificationIR),
 		CommunicationServiceIR: NewCommunicationService(storages.CommunicationIR),
+		MailServiceIR:          mail,
+		DirectMessageServiceIR: NewDirectMessageService(storages.DirectMessageIR),
+		ProfileServiceIR:       profile,
+		ReputationServiceIR:    reputation,
+		BadgeServiceIR:         badges,
+		PollServiceIR:          NewPollService(storages.PollIR),
+		DraftServiceIR:         NewDraftService(storages.DraftIR, storages.User, reputation, badges),
+		PostFlagServiceIR:      NewPostFlagService(storages.PostFlagIR),
+		CategoryServiceIR:      NewCategoryService(storages.CategoryIR),
+		OIDCServiceIR:          NewOIDCService(storages, config.OAuth),
+		TOTPServiceIR:          NewTOTPService(storages, config.Security),
+		AccountServiceIR:       account,
+		RiskAdminServiceIR:     NewRiskAdminService(storages),
+		JobServiceIR:           jobs,
+		HealthServiceIR:        NewHealthService(storages.HealthIR),
 	}
 }
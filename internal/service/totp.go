@@ -0,0 +1,426 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/server"
+	"forum/internal/storage"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+type TOTPServiceIR interface {
+	TOTPAvailable() bool
+	GetTOTPStatus(userID int) (models.TOTPStatus, error)
+	StartTOTPEnrollment(user models.User) (models.TOTPEnrollment, error)
+	ConfirmTOTP(userID int, code string) ([]string, error)
+	DisableTOTP(userID int, code string) error
+	RegenerateRecoveryCodes(userID int, code string) ([]string, error)
+	VerifyTOTP(userID int, code string) (recovery bool, err error)
+	SetSecondFactor(userID int, factor string) error
+	ChooseSecondFactor(userID int, riskLevel string) (string, error)
+	SignPendingLogin(username, factor string) string
+	ParsePendingLogin(value, factor string) (string, error)
+}
+
+var (
+	ErrTOTPUnavailable = errors.New(" authenticator apps are not configured on this server")
+	ErrTOTPEnabled     = errors.New(" an authenticator app is already enabled, disable it first")
+	ErrInvalidTOTP     = errors.New(" invalid or already used code")
+	ErrPendingLogin    = errors.New(" the sign in expired, please enter your password again")
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	recoveryCodeCount = 10
+	// pendingLoginTTL is how long the user has for the second step.
+	pendingLoginTTL = 5 * time.Minute
+)
+
+type TOTPService struct {
+	storage *storage.Storage
+	gcm     cipher.AEAD
+	macKey  []byte
+	issuer  string
+}
+
+// NewTOTPService derives two keys from the configured TOTP key: one encrypts
+// the secrets, the other signs pending logins.
+func NewTOTPService(storages *storage.Storage, config server.SecurityConfig) *TOTPService {
+	t := &TOTPService{
+		storage: storages,
+		issuer:  config.Issuer,
+	}
+	if t.issuer == "" {
+		t.issuer = "Elestial"
+	}
+	if config.TOTPKey == "" {
+		t.macKey = make([]byte, 32)
+		if _, err := rand.Read(t.macKey); err != nil {
+			panic(err)
+		}
+		return t
+	}
+	encKey := sha256.Sum256([]byte("totp-secret:" + config.TOTPKey))
+	macKey := sha256.Sum256([]byte("pending-login:" + config.TOTPKey))
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	t.gcm = gcm
+	t.macKey = macKey[:]
+	return t
+}
+
+func (t *TOTPService) TOTPAvailable() bool {
+	return t.gcm != nil
+}
+
+func (t *TOTPService) GetTOTPStatus(userID int) (models.TOTPStatus, error) {
+	var status models.TOTPStatus
+	factor, err := t.storage.TotpIR.GetSecondFactor(userID)
+	if err != nil {
+		return status, err
+	}
+	status.SecondFactor = factor
+	totp, err := t.storage.TotpIR.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	status.Enabled = totp.Enabled
+	if !status.Enabled {
+		return status, nil
+	}
+	status.RecoveryLeft, err = t.storage.TotpIR.CountRecoveryCodes(userID)
+	return status, err
+}
+
+// StartTOTPEnrollment makes a new secret, or reuses the pending one, and
+// returns what the app needs to scan. The secret only becomes active once
+// ConfirmTOTP sees a valid code.
+func (t *TOTPService) StartTOTPEnrollment(user models.User) (models.TOTPEnrollment, error) {
+	if !t.TOTPAvailable() {
+		return models.TOTPEnrollment{}, ErrTOTPUnavailable
+	}
+	current, err := t.storage.TotpIR.GetTOTP(user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.TOTPEnrollment{}, err
+	}
+	if current.Enabled {
+		return models.TOTPEnrollment{}, ErrTOTPEnabled
+	}
+
+	// a pending secret is shown again, so a mistyped code needs no rescan
+	raw, err := t.decrypt(current.Secret)
+	if err != nil || len(raw) == 0 {
+		raw = make([]byte, 20)
+		if _, err := rand.Read(raw); err != nil {
+			return models.TOTPEnrollment{}, err
+		}
+		encrypted, err := t.encrypt(raw)
+		if err != nil {
+			return models.TOTPEnrollment{}, err
+		}
+		if err := t.storage.TotpIR.SaveTOTPSecret(user.Id, encrypted); err != nil {
+			return models.TOTPEnrollment{}, err
+		}
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	uri := fmt.Sprintf("otpauth://totp/%s:%s?%s",
+		url.PathEscape(t.issuer),
+		url.PathEscape(user.Username),
+		url.Values{
+			"secret":    {secret},
+			"issuer":    {t.issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(totpPeriod)},
+		}.Encode())
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QR:     base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// ConfirmTOTP enables the pending secret when the code matches and returns
+// the recovery codes, which are shown this once.
+func (t *TOTPService) ConfirmTOTP(userID int, code string) ([]string, error) {
+	totp, err := t.storage.TotpIR.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidTOTP
+	}
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return nil, ErrTOTPEnabled
+	}
+	if err := t.checkCode(totp, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.storage.TotpIR.EnableTOTP(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP needs a current code or a recovery code, so a session left
+// open somewhere cannot turn the factor off.
+func (t *TOTPService) DisableTOTP(userID int, code string) error {
+	if _, err := t.VerifyTOTP(userID, code); err != nil {
+		return err
+	}
+	return t.storage.TotpIR.DisableTOTP(userID)
+}
+
+func (t *TOTPService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	if _, err := t.VerifyTOTP(userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.storage.TotpIR.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyTOTP accepts a code from the app or one of the recovery codes, and
+// tells which one it was. Either works only once.
+func (t *TOTPService) VerifyTOTP(userID int, code string) (bool, error) {
+	totp, err := t.storage.TotpIR.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.Enabled) {
+		return false, ErrInvalidTOTP
+	}
+	if err != nil {
+		return false, err
+	}
+	code = strings.Join(strings.Fields(code), "")
+	if len(code) == totpDigits {
+		return false, t.checkCode(totp, code)
+	}
+	ok, err := t.storage.TotpIR.UseRecoveryCode(userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrInvalidTOTP
+	}
+	return true, nil
+}
+
+// checkCode compares the code against the previous, current and next time
+// step to allow for clock drift, and records the step it matched.
+func (t *TOTPService) checkCode(totp models.TOTP, code string) error {
+	if !t.TOTPAvailable() {
+		return ErrTOTPUnavailable
+	}
+	secret, err := t.decrypt(totp.Secret)
+	if err != nil {
+		return err
+	}
+	code = strings.Join(strings.Fields(code), "")
+	now := time.Now().Unix() / totpPeriod
+	for step := now - 1; step <= now+1; step++ {
+		if !hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			continue
+		}
+		ok, err := t.storage.TotpIR.UseTOTPStep(totp.UserID, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTOTP
+		}
+		return nil
+	}
+	return ErrInvalidTOTP
+}
+
+func (t *TOTPService) SetSecondFactor(userID int, factor string) error {
+	switch factor {
+	case models.FactorEmail:
+	case models.FactorTOTP:
+		status, err := t.GetTOTPStatus(userID)
+		if err != nil {
+			return err
+		}
+		if !status.Enabled {
+			return errors.New(" enable an authenticator app first")
+		}
+	case models.FactorPasskey:
+		if !t.storage.Auth.HasWebAuthn(userID) {
+			return errors.New(" add a passkey first")
+		}
+	default:
+		return errors.New(" unknown second factor")
+	}
+	return t.storage.TotpIR.SetSecondFactor(userID, factor)
+}
+
+// ChooseSecondFactor picks the factor a login at this risk level asks for:
+// the user's preferred one when it is set up and strong enough. A RED login
+// does not accept the emailed code.
+func (t *TOTPService) ChooseSecondFactor(userID int, riskLevel string) (string, error) {
+	preferred, err := t.storage.TotpIR.GetSecondFactor(userID)
+	if err != nil {
+		return "", err
+	}
+	status, err := t.GetTOTPStatus(userID)
+	if err != nil {
+		return "", err
+	}
+	hasTOTP := status.Enabled && t.TOTPAvailable()
+	hasPasskey := t.storage.Auth.HasWebAuthn(userID)
+
+	switch {
+	case preferred == models.FactorTOTP && hasTOTP:
+		return models.FactorTOTP, nil
+	case preferred == models.FactorPasskey && hasPasskey:
+		return models.FactorPasskey, nil
+	case riskLevel != "RED":
+		return models.FactorEmail, nil
+	case !hasPasskey && hasTOTP:
+		return models.FactorTOTP, nil
+	default:
+		return models.FactorPasskey, nil
+	}
+}
+
+type pendingLogin struct {
+	Username string
+	Factor   string
+	Expires  int64
+}
+
+// SignPendingLogin returns the cookie value that proves the password step
+// passed for the user, who now owes the second factor, "email" or "totp".
+func (t *TOTPService) SignPendingLogin(username, factor string) string {
+	payload, _ := json.Marshal(pendingLogin{Username: username, Factor: factor, Expires: time.Now().Add(pendingLoginTTL).Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, t.macKey)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParsePendingLogin returns the user of a cookie signed for factor. The
+// cookie of one factor does not open the step of the other.
+func (t *TOTPService) ParsePendingLogin(value, factor string) (string, error) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", ErrPendingLogin
+	}
+	mac := hmac.New(sha256.New, t.macKey)
+	mac.Write([]byte(encoded))
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return "", ErrPendingLogin
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrPendingLogin
+	}
+	var pending pendingLogin
+	if err := json.Unmarshal(payload, &pending); err != nil || pending.Factor != factor || time.Now().Unix() > pending.Expires {
+		return "", ErrPendingLogin
+	}
+	return pending.Username, nil
+}
+
+func (t *TOTPService) encrypt(plain []byte) (string, error) {
+	nonce := make([]byte, t.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(t.gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func (t *TOTPService) decrypt(value string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < t.gcm.NonceSize() {
+		return nil, errors.New("totp: secret too short")
+	}
+	nonce, ciphertext := sealed[:t.gcm.NonceSize()], sealed[t.gcm.NonceSize():]
+	return t.gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// totpCode is the RFC 6238 code for the time step: HOTP over HMAC-SHA1 with
+// dynamic truncation.
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// newRecoveryCodes returns the codes to show and the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case and dashes, so the code can be typed back
+// however it was written down.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"forum/internal/server"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The SHA1 test vectors of RFC 6238, appendix B, cut to six digits.
+func TestTOTPCode_RFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		require.Equal(t, tc.code, totpCode(secret, tc.unix/totpPeriod), "time %d", tc.unix)
+	}
+}
+
+func TestTOTP_SecretEncryption(t *testing.T) {
+	svc := NewTOTPService(nil, server.SecurityConfig{TOTPKey: "key"})
+	require.True(t, svc.TOTPAvailable())
+
+	sealed, err := svc.encrypt([]byte("secret"))
+	require.NoError(t, err)
+	require.NotContains(t, sealed, "secret")
+	plain, err := svc.decrypt(sealed)
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(plain))
+
+	other := NewTOTPService(nil, server.SecurityConfig{TOTPKey: "other"})
+	_, err = other.decrypt(sealed)
+	require.Error(t, err)
+
+	require.False(t, NewTOTPService(nil, server.SecurityConfig{}).TOTPAvailable())
+}
+
+func TestTOTP_PendingLogin(t *testing.T) {
+	svc := NewTOTPService(nil, server.SecurityConfig{TOTPKey: "key"})
+	value := svc.SignPendingLogin("alice", "totp")
+	username, err := svc.ParsePendingLogin(value, "totp")
+	require.NoError(t, err)
+	require.Equal(t, "alice", username)
+
+	_, err = svc.ParsePendingLogin(value, "email")
+	require.ErrorIs(t, err, ErrPendingLogin, "the cookie of one factor does not pass the other")
+	_, err = svc.ParsePendingLogin(value+"x", "totp")
+	require.ErrorIs(t, err, ErrPendingLogin)
+	encoded, signature, _ := strings.Cut(value, ".")
+	_, err = svc.ParsePendingLogin(encoded[:len(encoded)-2]+"."+signature, "totp")
+	require.ErrorIs(t, err, ErrPendingLogin)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+	require.Len(t, hashes, recoveryCodeCount)
+	for i, code := range codes {
+		require.Len(t, code, 9)
+		require.Equal(t, hashes[i], hashRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	}
+}
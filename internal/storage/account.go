@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenInvalid is returned for a reset or verification token that
+	// does not exist, expired or was used already.
+	ErrTokenInvalid = errors.New(" this link is invalid or has expired")
+	ErrEmailTaken   = errors.New(" this email is already used by another account")
+)
+
+type AccountIR interface {
+	CreateUserToken(userID int, purpose, tokenHash string, expiresAt time.Time) error
+	GetUserToken(purpose, tokenHash string) (int, error)
+	ResetPassword(tokenHash, passwordHash string) (int, error)
+	VerifyEmail(tokenHash string) (int, error)
+	IsEmailVerified(userID int) (bool, error)
+
+	SetSudoUntil(userID int, until time.Time) error
+	GetSudoUntil(userID int) (time.Time, error)
+	SetPassword(userID int, passwordHash string) error
+	LockAccount(userID int) error
+
+	CreateEmailChange(userID int, newEmail, confirmHash, cancelHash string, expiresAt time.Time) error
+	GetEmailChange(userID int) (string, error)
+	GetEmailChangeByHash(confirm bool, hash string) (models.EmailChange, error)
+	ConfirmEmailChange(confirmHash string) (models.EmailChange, error)
+	CancelEmailChange(cancelHash string) (models.EmailChange, error)
+}
+
+type AccountStorage struct {
+	db *sql.DB
+}
+
+func NewAccountStorage(db *sql.DB) AccountIR {
+	return &AccountStorage{
+		db: db,
+	}
+}
+
+// CreateUserToken stores a new token. Older unused tokens of the same
+// purpose are dropped, so only the latest link works.
+func (a *AccountStorage) CreateUserToken(userID int, purpose, tokenHash string, expiresAt time.Time) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;`, userID, purpose); err != nil {
+		return err
+	}
+	query := `INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4);`
+	if _, err := tx.Exec(query, userID, purpose, tokenHash, expiresAt.Truncate(time.Second)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUserToken returns the owner of a valid token without using it up.
+func (a *AccountStorage) GetUserToken(purpose, tokenHash string) (int, error) {
+	return userToken(a.db.QueryRow, purpose, tokenHash)
+}
+
+// ResetPassword uses the token, sets the new password and signs the user
+// out everywhere.
+func (a *AccountStorage) ResetPassword(tokenHash, passwordHash string) (int, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := useToken(tx, "reset", tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	query := `UPDATE user SET password = $1, session_token = NULL, expiresAt = NULL, sudo_until = NULL, updated_at = datetime('now','localtime') WHERE id = $2;`
+	if _, err := tx.Exec(query, passwordHash, userID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_tokens WHERE user_id = $1 AND purpose = 'reset' AND used_at IS NULL;`, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (a *AccountStorage) VerifyEmail(tokenHash string) (int, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := useToken(tx, "verify", tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE user SET email_verified = 1 WHERE id = $1;`, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (a *AccountStorage) IsEmailVerified(userID int) (bool, error) {
+	var verified bool
+	err := a.db.QueryRow(`SELECT email_verified FROM user WHERE id = $1;`, userID).Scan(&verified)
+	return verified, err
+}
+
+func (a *AccountStorage) SetSudoUntil(userID int, until time.Time) error {
+	_, err := a.db.Exec(`UPDATE user SET sudo_until = $1 WHERE id = $2;`, until.Truncate(time.Second), userID)
+	return err
+}
+
+// GetSudoUntil returns the end of the sudo window, the zero time when there
+// is none.
+func (a *AccountStorage) GetSudoUntil(userID int) (time.Time, error) {
+	var until sql.NullTime
+	if err := a.db.QueryRow(`SELECT sudo_until FROM user WHERE id = $1;`, userID).Scan(&until); err != nil {
+		return time.Time{}, err
+	}
+	return until.Time, nil
+}
+
+func (a *AccountStorage) SetPassword(userID int, passwordHash string) error {
+	_, err := a.db.Exec(`UPDATE user SET password = $1, updated_at = datetime('now','localtime') WHERE id = $2;`, passwordHash, userID)
+	return err
+}
+
+// LockAccount signs the user out everywhere, drops a pending email change
+// and the "not me" links, and makes the password stop working until it is
+// reset. Accounts without
+// a password keep their provider marker.
+func (a *AccountStorage) LockAccount(userID int) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE user SET
+			password = CASE WHEN password LIKE '$2%' THEN 'locked' ELSE password END,
+			session_token = NULL,
+			expiresAt = NULL,
+			sudo_until = NULL,
+			updated_at = datetime('now','localtime')
+		WHERE id = $1;`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM email_changes WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+	// the links of other alerts would only lock the account again
+	if _, err := tx.Exec(`DELETE FROM user_tokens WHERE user_id = $1 AND purpose = 'not-me' AND used_at IS NULL;`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateEmailChange stores the address the user wants to move to. A newer
+// request replaces the pending one.
+func (a *AccountStorage) CreateEmailChange(userID int, newEmail, confirmHash, cancelHash string, expiresAt time.Time) error {
+	query := `INSERT INTO email_changes (user_id, new_email, confirm_hash, cancel_hash, expires_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT(user_id) DO UPDATE SET
+			new_email = excluded.new_email,
+			confirm_hash = excluded.confirm_hash,
+			cancel_hash = excluded.cancel_hash,
+			expires_at = excluded.expires_at,
+			created_at = datetime('now','localtime');`
+	_, err := a.db.Exec(query, userID, newEmail, confirmHash, cancelHash, expiresAt.Truncate(time.Second))
+	return err
+}
+
+// GetEmailChange returns the pending new address, or "" when there is none.
+func (a *AccountStorage) GetEmailChange(userID int) (string, error) {
+	var email string
+	var expiresAt time.Time
+	err := a.db.QueryRow(`SELECT new_email, expires_at FROM email_changes WHERE user_id = $1;`, userID).Scan(&email, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(expiresAt)) {
+		return "", nil
+	}
+	return email, err
+}
+
+// GetEmailChangeByHash returns the pending change the confirm or cancel
+// hash belongs to, leaving it pending.
+func (a *AccountStorage) GetEmailChangeByHash(confirm bool, hash string) (models.EmailChange, error) {
+	column := "cancel_hash"
+	if confirm {
+		column = "confirm_hash"
+	}
+	return findEmailChange(a.db.QueryRow, column, hash)
+}
+
+// ConfirmEmailChange moves the account to the new address, which counts as
+// verified since the link reached it.
+func (a *AccountStorage) ConfirmEmailChange(confirmHash string) (models.EmailChange, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	defer tx.Rollback()
+
+	change, err := takeEmailChange(tx, "confirm_hash", confirmHash)
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	_, err = tx.Exec(`UPDATE user SET email = $1, email_verified = 1, updated_at = datetime('now','localtime') WHERE id = $2;`, change.NewEmail, change.UserID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return models.EmailChange{}, ErrEmailTaken
+		}
+		return models.EmailChange{}, err
+	}
+	return change, tx.Commit()
+}
+
+func (a *AccountStorage) CancelEmailChange(cancelHash string) (models.EmailChange, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	defer tx.Rollback()
+
+	change, err := takeEmailChange(tx, "cancel_hash", cancelHash)
+	if err != nil {
+		return models.EmailChange{}, err
+	}
+	return change, tx.Commit()
+}
+
+// takeEmailChange deletes the pending change the hash belongs to and returns
+// it with the address the account has now.
+func takeEmailChange(tx *sql.Tx, column, hash string) (models.EmailChange, error) {
+	change, err := findEmailChange(tx.QueryRow, column, hash)
+	if err != nil {
+		return change, err
+	}
+	_, err = tx.Exec(`DELETE FROM email_changes WHERE user_id = $1;`, change.UserID)
+	return change, err
+}
+
+func findEmailChange(queryRow func(string, ...any) *sql.Row, column, hash string) (models.EmailChange, error) {
+	var change models.EmailChange
+	var expiresAt time.Time
+	query := fmt.Sprintf(`SELECT ec.user_id, u.email, ec.new_email, ec.expires_at
+		FROM email_changes ec
+		JOIN user u ON u.id = ec.user_id
+		WHERE ec.%s = $1;`, column)
+	err := queryRow(query, hash).Scan(&change.UserID, &change.OldEmail, &change.NewEmail, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return change, ErrTokenInvalid
+	}
+	if err != nil {
+		return change, err
+	}
+	if time.Now().After(expiresAt) {
+		return change, ErrTokenInvalid
+	}
+	return change, nil
+}
+
+func userToken(queryRow func(string, ...any) *sql.Row, purpose, tokenHash string) (int, error) {
+	var userID int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	query := `SELECT user_id, expires_at, used_at FROM user_tokens WHERE purpose = $1 AND token_hash = $2;`
+	err := queryRow(query, purpose, tokenHash).Scan(&userID, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return 0, ErrTokenInvalid
+	}
+	return userID, nil
+}
+
+// useToken marks a valid token used inside the transaction.
+func useToken(tx *sql.Tx, purpose, tokenHash string) (int, error) {
+	userID, err := userToken(tx.QueryRow, purpose, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`UPDATE user_tokens SET used_at = datetime('now','localtime') WHERE token_hash = $1 AND used_at IS NULL;`, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return 0, ErrTokenInvalid
+	}
+	return userID, nil
+}
@@ -19,8 +19,6 @@ type Auth interface {
 	GetUserByEmail(email string) (models.User, error)
 	DeleteToken(token string) error
 	DeleteTokenByUserID(userid int) error
-	CreateUserGoogle(email, username string) error
-	CreateUserGithub(email, username string) error
 
 	SaveEmailCode(username, codeHash string, expiresAt time.Time) (string, error)
 	CheckEmailCode(username, codeHash string) (bool, error)
@@ -30,7 +28,9 @@ type Auth interface {
 	HasWebAuthn(userID int) bool
 	GetUserIDByCredentialID(credentialID []byte) (int, error)
 	UpdateSignCount(credentialID []byte, signCount uint32) error
-	DeleteAllCredentialsByUserID(userID int64) error
+	ListCredentials(userID int) ([]models.WebAuthnCredential, error)
+	RenameCredential(userID int, id int64, name string) error
+	DeleteCredential(userID int, id int64) error
 }
 
 type AuthStorage struct {
@@ -73,7 +73,8 @@ func (a *AuthStorage) GetUserByEmail(email string) (models.User, error) {
 }
 
 func (a *AuthStorage) SaveToken(token string, expired time.Time, username string) error {
-	query := `UPDATE user SET session_token = $1, expiresAt = $2 WHERE username = $3;`
+	// a new session starts outside sudo mode
+	query := `UPDATE user SET session_token = $1, expiresAt = $2, sudo_until = NULL WHERE username = $3;`
 	if _, err := a.db.Exec(query, token, expired, username); err != nil {
 		return err
 	}
@@ -117,7 +118,7 @@ func (a *AuthStorage) GetPasswordByUsername(username string) (string, error) {
 }
 
 func (a *AuthStorage) DeleteToken(token string) error {
-	query := `UPDATE user SET session_token = NULL, expiresAt = NULL WHERE session_token = $1`
+	query := `UPDATE user SET session_token = NULL, expiresAt = NULL, sudo_until = NULL WHERE session_token = $1`
 	if _, err := a.db.Exec(query, token); err != nil {
 		return err
 	}
@@ -125,7 +126,7 @@ func (a *AuthStorage) DeleteToken(token string) error {
 }
 
 func (a *AuthStorage) DeleteTokenByUserID(userId int) error {
-	query := `UPDATE user SET session_token = NULL, expiresAt = NULL WHERE id = $1`
+	query := `UPDATE user SET session_token = NULL, expiresAt = NULL, sudo_until = NULL WHERE id = $1`
 	if _, err := a.db.Exec(query, userId); err != nil {
 		return err
 	}
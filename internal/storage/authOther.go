@@ -1,35 +1,17 @@
 package storage
 
 import (
+	"database/sql"
 	"forum/internal/models"
-	"log"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 )
 
-func (a *AuthStorage) CreateUserGoogle(email, username string) error {
-	query := `INSERT INTO user(email, username, password) VALUES ($1, $2, $3);`
-	_, err := a.db.Exec(query, email, username, "google")
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (a *AuthStorage) CreateUserGithub(email, username string) error {
-	query := `INSERT INTO user(email, username, password) VALUES ($1, $2, $3);`
-	_, err := a.db.Exec(query, email, username, "github")
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (a *AuthStorage) SaveCredentials(cred *models.WebAuthnCredential) error {
 	const query = `
 	INSERT INTO webauthn_credentials
-	(user_id, credential_id, public_key, sign_count, is_passkey )
-	VALUES (?, ?, ?, ?, ?)
+	(user_id, credential_id, public_key, sign_count, is_passkey, name, backup_eligible, backup_state)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err := a.db.Exec(
@@ -39,8 +21,9 @@ func (a *AuthStorage) SaveCredentials(cred *models.WebAuthnCredential) error {
 		cred.PublicKey,
 		cred.SignCount,
 		cred.IsPasskey,
-		// cred.BackupEligible,
-		// cred.BackupState,
+		cred.Name,
+		cred.BackupEligible,
+		cred.BackupState,
 	)
 
 	return err
@@ -48,7 +31,7 @@ func (a *AuthStorage) SaveCredentials(cred *models.WebAuthnCredential) error {
 
 func (a *AuthStorage) GetCredentials(userID int) ([]webauthn.Credential, error) {
 	const query = `
-	SELECT credential_id, public_key, sign_count
+	SELECT credential_id, public_key, sign_count, backup_eligible, backup_state
 	FROM webauthn_credentials
 	WHERE user_id = ?
 	`
@@ -63,14 +46,14 @@ func (a *AuthStorage) GetCredentials(userID int) ([]webauthn.Credential, error)
 
 	for rows.Next() {
 		var (
-			credID    []byte
-			publicKey []byte
-			signCount uint32
-			// backupEligible bool
-			// backupState    bool
+			credID         []byte
+			publicKey      []byte
+			signCount      uint32
+			backupEligible bool
+			backupState    bool
 		)
 
-		if err := rows.Scan(&credID, &publicKey, &signCount); err != nil {
+		if err := rows.Scan(&credID, &publicKey, &signCount, &backupEligible, &backupState); err != nil {
 			return nil, err
 		}
 
@@ -81,8 +64,8 @@ func (a *AuthStorage) GetCredentials(userID int) ([]webauthn.Credential, error)
 				SignCount: signCount,
 			},
 			Flags: webauthn.CredentialFlags{
-				BackupEligible: true,
-				BackupState:    true,
+				BackupEligible: backupEligible,
+				BackupState:    backupState,
 			},
 		})
 	}
@@ -101,18 +84,65 @@ func (a *AuthStorage) HasWebAuthn(userID int) bool {
 	return count > 0
 }
 
-func (r *AuthStorage) DeleteAllCredentialsByUserID(userID int64) error {
-	res, err := r.db.Exec(`
-        DELETE FROM webauthn_credentials
-        WHERE user_id = ?
-    `, userID)
+// ListCredentials returns the passkeys of a user for the settings page,
+// oldest first.
+func (a *AuthStorage) ListCredentials(userID int) ([]models.WebAuthnCredential, error) {
+	rows, err := a.db.Query(`
+		SELECT id, user_id, name, is_passkey, backup_eligible, backup_state, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = ?
+		ORDER BY created_at, id
+	`, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// необязательно, но полезно для логов
-	rows, _ := res.RowsAffected()
-	log.Printf("Deleted %d WebAuthn credentials for user %d", rows, userID)
+	var creds []models.WebAuthnCredential
+	for rows.Next() {
+		var cred models.WebAuthnCredential
+		if err := rows.Scan(&cred.ID, &cred.UserID, &cred.Name, &cred.IsPasskey, &cred.BackupEligible, &cred.BackupState, &cred.CreatedAt, &cred.LastUsedAt); err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
 
+func (a *AuthStorage) RenameCredential(userID int, id int64, name string) error {
+	res, err := a.db.Exec(`UPDATE webauthn_credentials SET name = ? WHERE id = ? AND user_id = ?`, name, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
+
+// DeleteCredential revokes one passkey. When it was the last one and the
+// user asked for a passkey as second factor, the factor goes back to email.
+func (a *AuthStorage) DeleteCredential(userID int, id int64) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.Exec(`
+		UPDATE user SET second_factor = 'email'
+		WHERE id = $1 AND second_factor = 'passkey'
+			AND NOT EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = $1)
+	`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
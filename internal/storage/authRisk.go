@@ -2,7 +2,9 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"forum/internal/models"
+	"time"
 )
 
 type AuthRiskIR interface {
@@ -11,6 +13,9 @@ type AuthRiskIR interface {
 	GetRiskAssessmentByUserID(userID int) (models.RiskAssessment, error)
 	SaveAuthLog(log models.AuthLog) error
 	GetLogsByUserID(userID int) ([]models.AuthLog, error)
+	GetSignInsByUserID(userID, limit int) ([]models.AuthLog, error)
+	CountSignIns(userID int, device string) (int, int, error)
+	RiskOverriddenSince(userID int, since time.Time) (bool, error)
 }
 
 type AuthRiskStorage struct {
@@ -37,13 +42,15 @@ func (a *AuthRiskStorage) CreateRiskAssessment(assessment models.RiskAssessment)
 				user_id,
 				risk_level,
 				reason,
+				primary_geo,
 				primary_ip,
 				primary_device,
 				primary_online_time
-			) VALUES ($1, $2, $3, $4, $5, $6)`,
+			) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
 		assessment.UserID,
 		riskLevel,
 		assessment.Reason,
+		assessment.PrimaryGeo,
 		assessment.PrimaryIP,
 		assessment.PrimaryDevice,
 		primaryOnlineTime,
@@ -51,27 +58,45 @@ func (a *AuthRiskStorage) CreateRiskAssessment(assessment models.RiskAssessment)
 	return err
 }
 
+// pinned holds while an admin pinned the level of a risk assessment.
+const pinned = `(pinned_until IS NOT NULL AND datetime(pinned_until) > datetime('now'))`
+
+// UpdateRiskAssessment saves a new assessment. A level an admin pinned is
+// kept, even when the assessment started before the pin.
 func (a *AuthRiskStorage) UpdateRiskAssessment(assessment models.RiskAssessment) error {
 	var primaryOnlineTime interface{}
 	if !assessment.PrimaryOnlineTime.IsZero() {
 		primaryOnlineTime = assessment.PrimaryOnlineTime
 	}
 
+	explanation, err := json.Marshal(assessment.Explanation)
+	if err != nil {
+		return err
+	}
+	var assessedAt interface{}
+	if !assessment.AssessedAt.IsZero() {
+		assessedAt = assessment.AssessedAt
+	}
+
 	res, err := a.db.Exec(
 		`UPDATE risk_assessments
-			SET risk_level = $1,
-			    reason = $2,
+			SET risk_level = CASE WHEN `+pinned+` THEN risk_level ELSE $1 END,
+			    reason = CASE WHEN `+pinned+` THEN reason ELSE $2 END,
 			    primary_geo = $3,
 			    primary_ip = $4,
 			    primary_device = $5,
-			    primary_online_time = $6
-			WHERE user_id = $7`,
+			    primary_online_time = $6,
+			    explanation = $7,
+			    assessed_at = $8
+			WHERE user_id = $9`,
 		assessment.RiskLevel,
 		assessment.Reason,
 		assessment.PrimaryGeo,
 		assessment.PrimaryIP,
 		assessment.PrimaryDevice,
 		primaryOnlineTime,
+		string(explanation),
+		assessedAt,
 		assessment.UserID,
 	)
 	if err != nil {
@@ -97,10 +122,13 @@ func (a *AuthRiskStorage) GetRiskAssessmentByUserID(userID int) (models.RiskAsse
 		primaryIP         sql.NullString
 		primaryDevice     sql.NullString
 		primaryOnlineTime sql.NullTime
+		explanation       sql.NullString
+		assessedAt        sql.NullTime
+		pinnedUntil       sql.NullTime
 	)
 
 	err := a.db.QueryRow(
-		`SELECT user_id, risk_level, reason, primary_geo, primary_ip, primary_device, primary_online_time
+		`SELECT user_id, risk_level, reason, primary_geo, primary_ip, primary_device, primary_online_time, explanation, assessed_at, pinned_until
 			FROM risk_assessments
 			WHERE user_id = $1 LIMIT 1`,
 		userID,
@@ -112,10 +140,20 @@ func (a *AuthRiskStorage) GetRiskAssessmentByUserID(userID int) (models.RiskAsse
 		&primaryIP,
 		&primaryDevice,
 		&primaryOnlineTime,
+		&explanation,
+		&assessedAt,
+		&pinnedUntil,
 	)
 	if err != nil {
 		return models.RiskAssessment{}, err
 	}
+	if explanation.String != "" {
+		if err := json.Unmarshal([]byte(explanation.String), &assessment.Explanation); err != nil {
+			return models.RiskAssessment{}, err
+		}
+	}
+	assessment.AssessedAt = assessedAt.Time
+	assessment.PinnedUntil = pinnedUntil.Time
 
 	assessment.PrimaryGeo = primaryGeo.String
 	assessment.PrimaryIP = primaryIP.String
@@ -134,33 +172,92 @@ func (a *AuthRiskStorage) GetRiskAssessmentByUserID(userID int) (models.RiskAsse
 	return assessment, nil
 }
 
+// RiskOverriddenSince tells whether an admin pinned, unpinned or reset the
+// level of the user at since or later. created_at is in local time.
+func (a *AuthRiskStorage) RiskOverriddenSince(userID int, since time.Time) (bool, error) {
+	var count int
+	err := a.db.QueryRow(
+		`SELECT COUNT(*)
+			FROM risk_overrides
+			WHERE user_id = $1 AND action != 'export'
+			  AND created_at >= datetime($2, 'unixepoch', 'localtime')`,
+		userID,
+		since.Unix(),
+	).Scan(&count)
+	return count > 0, err
+}
+
 func (a *AuthRiskStorage) SaveAuthLog(log models.AuthLog) error {
+	if log.Time.IsZero() {
+		log.Time = time.Now().Truncate(time.Second)
+	}
 	_, err := a.db.Exec(
-		`INSERT INTO user_events(user_id, ip, geo, device, status,reason, event_time)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		`INSERT INTO user_events(user_id, ip, geo, city, asn, device, status,reason, method, event_time)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
 		log.UserID,
 		log.IP,
 		log.Geo,
+		log.City,
+		log.ASN,
 		log.Device,
 		log.Status,
 		log.Reason,
+		log.Method,
 		log.Time,
 	)
 	return err
 }
 
+// GetLogsByUserID returns the last sign in attempts the risk engine looks
+// at. Events without a method, like settings changes, are left out.
 func (a *AuthRiskStorage) GetLogsByUserID(userID int) ([]models.AuthLog, error) {
 	rows, err := a.db.Query(
-		`SELECT id, user_id, ip, geo, device, status, reason, event_time
+		`SELECT id, user_id, ip, geo, city, asn, device, status, reason, method, event_time
 			FROM user_events
-			WHERE user_id = $1
-			ORDER BY event_time DESC
+			WHERE user_id = $1 AND method != ''
+			ORDER BY event_time DESC, id DESC
 			LIMIT 10`,
 		userID,
 	)
 	if err != nil {
 		return nil, err
 	}
+	return scanAuthLogs(rows)
+}
+
+// GetSignInsByUserID returns the latest sign in attempts, the events that
+// have a method, newest first.
+func (a *AuthRiskStorage) GetSignInsByUserID(userID, limit int) ([]models.AuthLog, error) {
+	rows, err := a.db.Query(
+		`SELECT id, user_id, ip, geo, city, asn, device, status, reason, method, event_time
+			FROM user_events
+			WHERE user_id = $1 AND method != ''
+			ORDER BY event_time DESC, id DESC
+			LIMIT $2`,
+		userID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuthLogs(rows)
+}
+
+// CountSignIns returns how many successful sign ins the user has, and how
+// many of them were from device.
+func (a *AuthRiskStorage) CountSignIns(userID int, device string) (int, int, error) {
+	var total, fromDevice int
+	err := a.db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(device = $1), 0)
+			FROM user_events
+			WHERE user_id = $2 AND status = 1 AND method != ''`,
+		device,
+		userID,
+	).Scan(&total, &fromDevice)
+	return total, fromDevice, err
+}
+
+func scanAuthLogs(rows *sql.Rows) ([]models.AuthLog, error) {
 	defer rows.Close()
 
 	logs := make([]models.AuthLog, 0)
@@ -169,9 +266,12 @@ func (a *AuthRiskStorage) GetLogsByUserID(userID int) ([]models.AuthLog, error)
 			logItem models.AuthLog
 			ip      sql.NullString
 			geo     sql.NullString
+			city    sql.NullString
+			asn     sql.NullString
 			device  sql.NullString
 			status  sql.NullBool
 			reason  sql.NullString
+			method  sql.NullString
 			tm      sql.NullTime
 		)
 
@@ -180,9 +280,12 @@ func (a *AuthRiskStorage) GetLogsByUserID(userID int) ([]models.AuthLog, error)
 			&logItem.UserID,
 			&ip,
 			&geo,
+			&city,
+			&asn,
 			&device,
 			&status,
 			&reason,
+			&method,
 			&tm,
 		); err != nil {
 			return nil, err
@@ -190,9 +293,12 @@ func (a *AuthRiskStorage) GetLogsByUserID(userID int) ([]models.AuthLog, error)
 
 		logItem.IP = ip.String
 		logItem.Geo = geo.String
+		logItem.City = city.String
+		logItem.ASN = asn.String
 		logItem.Device = device.String
 		logItem.Status = status.Bool
 		logItem.Reason = reason.String
+		logItem.Method = method.String
 		if tm.Valid {
 			logItem.Time = tm.Time
 		}
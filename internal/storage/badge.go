@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+)
+
+type BadgeIR interface {
+	AwardBadge(userID int, badge, period string) (bool, error)
+	GetUserBadges(userID int) ([]models.UserBadge, error)
+	GetBadgeStats(userID int) (models.BadgeStats, error)
+	GetTopCommenter(month string) (int, error)
+	GetUserIDs() ([]int, error)
+}
+
+type BadgeStorage struct {
+	db *sql.DB
+}
+
+func NewBadgeStorage(db *sql.DB) BadgeIR {
+	return &BadgeStorage{
+		db: db,
+	}
+}
+
+// AwardBadge reports whether the badge is new; awarding it twice for the same
+// period is a no-op.
+func (b *BadgeStorage) AwardBadge(userID int, badge, period string) (bool, error) {
+	res, err := b.db.Exec(`INSERT OR IGNORE INTO user_badges(user_id, badge, period) VALUES ($1, $2, $3);`,
+		userID, badge, period)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (b *BadgeStorage) GetUserBadges(userID int) ([]models.UserBadge, error) {
+	rows, err := b.db.Query(`SELECT user_id, badge, period, awarded_at FROM user_badges WHERE user_id = $1 ORDER BY awarded_at, id;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: user badges: %w", err)
+	}
+	defer rows.Close()
+
+	var badges []models.UserBadge
+	for rows.Next() {
+		var badge models.UserBadge
+		var code string
+		if err := rows.Scan(&badge.UserID, &code, &badge.Period, &badge.AwardedAt); err != nil {
+			return nil, fmt.Errorf("storage: user badges: %w", err)
+		}
+		var ok bool
+		if badge.Badge, ok = models.BadgeByCode(code); !ok {
+			// the rule was retired; keep the row but do not show it
+			continue
+		}
+		badges = append(badges, badge)
+	}
+	return badges, rows.Err()
+}
+
+func (b *BadgeStorage) GetBadgeStats(userID int) (models.BadgeStats, error) {
+	query := `SELECT
+			(SELECT COUNT(*) FROM post WHERE author_id = u.id AND status = 'done'),
+			(SELECT COALESCE(SUM(likes), 0) FROM post WHERE author_id = u.id) +
+				(SELECT COALESCE(SUM(likes), 0) FROM comment WHERE author_id = u.id),
+			CAST(julianday('now','localtime') - julianday(u.created_at) AS INTEGER)
+		FROM user u
+		WHERE u.id = $1;`
+	var stats models.BadgeStats
+	err := b.db.QueryRow(query, userID).Scan(&stats.Posts, &stats.LikesReceived, &stats.MemberDays)
+	return stats, err
+}
+
+// GetTopCommenter returns who wrote the most comments in month ("2006-01").
+// Ties go to whoever got there first. sql.ErrNoRows means nobody commented.
+func (b *BadgeStorage) GetTopCommenter(month string) (int, error) {
+	query := `SELECT author_id
+		FROM comment
+		WHERE strftime('%Y-%m', created_at) = $1
+		GROUP BY author_id
+		ORDER BY COUNT(*) DESC, MAX(id) ASC
+		LIMIT 1;`
+	var userID int
+	err := b.db.QueryRow(query, month).Scan(&userID)
+	return userID, err
+}
+
+func (b *BadgeStorage) GetUserIDs() ([]int, error) {
+	rows, err := b.db.Query(`SELECT id FROM user ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// parseBadges turns the GROUP_CONCAT of user_badges.badge selected with the
+// post listings into badges, once each.
+func parseBadges(codes string) []models.Badge {
+	var badges []models.Badge
+	seen := map[string]bool{}
+	for _, code := range strings.Split(codes, ",") {
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		if badge, ok := models.BadgeByCode(code); ok {
+			badges = append(badges, badge)
+		}
+	}
+	return badges
+}
@@ -1,17 +1,51 @@
 package storage
 
 import (
+	"database/sql"
 	"forum/internal/models"
 	"strings"
 )
 
-func (p *PostStorage) Category() ([]models.Category, error) {
+type CategoryIR interface {
+	Category() ([]models.Category, error)
+	AddCategory(category models.Category) error
+	UpdateCategory(category models.Category) error
+	CountCategoryPosts(name string) (int, error)
+	DeleteCategory(name, moveTo string) error
+	AddCategoryModerator(name, username string) error
+	RemoveCategoryModerator(name, username string) error
+	GetModeratedCategories(userID int) ([]string, error)
+	GetWaitPostsByModerator(userID int) ([]models.Post, error)
+}
+
+type CategoryStorage struct {
+	db *sql.DB
+}
+
+func NewCategoryStorage(db *sql.DB) CategoryIR {
+	return &CategoryStorage{
+		db: db,
+	}
+}
+
+// hasCategory matches name against one entry of the comma separated
+// p.category column, so that "Art" does not match a post in "Smart".
+func hasCategory(name string) string {
+	return `(', ' || p.category || ', ') LIKE ('%, ' || ` + name + ` || ', %')`
+}
+
+func (c *CategoryStorage) Category() ([]models.Category, error) {
 	query := `
-		SELECT hashtag
-		FROM hashtags;
+		SELECT h.hashtag, h.slug, h.description, h.icon, h.parent, h.position, h.archived,
+			(SELECT COALESCE(GROUP_CONCAT(u.username), '')
+			FROM category_moderators cm
+			JOIN user u ON u.id = cm.user_id
+			WHERE cm.hashtag = h.hashtag)
+		FROM hashtags h
+		ORDER BY h.position, h.rowid;
 	`
 
-	rows, err := p.db.Query(query)
+	rows, err := c.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -20,10 +54,14 @@ func (p *PostStorage) Category() ([]models.Category, error) {
 	var categories []models.Category
 	for rows.Next() {
 		var category models.Category
-		err := rows.Scan(&category.Name)
+		var moderators string
+		err := rows.Scan(&category.Name, &category.Slug, &category.Description, &category.Icon, &category.Parent, &category.Position, &category.Archived, &moderators)
 		if err != nil {
 			return nil, err
 		}
+		if moderators != "" {
+			category.Moderators = strings.Split(moderators, ",")
+		}
 		categories = append(categories, category)
 	}
 
@@ -35,12 +73,18 @@ func (p *PostStorage) Category() ([]models.Category, error) {
 }
 
 func (p *PostStorage) GetAllPostsByCategories(category string) ([]models.Post, error) {
+	// a parent category also lists the posts of its subcategories
 	query := `
-		SELECT p.id, p.title, p.description,p.imageURL, u.username, p.likes, p.dislikes, p.category, p.created_at
+		SELECT p.id, p.title, p.description,p.imageURL, u.username, p.likes, p.dislikes, p.category, p.created_at,
+			(SELECT COALESCE(SUM(points), 0) FROM reputation_events WHERE user_id = p.author_id),
+			(SELECT COALESCE(GROUP_CONCAT(badge), '') FROM user_badges WHERE user_id = p.author_id)
 		FROM post p
 		LEFT JOIN user u
 		ON u.id = p.author_id
-		WHERE p.status = "done" AND category LIKE '%' || $1 || '%';
+		WHERE p.status = "done" AND EXISTS (
+			SELECT 1 FROM hashtags h
+			WHERE (h.hashtag = $1 OR h.parent = $1)
+			AND ` + hasCategory("h.hashtag") + `);
 	`
 
 	rows, err := p.db.Query(query, category)
@@ -50,13 +94,14 @@ func (p *PostStorage) GetAllPostsByCategories(category string) ([]models.Post, e
 	defer rows.Close()
 
 	var posts []models.Post
-	var cats string
+	var cats, badges string
 	for rows.Next() {
 		var post models.Post
-		err := rows.Scan(&post.Id, &post.Title, &post.Description, &post.Image, &post.Author, &post.Likes, &post.Dislikes, &cats, &post.CreateAt)
+		err := rows.Scan(&post.Id, &post.Title, &post.Description, &post.Image, &post.Author, &post.Likes, &post.Dislikes, &cats, &post.CreateAt, &post.AuthorReputation, &badges)
 		if err != nil {
 			return nil, err
 		}
+		post.AuthorBadges = parseBadges(badges)
 		post.Category = strings.Split(cats, ",")
 		posts = append(posts, post)
 	}
@@ -68,9 +113,9 @@ func (p *PostStorage) GetAllPostsByCategories(category string) ([]models.Post, e
 	return posts, nil
 }
 
-func (p *PostStorage) DeleteCategory(name string) error {
-	query := `DELETE FROM hashtags WHERE hashtag = $1;`
-	_, err := p.db.Exec(query, name)
+func (c *CategoryStorage) AddCategory(category models.Category) error {
+	query := `INSERT INTO hashtags (hashtag, slug, description, icon, parent, position) VALUES ($1, $2, $3, $4, $5, $6);`
+	_, err := c.db.Exec(query, category.Name, category.Slug, category.Description, category.Icon, category.Parent, category.Position)
 	if err != nil {
 		models.ErrLog.Println(err)
 		return err
@@ -78,12 +123,166 @@ func (p *PostStorage) DeleteCategory(name string) error {
 	return nil
 }
 
-func (p *PostStorage) AddCategory(name string) error {
-	query := `INSERT INTO hashtags (hashtag) VALUES ($1);`
-	_, err := p.db.Exec(query, name)
+func (c *CategoryStorage) UpdateCategory(category models.Category) error {
+	query := `UPDATE hashtags SET description = $1, icon = $2, parent = $3, position = $4, archived = $5 WHERE hashtag = $6;`
+	res, err := c.db.Exec(query, category.Description, category.Icon, category.Parent, category.Position, category.Archived, category.Name)
 	if err != nil {
 		models.ErrLog.Println(err)
 		return err
 	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
+
+// CountCategoryPosts counts the posts in any status, drafts included, that
+// still carry the category.
+func (c *CategoryStorage) CountCategoryPosts(name string) (int, error) {
+	query := `SELECT COUNT(*) FROM post p WHERE ` + hasCategory("$1") + `;`
+	var count int
+	err := c.db.QueryRow(query, name).Scan(&count)
+	return count, err
+}
+
+// DeleteCategory removes the category and moves its posts, follows and pins
+// to moveTo in one transaction. With an empty moveTo the category is simply
+// dropped from them. Subcategories become top level ones.
+func (c *CategoryStorage) DeleteCategory(name, moveTo string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT p.id, p.category FROM post p WHERE ` + hasCategory("$1") + `;`
+	rows, err := tx.Query(query, name)
+	if err != nil {
+		return err
+	}
+	moved := map[int]string{}
+	for rows.Next() {
+		var id int
+		var cats string
+		if err := rows.Scan(&id, &cats); err != nil {
+			rows.Close()
+			return err
+		}
+		var kept []string
+		for _, cat := range strings.Split(cats, ", ") {
+			if cat == name {
+				cat = moveTo
+			}
+			if cat != "" {
+				kept = append(kept, cat)
+			}
+		}
+		moved[id] = strings.Join(uniqueStrings(kept), ", ")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, cats := range moved {
+		if _, err := tx.Exec(`UPDATE post SET category = $1 WHERE id = $2;`, cats, id); err != nil {
+			return err
+		}
+	}
+
+	if moveTo != "" {
+		if _, err := tx.Exec(`UPDATE OR IGNORE category_follows SET hashtag = $1 WHERE hashtag = $2;`, moveTo, name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE OR IGNORE post_pins SET scope = $1 WHERE scope = $2;`, moveTo, name); err != nil {
+			return err
+		}
+	}
+	statements := []string{
+		`DELETE FROM category_follows WHERE hashtag = $1;`,
+		`DELETE FROM post_pins WHERE scope = $1;`,
+		`DELETE FROM category_moderators WHERE hashtag = $1;`,
+		`UPDATE hashtags SET parent = '' WHERE parent = $1;`,
+		`DELETE FROM hashtags WHERE hashtag = $1;`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, name); err != nil {
+			models.ErrLog.Println(err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *CategoryStorage) AddCategoryModerator(name, username string) error {
+	query := `INSERT OR IGNORE INTO category_moderators (hashtag, user_id)
+		SELECT $1, id FROM user WHERE username = $2;`
+	res, err := c.db.Exec(query, name, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		var exists bool
+		if err := c.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user WHERE username = $1);`, username).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+func (c *CategoryStorage) RemoveCategoryModerator(name, username string) error {
+	query := `DELETE FROM category_moderators
+		WHERE hashtag = $1 AND user_id = (SELECT id FROM user WHERE username = $2);`
+	_, err := c.db.Exec(query, name, username)
+	return err
+}
+
+func (c *CategoryStorage) GetModeratedCategories(userID int) ([]string, error) {
+	rows, err := c.db.Query(`SELECT hashtag FROM category_moderators WHERE user_id = $1 ORDER BY hashtag;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetWaitPostsByModerator is the moderation queue of a category moderator:
+// waiting posts in at least one of their categories.
+func (c *CategoryStorage) GetWaitPostsByModerator(userID int) ([]models.Post, error) {
+	query := `
+		SELECT p.id, u.username, p.title, p.description, p.imageURL, p.likes, p.dislikes, p.category, p.created_at
+		FROM post p
+		LEFT JOIN user u ON p.author_id = u.id
+		WHERE p.status = "waiting" AND EXISTS (
+			SELECT 1 FROM category_moderators cm
+			WHERE cm.user_id = $1 AND ` + hasCategory("cm.hashtag") + `)
+		ORDER BY p.created_at ASC LIMIT 20;`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		var post models.Post
+		var categoriesStr string
+		if err := rows.Scan(&post.Id, &post.Author, &post.Title, &post.Description, &post.Image, &post.Likes, &post.Dislikes, &categoriesStr, &post.CreateAt); err != nil {
+			return nil, err
+		}
+		post.Category = strings.Split(categoriesStr, ", ")
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
@@ -79,7 +79,8 @@ func (c *CommentStorage) CreateComment(id, author_id int, comment string) (int,
 
 func (c *CommentStorage) GetCommentsByIdPost(id int) ([]models.Comment, error) {
 	comments := []models.Comment{}
-	query := `SELECT c.id, u.username, c.comment, c.likes, c.dislikes, c.created_at 
+	query := `SELECT c.id, u.username, c.comment, c.likes, c.dislikes, c.created_at,
+			(SELECT COALESCE(SUM(points), 0) FROM reputation_events WHERE user_id = c.author_id)
 		FROM comment c
 		LEFT JOIN user u
 		ON u.id = c.author_id
@@ -90,7 +91,7 @@ func (c *CommentStorage) GetCommentsByIdPost(id int) ([]models.Comment, error) {
 	}
 	for rows.Next() {
 		var comment models.Comment
-		if err := rows.Scan(&comment.Id, &comment.Creator, &comment.Text, &comment.Likes, &comment.Dislikes, &comment.Created_at); err != nil {
+		if err := rows.Scan(&comment.Id, &comment.Creator, &comment.Text, &comment.Likes, &comment.Dislikes, &comment.Created_at, &comment.CreatorReputation); err != nil {
 			log.Println(err.Error())
 			return nil, fmt.Errorf("storage: comment by id post: %w", err)
 		}
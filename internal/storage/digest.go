@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+type DigestIR interface {
+	GetDigestPreferences(userID int) (models.DigestPreferences, error)
+	SaveDigestPreferences(pref models.DigestPreferences) error
+	GetDueDigests(digest string, before time.Time) ([]models.DigestPreferences, error)
+	MarkDigestSent(userID int, sentAt time.Time) error
+	Unsubscribe(token string) (bool, error)
+	GetFollowedCategories(userID int) ([]string, error)
+	SetFollowedCategories(userID int, categories []string) error
+	GetNotificationsSince(userID int, since time.Time) ([]models.Message, error)
+	GetRepliesSince(userID int, since time.Time) ([]models.Message, error)
+	GetFollowedPostsSince(userID int, since time.Time) ([]models.Post, error)
+}
+
+type DigestStorage struct {
+	db *sql.DB
+}
+
+func NewDigestStorage(db *sql.DB) DigestIR {
+	return &DigestStorage{
+		db: db,
+	}
+}
+
+func (d *DigestStorage) GetDigestPreferences(userID int) (models.DigestPreferences, error) {
+	query := `SELECT u.id,
+			u.email,
+			u.username,
+			COALESCE(e.digest, 'off'),
+			COALESCE(e.include_notifications, 1),
+			COALESCE(e.include_replies, 1),
+			COALESCE(e.include_categories, 1),
+			e.unsubscribe_token,
+			e.last_sent_at
+		FROM user u
+		LEFT JOIN email_preferences e
+		ON e.user_id = u.id
+		WHERE u.id = $1;`
+	pref, err := scanDigestPreferences(d.db.QueryRow(query, userID))
+	if err != nil {
+		return models.DigestPreferences{}, err
+	}
+	pref.FollowedCategories, err = d.GetFollowedCategories(userID)
+	if err != nil {
+		return models.DigestPreferences{}, err
+	}
+	return pref, nil
+}
+
+func (d *DigestStorage) SaveDigestPreferences(pref models.DigestPreferences) error {
+	query := `INSERT INTO email_preferences(user_id, digest, include_notifications, include_replies, include_categories, unsubscribe_token)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT(user_id) DO UPDATE SET
+			digest = excluded.digest,
+			include_notifications = excluded.include_notifications,
+			include_replies = excluded.include_replies,
+			include_categories = excluded.include_categories,
+			unsubscribe_token = excluded.unsubscribe_token;`
+	_, err := d.db.Exec(query, pref.UserID, pref.Digest, pref.IncludeNotifications, pref.IncludeReplies,
+		pref.IncludeCategories, pref.UnsubscribeToken)
+	return err
+}
+
+func (d *DigestStorage) GetDueDigests(digest string, before time.Time) ([]models.DigestPreferences, error) {
+	query := `SELECT u.id,
+			u.email,
+			u.username,
+			e.digest,
+			e.include_notifications,
+			e.include_replies,
+			e.include_categories,
+			e.unsubscribe_token,
+			e.last_sent_at
+		FROM email_preferences e
+		JOIN user u
+		ON u.id = e.user_id
+		WHERE e.digest = $1 AND (e.last_sent_at IS NULL OR e.last_sent_at <= $2);`
+	rows, err := d.db.Query(query, digest, before)
+	if err != nil {
+		return nil, fmt.Errorf("storage: due digests: %w", err)
+	}
+	defer rows.Close()
+
+	var prefs []models.DigestPreferences
+	for rows.Next() {
+		pref, err := scanDigestPreferences(rows)
+		if err != nil {
+			return nil, fmt.Errorf("storage: due digests: %w", err)
+		}
+		prefs = append(prefs, pref)
+	}
+	return prefs, rows.Err()
+}
+
+func (d *DigestStorage) MarkDigestSent(userID int, sentAt time.Time) error {
+	_, err := d.db.Exec(`UPDATE email_preferences SET last_sent_at = $1 WHERE user_id = $2;`, sentAt, userID)
+	return err
+}
+
+func (d *DigestStorage) Unsubscribe(token string) (bool, error) {
+	res, err := d.db.Exec(`UPDATE email_preferences SET digest = 'off' WHERE unsubscribe_token = $1;`, token)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (d *DigestStorage) GetFollowedCategories(userID int) ([]string, error) {
+	rows, err := d.db.Query(`SELECT hashtag FROM category_follows WHERE user_id = $1 ORDER BY hashtag;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: followed categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return nil, fmt.Errorf("storage: followed categories: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (d *DigestStorage) SetFollowedCategories(userID int, categories []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM category_follows WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+	for _, category := range uniqueStrings(categories) {
+		if _, err = tx.Exec(`INSERT INTO category_follows(user_id, hashtag) VALUES ($1, $2);`, userID, category); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DigestStorage) GetNotificationsSince(userID int, since time.Time) ([]models.Message, error) {
+	return d.getMessagesSince(`n.message != 'cc'`, userID, since)
+}
+
+func (d *DigestStorage) GetRepliesSince(userID int, since time.Time) ([]models.Message, error) {
+	return d.getMessagesSince(`n.message = 'cc'`, userID, since)
+}
+
+func (d *DigestStorage) getMessagesSince(filter string, userID int, since time.Time) ([]models.Message, error) {
+	query := `SELECT n.id,
+			n.from_user_id,
+			n.to_user_id,
+			COALESCE(u.username, ''),
+			COALESCE(n.post_id, 0),
+			n.comment_id,
+			n.message,
+			n.created_at
+		FROM notification n
+		LEFT JOIN user u
+		ON u.id = n.from_user_id
+		WHERE n.to_user_id = $1 AND n.from_user_id != n.to_user_id AND n.created_at > $2 AND ` + filter + `
+		ORDER BY n.created_at DESC LIMIT 50;`
+	rows, err := d.db.Query(query, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("storage: digest messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var message models.Message
+		var commentID sql.NullInt64
+		if err := rows.Scan(&message.Id, &message.FromUserId, &message.ToUserId, &message.FromUserName,
+			&message.PostId, &commentID, &message.Message, &message.CreateAt); err != nil {
+			return nil, fmt.Errorf("storage: digest messages: %w", err)
+		}
+		if commentID.Valid {
+			message.CommentId = int(commentID.Int64)
+		}
+		message.Message = ConvertMessageAuthor(message.Message)
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (d *DigestStorage) GetFollowedPostsSince(userID int, since time.Time) ([]models.Post, error) {
+	query := `SELECT p.id, COALESCE(u.username, ''), p.title, p.description, p.imageURL, p.category, p.created_at
+		FROM post p
+		LEFT JOIN user u
+		ON u.id = p.author_id
+		WHERE p.status = 'done' AND p.author_id != $1 AND p.created_at > $2
+		AND EXISTS (
+			SELECT 1 FROM category_follows cf
+			WHERE cf.user_id = $1 AND (', ' || p.category || ', ') LIKE '%, ' || cf.hashtag || ', %'
+		)
+		ORDER BY p.created_at DESC LIMIT 20;`
+	rows, err := d.db.Query(query, userID, since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, fmt.Errorf("storage: followed posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		var post models.Post
+		var categoriesStr string
+		if err := rows.Scan(&post.Id, &post.Author, &post.Title, &post.Description, &post.Image, &categoriesStr, &post.CreateAt); err != nil {
+			return nil, fmt.Errorf("storage: followed posts: %w", err)
+		}
+		post.Category = strings.Split(categoriesStr, ", ")
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDigestPreferences(row rowScanner) (models.DigestPreferences, error) {
+	var (
+		pref     models.DigestPreferences
+		token    sql.NullString
+		lastSent sql.NullTime
+	)
+	if err := row.Scan(&pref.UserID, &pref.Email, &pref.Username, &pref.Digest, &pref.IncludeNotifications,
+		&pref.IncludeReplies, &pref.IncludeCategories, &token, &lastSent); err != nil {
+		return models.DigestPreferences{}, err
+	}
+	pref.UnsubscribeToken = token.String
+	if lastSent.Valid {
+		pref.LastSentAt = lastSent.Time
+	}
+	return pref, nil
+}
@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+)
+
+type DirectMessageIR interface {
+	GetUserIdByName(username string) (int, error)
+	FindDirectConversation(firstID, secondID int) (int, error)
+	CreateConversation(creatorID int, title string, memberIDs []int) (int, error)
+	IsConversationMember(conversationID, userID int) (bool, error)
+	IsConversationMessage(conversationID, messageID int) (bool, error)
+	GetConversations(userID int) ([]models.Conversation, error)
+	GetConversation(conversationID int) (models.Conversation, error)
+	GetDirectMessages(conversationID int) ([]models.DirectMessage, error)
+	CreateDirectMessage(msg models.DirectMessage) (int, error)
+	MarkConversationRead(conversationID, userID int) error
+	CountUnreadMessages(userID int) (int, error)
+	BlockUser(blockerID, blockedID int) error
+	UnblockUser(blockerID, blockedID int) error
+	GetBlockedUsers(userID int) ([]models.User, error)
+	HasBlockBetween(userID int, otherIDs []int) (bool, error)
+	CreateDMReport(report models.DMReport) error
+	GetOpenDMReports() ([]models.DMReport, error)
+	GetDMReport(id int) (models.DMReport, error)
+	ResolveDMReport(id, moderatorID int) error
+}
+
+type DirectMessageStorage struct {
+	db *sql.DB
+}
+
+func NewDirectMessageStorage(db *sql.DB) DirectMessageIR {
+	return &DirectMessageStorage{
+		db: db,
+	}
+}
+
+func (d *DirectMessageStorage) GetUserIdByName(username string) (int, error) {
+	var id int
+	err := d.db.QueryRow(`SELECT id FROM user WHERE username = $1;`, username).Scan(&id)
+	return id, err
+}
+
+// FindDirectConversation returns the one-to-one conversation between two
+// users, or 0 if they have not talked yet.
+func (d *DirectMessageStorage) FindDirectConversation(firstID, secondID int) (int, error) {
+	query := `SELECT c.id
+		FROM conversations c
+		WHERE (SELECT COUNT(*) FROM conversation_members m WHERE m.conversation_id = c.id) = 2
+		AND EXISTS (SELECT 1 FROM conversation_members m WHERE m.conversation_id = c.id AND m.user_id = $1)
+		AND EXISTS (SELECT 1 FROM conversation_members m WHERE m.conversation_id = c.id AND m.user_id = $2)
+		LIMIT 1;`
+	var id int
+	err := d.db.QueryRow(query, firstID, secondID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func (d *DirectMessageStorage) CreateConversation(creatorID int, title string, memberIDs []int) (id int, err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	res, err := tx.Exec(`INSERT INTO conversations(title, created_by) VALUES ($1, $2);`, title, creatorID)
+	if err != nil {
+		return 0, err
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, memberID := range memberIDs {
+		if _, err = tx.Exec(`INSERT OR IGNORE INTO conversation_members(conversation_id, user_id) VALUES ($1, $2);`, lastID, memberID); err != nil {
+			return 0, err
+		}
+	}
+	return int(lastID), nil
+}
+
+func (d *DirectMessageStorage) IsConversationMember(conversationID, userID int) (bool, error) {
+	var exists bool
+	err := d.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2);`,
+		conversationID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (d *DirectMessageStorage) IsConversationMessage(conversationID, messageID int) (bool, error) {
+	var exists bool
+	err := d.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM direct_messages WHERE id = $1 AND conversation_id = $2);`,
+		messageID, conversationID).Scan(&exists)
+	return exists, err
+}
+
+func (d *DirectMessageStorage) GetConversations(userID int) ([]models.Conversation, error) {
+	query := `SELECT c.id,
+			c.title,
+			c.updated_at,
+			COALESCE((SELECT dm.body FROM direct_messages dm WHERE dm.conversation_id = c.id ORDER BY dm.id DESC LIMIT 1), ''),
+			COALESCE((SELECT u.username FROM direct_messages dm JOIN user u ON u.id = dm.sender_id
+				WHERE dm.conversation_id = c.id ORDER BY dm.id DESC LIMIT 1), ''),
+			(SELECT COUNT(*) FROM direct_messages dm
+				WHERE dm.conversation_id = c.id AND dm.sender_id != $1 AND dm.id > m.last_read_id)
+		FROM conversations c
+		JOIN conversation_members m
+		ON m.conversation_id = c.id
+		WHERE m.user_id = $1
+		ORDER BY c.updated_at DESC, c.id DESC;`
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []models.Conversation
+	for rows.Next() {
+		var c models.Conversation
+		if err := rows.Scan(&c.Id, &c.Title, &c.UpdatedAt, &c.LastMessage, &c.LastSender, &c.Unread); err != nil {
+			return nil, fmt.Errorf("storage: conversations: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range conversations {
+		if conversations[i].Members, err = d.getMembers(conversations[i].Id); err != nil {
+			return nil, err
+		}
+	}
+	return conversations, nil
+}
+
+func (d *DirectMessageStorage) GetConversation(conversationID int) (models.Conversation, error) {
+	var c models.Conversation
+	err := d.db.QueryRow(`SELECT id, title, updated_at FROM conversations WHERE id = $1;`, conversationID).
+		Scan(&c.Id, &c.Title, &c.UpdatedAt)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	c.Members, err = d.getMembers(conversationID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	return c, nil
+}
+
+func (d *DirectMessageStorage) getMembers(conversationID int) ([]models.User, error) {
+	query := `SELECT u.id, u.username, u.imageURL, u.rol
+		FROM conversation_members m
+		JOIN user u
+		ON u.id = m.user_id
+		WHERE m.conversation_id = $1
+		ORDER BY u.username;`
+	rows, err := d.db.Query(query, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.Id, &u.Username, &u.ImageURL, &u.Rol); err != nil {
+			return nil, fmt.Errorf("storage: conversation members: %w", err)
+		}
+		members = append(members, u)
+	}
+	return members, rows.Err()
+}
+
+func (d *DirectMessageStorage) GetDirectMessages(conversationID int) ([]models.DirectMessage, error) {
+	query := `SELECT dm.id, dm.conversation_id, dm.sender_id, u.username, u.imageURL, dm.body, dm.image, dm.created_at
+		FROM direct_messages dm
+		JOIN user u
+		ON u.id = dm.sender_id
+		WHERE dm.conversation_id = $1
+		ORDER BY dm.id ASC;`
+	rows, err := d.db.Query(query, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: direct messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.DirectMessage
+	for rows.Next() {
+		var m models.DirectMessage
+		if err := rows.Scan(&m.Id, &m.ConversationId, &m.SenderId, &m.SenderName, &m.SenderImage, &m.Body, &m.Image, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("storage: direct messages: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (d *DirectMessageStorage) CreateDirectMessage(msg models.DirectMessage) (id int, err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	res, err := tx.Exec(`INSERT INTO direct_messages(conversation_id, sender_id, body, image) VALUES ($1, $2, $3, $4);`,
+		msg.ConversationId, msg.SenderId, msg.Body, msg.Image)
+	if err != nil {
+		return 0, err
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(`UPDATE conversations SET updated_at = datetime('now','localtime') WHERE id = $1;`, msg.ConversationId); err != nil {
+		return 0, err
+	}
+	// the sender has obviously seen everything up to their own message
+	if _, err = tx.Exec(`UPDATE conversation_members SET last_read_id = $1 WHERE conversation_id = $2 AND user_id = $3;`,
+		lastID, msg.ConversationId, msg.SenderId); err != nil {
+		return 0, err
+	}
+	return int(lastID), nil
+}
+
+func (d *DirectMessageStorage) MarkConversationRead(conversationID, userID int) error {
+	query := `UPDATE conversation_members
+		SET last_read_id = COALESCE((SELECT MAX(id) FROM direct_messages WHERE conversation_id = $1), 0)
+		WHERE conversation_id = $1 AND user_id = $2;`
+	_, err := d.db.Exec(query, conversationID, userID)
+	return err
+}
+
+func (d *DirectMessageStorage) CountUnreadMessages(userID int) (int, error) {
+	query := `SELECT COUNT(*)
+		FROM direct_messages dm
+		JOIN conversation_members m
+		ON m.conversation_id = dm.conversation_id
+		WHERE m.user_id = $1 AND dm.sender_id != $1 AND dm.id > m.last_read_id;`
+	var count int
+	err := d.db.QueryRow(query, userID).Scan(&count)
+	return count, err
+}
+
+func (d *DirectMessageStorage) BlockUser(blockerID, blockedID int) error {
+	_, err := d.db.Exec(`INSERT OR IGNORE INTO user_blocks(blocker_id, blocked_id) VALUES ($1, $2);`, blockerID, blockedID)
+	return err
+}
+
+func (d *DirectMessageStorage) UnblockUser(blockerID, blockedID int) error {
+	_, err := d.db.Exec(`DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2;`, blockerID, blockedID)
+	return err
+}
+
+func (d *DirectMessageStorage) GetBlockedUsers(userID int) ([]models.User, error) {
+	query := `SELECT u.id, u.username, u.imageURL
+		FROM user_blocks b
+		JOIN user u
+		ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY u.username;`
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: blocked users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.Id, &u.Username, &u.ImageURL); err != nil {
+			return nil, fmt.Errorf("storage: blocked users: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// HasBlockBetween reports whether userID blocked any of otherIDs or was
+// blocked by one of them.
+func (d *DirectMessageStorage) HasBlockBetween(userID int, otherIDs []int) (bool, error) {
+	if len(otherIDs) == 0 {
+		return false, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(otherIDs)), ",")
+	query := `SELECT EXISTS(SELECT 1 FROM user_blocks
+		WHERE (blocker_id = ? AND blocked_id IN (` + placeholders + `))
+		OR (blocked_id = ? AND blocker_id IN (` + placeholders + `)));`
+	args := make([]interface{}, 0, 2*len(otherIDs)+2)
+	args = append(args, userID)
+	for _, id := range otherIDs {
+		args = append(args, id)
+	}
+	args = append(args, userID)
+	for _, id := range otherIDs {
+		args = append(args, id)
+	}
+	var exists bool
+	err := d.db.QueryRow(query, args...).Scan(&exists)
+	return exists, err
+}
+
+func (d *DirectMessageStorage) CreateDMReport(report models.DMReport) error {
+	var messageID interface{}
+	if report.MessageId != 0 {
+		messageID = report.MessageId
+	}
+	_, err := d.db.Exec(`INSERT INTO dm_reports(conversation_id, message_id, reporter_id, reason) VALUES ($1, $2, $3, $4);`,
+		report.ConversationId, messageID, report.ReporterId, report.Reason)
+	return err
+}
+
+const dmReportColumns = `r.id, r.conversation_id, COALESCE(r.message_id, 0), r.reporter_id, COALESCE(u.username, ''),
+		COALESCE(r.reason, ''), r.status, COALESCE(r.handled_by, 0), r.created_at`
+
+func scanDMReport(row rowScanner) (models.DMReport, error) {
+	var r models.DMReport
+	err := row.Scan(&r.Id, &r.ConversationId, &r.MessageId, &r.ReporterId, &r.ReporterName, &r.Reason, &r.Status, &r.HandledBy, &r.CreatedAt)
+	return r, err
+}
+
+func (d *DirectMessageStorage) GetOpenDMReports() ([]models.DMReport, error) {
+	query := `SELECT ` + dmReportColumns + `
+		FROM dm_reports r
+		LEFT JOIN user u
+		ON u.id = r.reporter_id
+		WHERE r.status = 'open'
+		ORDER BY r.created_at ASC;`
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("storage: dm reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []models.DMReport
+	for rows.Next() {
+		report, err := scanDMReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("storage: dm reports: %w", err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (d *DirectMessageStorage) GetDMReport(id int) (models.DMReport, error) {
+	query := `SELECT ` + dmReportColumns + `
+		FROM dm_reports r
+		LEFT JOIN user u
+		ON u.id = r.reporter_id
+		WHERE r.id = $1;`
+	return scanDMReport(d.db.QueryRow(query, id))
+}
+
+func (d *DirectMessageStorage) ResolveDMReport(id, moderatorID int) error {
+	_, err := d.db.Exec(`UPDATE dm_reports SET status = 'resolved', handled_by = $1 WHERE id = $2;`, moderatorID, id)
+	return err
+}
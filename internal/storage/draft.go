@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+type DraftIR interface {
+	SaveDraft(post models.Post) (int, error)
+	GetDraft(id, authorID int) (models.Post, error)
+	GetDrafts(authorID int) ([]models.Post, error)
+	DeleteDraft(id, authorID int) error
+	UnschedulePost(id, authorID int) error
+	SubmitDraft(post models.Post, status string, publishAt time.Time) error
+	GetDuePosts() ([]models.Post, error)
+	PublishScheduledPost(id int, status string) error
+}
+
+type DraftStorage struct {
+	db *sql.DB
+}
+
+func NewDraftStorage(db *sql.DB) DraftIR {
+	return &DraftStorage{
+		db: db,
+	}
+}
+
+// SaveDraft creates a draft when post.Id is 0 and updates the author's draft
+// or scheduled post otherwise. An empty image keeps the one already saved.
+func (d *DraftStorage) SaveDraft(post models.Post) (int, error) {
+	categories := strings.Join(uniqueStrings(post.Category), ", ")
+	if post.Id == 0 {
+		res, err := d.db.Exec(`INSERT INTO post(title, description, imageURL, author_id, category, status) VALUES ($1, $2, $3, $4, $5, 'draft');`,
+			post.Title, post.Description, post.Image, post.UserId, categories)
+		if err != nil {
+			return 0, fmt.Errorf("storage: save draft: %w", err)
+		}
+		id, err := res.LastInsertId()
+		return int(id), err
+	}
+	query := `UPDATE post SET
+			title = $1,
+			description = $2,
+			imageURL = COALESCE(NULLIF($3, ''), imageURL),
+			category = $4
+		WHERE id = $5 AND author_id = $6 AND status IN ('draft', 'scheduled');`
+	res, err := d.db.Exec(query, post.Title, post.Description, post.Image, categories, post.Id, post.UserId)
+	if err != nil {
+		return 0, fmt.Errorf("storage: save draft: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return post.Id, nil
+}
+
+const draftColumns = `id, author_id, title, description, COALESCE(imageURL, ''), COALESCE(category, ''), status, created_at, publish_at`
+
+func scanDraft(row rowScanner) (models.Post, error) {
+	var post models.Post
+	var categories string
+	var publishAt sql.NullTime
+	if err := row.Scan(&post.Id, &post.UserId, &post.Title, &post.Description, &post.Image, &categories,
+		&post.Status, &post.CreateAt, &publishAt); err != nil {
+		return models.Post{}, err
+	}
+	if categories != "" {
+		post.Category = strings.Split(categories, ", ")
+	}
+	if publishAt.Valid {
+		post.PublishAt = publishAt.Time
+	}
+	return post, nil
+}
+
+func (d *DraftStorage) GetDraft(id, authorID int) (models.Post, error) {
+	row := d.db.QueryRow(`SELECT `+draftColumns+` FROM post WHERE id = $1 AND author_id = $2 AND status IN ('draft', 'scheduled');`, id, authorID)
+	return scanDraft(row)
+}
+
+func (d *DraftStorage) GetDrafts(authorID int) ([]models.Post, error) {
+	rows, err := d.db.Query(`SELECT `+draftColumns+` FROM post
+		WHERE author_id = $1 AND status IN ('draft', 'scheduled')
+		ORDER BY status, publish_at, created_at DESC;`, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: drafts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		post, err := scanDraft(rows)
+		if err != nil {
+			return nil, fmt.Errorf("storage: drafts: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (d *DraftStorage) DeleteDraft(id, authorID int) error {
+	res, err := d.db.Exec(`DELETE FROM post WHERE id = $1 AND author_id = $2 AND status IN ('draft', 'scheduled');`, id, authorID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SubmitDraft saves the final version of a draft and moves it on: to
+// 'scheduled' with publishAt, or to 'waiting' for moderation right away.
+func (d *DraftStorage) SubmitDraft(post models.Post, status string, publishAt time.Time) error {
+	var at sql.NullTime
+	if status == "scheduled" {
+		at = sql.NullTime{Time: publishAt.Truncate(time.Second), Valid: true}
+	}
+	query := `UPDATE post SET
+			title = $1,
+			description = $2,
+			imageURL = COALESCE(NULLIF($3, ''), imageURL),
+			category = $4,
+			status = $5,
+			publish_at = $6,
+			created_at = datetime('now','localtime')
+		WHERE id = $7 AND author_id = $8 AND status IN ('draft', 'scheduled');`
+	res, err := d.db.Exec(query, post.Title, post.Description, post.Image, strings.Join(uniqueStrings(post.Category), ", "),
+		status, at, post.Id, post.UserId)
+	if err != nil {
+		return fmt.Errorf("storage: submit draft: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetDuePosts returns the scheduled posts whose time has come. datetime()
+// normalises the stored time zone offsets before comparing.
+func (d *DraftStorage) GetDuePosts() ([]models.Post, error) {
+	rows, err := d.db.Query(`SELECT ` + draftColumns + ` FROM post
+		WHERE status = 'scheduled' AND datetime(publish_at) <= datetime('now')
+		ORDER BY publish_at;`)
+	if err != nil {
+		return nil, fmt.Errorf("storage: due posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		post, err := scanDraft(rows)
+		if err != nil {
+			return nil, fmt.Errorf("storage: due posts: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// PublishScheduledPost moves a scheduled post to status. It is a no-op when
+// the author pulled the post back into drafts in the meantime.
+func (d *DraftStorage) PublishScheduledPost(id int, status string) error {
+	_, err := d.db.Exec(`UPDATE post SET status = $1, publish_at = NULL, created_at = datetime('now','localtime')
+		WHERE id = $2 AND status = 'scheduled';`, status, id)
+	return err
+}
+
+// UnschedulePost takes a scheduled post back into the drafts.
+func (d *DraftStorage) UnschedulePost(id, authorID int) error {
+	res, err := d.db.Exec(`UPDATE post SET status = 'draft', publish_at = NULL WHERE id = $1 AND author_id = $2 AND status = 'scheduled';`, id, authorID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+type HealthIR interface {
+	PingDB(ctx context.Context) error
+	PingRedis(ctx context.Context) error
+	CheckSchema(ctx context.Context) error
+}
+
+type HealthStorage struct {
+	db *sql.DB
+}
+
+func NewHealthStorage(db *sql.DB) *HealthStorage {
+	return &HealthStorage{db: db}
+}
+
+func (h *HealthStorage) PingDB(ctx context.Context) error {
+	return h.db.PingContext(ctx)
+}
+
+func (h *HealthStorage) PingRedis(ctx context.Context) error {
+	if RDB == nil {
+		return errors.New("redis is not configured")
+	}
+	return RDB.Ping(ctx).Err()
+}
+
+// CheckSchema tells whether the migrations were applied: every table they
+// create and every column added since is there.
+func (h *HealthStorage) CheckSchema(ctx context.Context) error {
+	if len(migratedTables) == 0 {
+		return errors.New("migrations did not run")
+	}
+	for _, table := range migratedTables {
+		var count int
+		if err := h.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1;`, table).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("table %s is missing", table)
+		}
+	}
+	for _, c := range addedColumns {
+		var count int
+		if err := h.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2;`, c.table, c.column).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("column %s.%s is missing", c.table, c.column)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"forum/internal/server"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSchema(t *testing.T) {
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir("../.."))
+	defer os.Chdir(wd)
+
+	var config server.Config
+	config.DB.Driver = "sqlite3"
+	config.DB.Dsn = filepath.Join(t.TempDir(), "forum.db") + "?_foreign_keys=1"
+	db, err := InitDB(config)
+	require.NoError(t, err)
+	defer db.Close()
+
+	health := NewHealthStorage(db)
+	ctx := context.Background()
+	require.NoError(t, health.PingDB(ctx))
+	require.NoError(t, health.CheckSchema(ctx))
+
+	_, err = db.Exec(`DROP TABLE jobs;`)
+	require.NoError(t, err)
+	require.EqualError(t, health.CheckSchema(ctx), "table jobs is missing")
+}
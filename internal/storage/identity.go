@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"strings"
+)
+
+// ErrIdentityTaken is returned when the provider account is already linked to
+// another user.
+var ErrIdentityTaken = errors.New(" this account is already linked to another user")
+
+type IdentityIR interface {
+	GetUserByIdentity(provider, subject string) (models.User, error)
+	GetIdentities(userID int) ([]models.UserIdentity, error)
+	LinkIdentity(userID int, provider, subject, email string) error
+	UnlinkIdentity(userID int, provider string) error
+	CreateIdentityUser(username, email, provider, subject string, verified bool) (models.User, error)
+	GetLegacyOAuthUser(email, marker string) (models.User, error)
+	HasPassword(userID int) (bool, error)
+}
+
+type IdentityStorage struct {
+	db *sql.DB
+}
+
+func NewIdentityStorage(db *sql.DB) IdentityIR {
+	return &IdentityStorage{
+		db: db,
+	}
+}
+
+func (i *IdentityStorage) GetUserByIdentity(provider, subject string) (models.User, error) {
+	query := `
+		SELECT u.id, u.email, u.username
+		FROM user_identities ui
+		JOIN user u ON u.id = ui.user_id
+		WHERE ui.provider = $1 AND ui.subject = $2;`
+	var user models.User
+	err := i.db.QueryRow(query, provider, subject).Scan(&user.Id, &user.Email, &user.Username)
+	return user, err
+}
+
+func (i *IdentityStorage) GetIdentities(userID int) ([]models.UserIdentity, error) {
+	rows, err := i.db.Query(`SELECT provider, subject, email, created_at FROM user_identities WHERE user_id = $1 ORDER BY provider;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []models.UserIdentity
+	for rows.Next() {
+		var identity models.UserIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (i *IdentityStorage) LinkIdentity(userID int, provider, subject, email string) error {
+	var owner int
+	err := i.db.QueryRow(`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2;`, provider, subject).Scan(&owner)
+	switch {
+	case err == nil && owner != userID:
+		return ErrIdentityTaken
+	case err == nil:
+		return nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	// one account per provider: linking again replaces the old one
+	query := `INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+		ON CONFLICT(user_id, provider) DO UPDATE SET
+			subject = excluded.subject,
+			email = excluded.email,
+			created_at = datetime('now','localtime');`
+	_, err = i.db.Exec(query, userID, provider, subject, email)
+	return err
+}
+
+func (i *IdentityStorage) UnlinkIdentity(userID int, provider string) error {
+	res, err := i.db.Exec(`DELETE FROM user_identities WHERE user_id = $1 AND provider = $2;`, userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateIdentityUser registers a user that signs in only through the
+// provider. The password column keeps the provider name, like the accounts
+// the old Google and GitHub login created, so no password ever matches.
+// verified tells whether the provider vouched for the email.
+func (i *IdentityStorage) CreateIdentityUser(username, email, provider, subject string, verified bool) (models.User, error) {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO user(email, username, password, email_verified) VALUES ($1, $2, $3, $4);`, email, username, provider, verified)
+	if err != nil {
+		return models.User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return models.User{}, err
+	}
+	if _, err := tx.Exec(`INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4);`, id, provider, subject, email); err != nil {
+		return models.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+	return models.User{Id: int(id), Email: email, Username: username, EmailVerified: verified}, nil
+}
+
+// GetLegacyOAuthUser finds an account the old Google or GitHub login created
+// (password set to marker) that has no identity linked yet.
+func (i *IdentityStorage) GetLegacyOAuthUser(email, marker string) (models.User, error) {
+	query := `
+		SELECT id, email, username FROM user
+		WHERE email = $1 AND password = $2
+		AND NOT EXISTS (SELECT 1 FROM user_identities WHERE user_id = user.id);`
+	var user models.User
+	err := i.db.QueryRow(query, email, marker).Scan(&user.Id, &user.Email, &user.Username)
+	return user, err
+}
+
+// HasPassword tells whether the user can also sign in with a password.
+func (i *IdentityStorage) HasPassword(userID int) (bool, error) {
+	var password sql.NullString
+	if err := i.db.QueryRow(`SELECT password FROM user WHERE id = $1;`, userID).Scan(&password); err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(password.String, "$2"), nil
+}
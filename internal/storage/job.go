@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"time"
+)
+
+type JobIR interface {
+	CreateJob(kind, payload string, maxAttempts int, runAt time.Time) (int, error)
+	ClaimJob() (models.Job, error)
+	CompleteJob(id int) error
+	RescheduleJob(id int, lastError string, runAt time.Time) error
+	BuryJob(id int, lastError string) error
+	RequeueRunningJobs() (int64, error)
+	CountJobs() (map[string]int, error)
+	ListJobs(status string, limit int) ([]models.Job, error)
+	RetryJob(id int) error
+	DeleteDeadJob(id int) error
+}
+
+type JobStorage struct {
+	db *sql.DB
+}
+
+func NewJobStorage(db *sql.DB) JobIR {
+	return &JobStorage{
+		db: db,
+	}
+}
+
+// jobTime is how run_at is stored: UTC, whole seconds, so that datetime()
+// compares it with datetime('now').
+func jobTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func (j *JobStorage) CreateJob(kind, payload string, maxAttempts int, runAt time.Time) (int, error) {
+	res, err := j.db.Exec(`INSERT INTO jobs (kind, payload, max_attempts, run_at) VALUES ($1, $2, $3, $4);`,
+		kind, payload, maxAttempts, jobTime(runAt))
+	if err != nil {
+		return 0, fmt.Errorf("storage: create job: %w", err)
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at`
+
+func scanJob(row interface{ Scan(...any) error }) (models.Job, error) {
+	var job models.Job
+	err := row.Scan(&job.Id, &job.Kind, &job.Payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+		&job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+	return job, err
+}
+
+// ClaimJob marks the job due the longest as running and counts the attempt.
+// It returns sql.ErrNoRows when nothing is due.
+func (j *JobStorage) ClaimJob() (models.Job, error) {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return models.Job{}, err
+	}
+	defer tx.Rollback()
+
+	job, err := scanJob(tx.QueryRow(`SELECT ` + jobColumns + ` FROM jobs
+		WHERE status = 'queued' AND datetime(run_at) <= datetime('now')
+		ORDER BY run_at, id LIMIT 1;`))
+	if err != nil {
+		return models.Job{}, err
+	}
+	if _, err := tx.Exec(`UPDATE jobs SET status = 'running', attempts = attempts + 1, updated_at = datetime('now','localtime')
+		WHERE id = $1;`, job.Id); err != nil {
+		return models.Job{}, fmt.Errorf("storage: claim job: %w", err)
+	}
+	job.Status = "running"
+	job.Attempts++
+	return job, tx.Commit()
+}
+
+// CompleteJob deletes a job that succeeded.
+func (j *JobStorage) CompleteJob(id int) error {
+	_, err := j.db.Exec(`DELETE FROM jobs WHERE id = $1;`, id)
+	return err
+}
+
+// RescheduleJob queues a failed job again for runAt.
+func (j *JobStorage) RescheduleJob(id int, lastError string, runAt time.Time) error {
+	_, err := j.db.Exec(`UPDATE jobs SET status = 'queued', last_error = $1, run_at = $2, updated_at = datetime('now','localtime')
+		WHERE id = $3;`, lastError, jobTime(runAt), id)
+	return err
+}
+
+// BuryJob moves a job that used up its attempts to the dead letters.
+func (j *JobStorage) BuryJob(id int, lastError string) error {
+	_, err := j.db.Exec(`UPDATE jobs SET status = 'dead', last_error = $1, updated_at = datetime('now','localtime')
+		WHERE id = $2;`, lastError, id)
+	return err
+}
+
+// RequeueRunningJobs gives back the jobs a stopped process left running.
+func (j *JobStorage) RequeueRunningJobs() (int64, error) {
+	res, err := j.db.Exec(`UPDATE jobs SET status = 'queued', updated_at = datetime('now','localtime') WHERE status = 'running';`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (j *JobStorage) CountJobs() (map[string]int, error) {
+	rows, err := j.db.Query(`SELECT status, COUNT(*) FROM jobs GROUP BY status;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// ListJobs returns the jobs with status, the latest changed first.
+func (j *JobStorage) ListJobs(status string, limit int) ([]models.Job, error) {
+	rows, err := j.db.Query(`SELECT `+jobColumns+` FROM jobs WHERE status = $1 ORDER BY updated_at DESC, id DESC LIMIT $2;`,
+		status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("storage: list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("storage: list jobs: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RetryJob queues a dead job again with all its attempts.
+func (j *JobStorage) RetryJob(id int) error {
+	res, err := j.db.Exec(`UPDATE jobs SET status = 'queued', attempts = 0, run_at = $1, updated_at = datetime('now','localtime')
+		WHERE id = $2 AND status = 'dead';`, jobTime(time.Now()), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (j *JobStorage) DeleteDeadJob(id int) error {
+	res, err := j.db.Exec(`DELETE FROM jobs WHERE id = $1 AND status = 'dead';`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"forum/internal/models"
+	"strings"
 	"time"
 )
 
@@ -12,6 +13,7 @@ type NotificationIR interface {
 	CreateMassageComment(mes models.Message) error
 	CreateMassagePost(mes models.Message) error
 	CreateMassageUpRole(mes models.Message) error
+	CreateMassageSystem(mes models.Message) error
 	GetMessagesByAuthorId(id int) ([]models.Message, error)
 	GetMessagesByReactAuthorId(id int) ([]models.Message, error)
 }
@@ -56,14 +58,23 @@ func (ns *NotificationStorage) CreateMassageUpRole(mes models.Message) error {
 	return nil
 }
 
+// CreateMassageSystem stores a notification that comes from the forum itself
+// rather than from another user, so it has neither sender nor post.
+func (ns *NotificationStorage) CreateMassageSystem(mes models.Message) error {
+	mes.CreateAt = time.Now()
+	query := `INSERT INTO notification(to_user_id, message, created_at) VALUES ($1, $2, $3);`
+	_, err := ns.db.Exec(query, mes.ToUserId, mes.Message, mes.CreateAt)
+	return err
+}
+
 func (ns *NotificationStorage) GetMessagesByAuthorId(id int) ([]models.Message, error) {
 	query := `SELECT n.id,
-			n.from_user_id ,
+			COALESCE(n.from_user_id, 0),
 			n.to_user_id ,
-			u.username,
-			u.imageURL,
-			n.post_id ,
-			p.imageURL,
+			COALESCE(u.username, 'Elestial'),
+			COALESCE(u.imageURL, 'ava.jpg'),
+			COALESCE(n.post_id, 0),
+			COALESCE(p.imageURL, ''),
 			n.comment_id ,
 			n.message ,
 			n.activity,
@@ -194,6 +205,14 @@ func ConvertMessageAction(mes string) string {
 }
 
 func ConvertMessageAuthor(mes string) string {
+	if code, ok := strings.CutPrefix(mes, "badge:"); ok {
+		if badge, ok := models.BadgeByCode(code); ok {
+			return fmt.Sprintf("you earned the %s %s badge", badge.Icon, badge.Name)
+		}
+	}
+	if what, ok := strings.CutPrefix(mes, "security:"); ok {
+		return what + ", see your recent sign ins on the security page"
+	}
 	switch mes {
 	case "pl":
 		return "user liked your post"
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+)
+
+var ErrPollOption = errors.New(" option does not belong to this poll")
+
+type PollIR interface {
+	CreatePoll(poll models.Poll) (int, error)
+	GetPollByPost(postID, userID int) (models.Poll, error)
+	SavePollVote(pollID, userID int, optionIDs []int) error
+}
+
+type PollStorage struct {
+	db *sql.DB
+}
+
+func NewPollStorage(db *sql.DB) PollIR {
+	return &PollStorage{
+		db: db,
+	}
+}
+
+func (p *PollStorage) CreatePoll(poll models.Poll) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var closesAt sql.NullTime
+	if !poll.ClosesAt.IsZero() {
+		closesAt = sql.NullTime{Time: poll.ClosesAt, Valid: true}
+	}
+	res, err := tx.Exec(`INSERT INTO polls(post_id, question, multiple, hide_results, closes_at) VALUES ($1, $2, $3, $4, $5);`,
+		poll.PostId, poll.Question, poll.Multiple, poll.HideResults, closesAt)
+	if err != nil {
+		return 0, fmt.Errorf("storage: create poll: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for i, option := range poll.Options {
+		if _, err := tx.Exec(`INSERT INTO poll_options(poll_id, position, text) VALUES ($1, $2, $3);`, id, i, option.Text); err != nil {
+			return 0, fmt.Errorf("storage: create poll option: %w", err)
+		}
+	}
+	return int(id), tx.Commit()
+}
+
+// GetPollByPost loads the poll of a post with its vote counts; the Chosen and
+// Voted fields are filled for userID (0 for guests).
+func (p *PollStorage) GetPollByPost(postID, userID int) (models.Poll, error) {
+	var poll models.Poll
+	var closesAt sql.NullTime
+	query := `SELECT id, post_id, question, multiple, hide_results, closes_at,
+			(SELECT COUNT(DISTINCT user_id) FROM poll_votes WHERE poll_id = polls.id)
+		FROM polls WHERE post_id = $1;`
+	err := p.db.QueryRow(query, postID).Scan(&poll.Id, &poll.PostId, &poll.Question, &poll.Multiple, &poll.HideResults,
+		&closesAt, &poll.Voters)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	if closesAt.Valid {
+		poll.ClosesAt = closesAt.Time
+	}
+
+	rows, err := p.db.Query(`SELECT o.id, o.text,
+			(SELECT COUNT(*) FROM poll_votes v WHERE v.option_id = o.id),
+			EXISTS (SELECT 1 FROM poll_votes v WHERE v.option_id = o.id AND v.user_id = $1)
+		FROM poll_options o
+		WHERE o.poll_id = $2
+		ORDER BY o.position;`, userID, poll.Id)
+	if err != nil {
+		return models.Poll{}, fmt.Errorf("storage: poll options: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var option models.PollOption
+		if err := rows.Scan(&option.Id, &option.Text, &option.Votes, &option.Chosen); err != nil {
+			return models.Poll{}, fmt.Errorf("storage: poll options: %w", err)
+		}
+		poll.Voted = poll.Voted || option.Chosen
+		poll.Options = append(poll.Options, option)
+	}
+	return poll, rows.Err()
+}
+
+// SavePollVote replaces the ballot of userID. Options of other polls are
+// refused, and the single choice trigger refuses a second option.
+func (p *PollStorage) SavePollVote(pollID, userID int, optionIDs []int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2;`, pollID, userID); err != nil {
+		return err
+	}
+	for _, optionID := range optionIDs {
+		res, err := tx.Exec(`INSERT INTO poll_votes(poll_id, user_id, option_id)
+			SELECT $1, $2, id FROM poll_options WHERE id = $3 AND poll_id = $1;`, pollID, userID, optionID)
+		if err != nil {
+			return fmt.Errorf("storage: poll vote: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrPollOption
+		}
+	}
+	return tx.Commit()
+}
@@ -9,12 +9,9 @@ import (
 )
 
 type PostIR interface {
-	CreatePost(post models.Post) error
+	CreatePost(post models.Post) (int, error)
 	GetPostByID(id int) (models.Post, error)
 	GetAllPosts() ([]models.Post, error)
-	Category() ([]models.Category, error)
-	AddCategory(name string) error
-	DeleteCategory(name string) error
 	GetAllPostsByCategories(category string) ([]models.Post, error)
 	GetMyPost(id int) ([]models.Post, error)
 	GetMyLikedPost(id int) ([]models.Post, error)
@@ -90,7 +87,9 @@ func (p *PostStorage) DeletePost(id int) error {
 func (p *PostStorage) GetAllPosts() ([]models.Post, error) {
 	posts := []models.Post{}
 	query := `
-		SELECT post.id, user.username, post.title, post.description, post.imageURL, post.likes, post.dislikes, post.category, post.created_at
+		SELECT post.id, user.username, post.title, post.description, post.imageURL, post.likes, post.dislikes, post.category, post.created_at,
+			(SELECT COALESCE(SUM(points), 0) FROM reputation_events WHERE user_id = post.author_id),
+			(SELECT COALESCE(GROUP_CONCAT(badge), '') FROM user_badges WHERE user_id = post.author_id)
 		FROM post
 		LEFT JOIN user ON post.author_id = user.id
 		WHERE post.status = "done"
@@ -101,10 +100,11 @@ func (p *PostStorage) GetAllPosts() ([]models.Post, error) {
 	}
 	for row.Next() {
 		var post models.Post
-		var categoriesStr string
-		if err := row.Scan(&post.Id, &post.Author, &post.Title, &post.Description, &post.Image, &post.Likes, &post.Likes, &categoriesStr, &post.CreateAt); err != nil {
+		var categoriesStr, badges string
+		if err := row.Scan(&post.Id, &post.Author, &post.Title, &post.Description, &post.Image, &post.Likes, &post.Likes, &categoriesStr, &post.CreateAt, &post.AuthorReputation, &badges); err != nil {
 			return nil, fmt.Errorf("storage: get all posts: %w", err)
 		}
+		post.AuthorBadges = parseBadges(badges)
 		post.Category = strings.Split(categoriesStr, ", ")
 
 		posts = append(posts, post)
@@ -139,7 +139,7 @@ func (p *PostStorage) GetAllWaitPosts() ([]models.Post, error) {
 	return posts, nil
 }
 
-func (p *PostStorage) CreatePost(post models.Post) error {
+func (p *PostStorage) CreatePost(post models.Post) (int, error) {
 	query := `INSERT INTO post(title, description,imageURL, author_id, category) VALUES ($1, $2, $3, $4, $5);`
 	var categoriesStr string
 	if len(post.Category) == 1 {
@@ -149,11 +149,12 @@ func (p *PostStorage) CreatePost(post models.Post) error {
 		categoriesStr = strings.Join(post.Category, ", ")
 	}
 
-	_, err := p.db.Exec(query, post.Title, post.Description, post.Image, post.UserId, categoriesStr)
+	res, err := p.db.Exec(query, post.Title, post.Description, post.Image, post.UserId, categoriesStr)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	id, err := res.LastInsertId()
+	return int(id), err
 }
 
 func (p *PostStorage) UpdatePost(post models.Post) error {
@@ -197,18 +198,21 @@ func (p *PostStorage) GetPostByID(id int) (models.Post, error) {
 			post.likes, 
 			post.dislikes ,
 			post.category,
-			post.status
+			post.status,
+			(SELECT COALESCE(SUM(points), 0) FROM reputation_events WHERE user_id = post.author_id),
+			(SELECT COALESCE(GROUP_CONCAT(badge), '') FROM user_badges WHERE user_id = post.author_id)
 		FROM post
 		LEFT JOIN user 
 		ON post.author_id = user.id
 		WHERE post.id = $1;`
 	row := p.db.QueryRow(query, id)
 	var post models.Post
-	var categoriesStr string
+	var categoriesStr, badges string
 	if err := row.Scan(&post.Id, &post.UserId, &post.Author, &post.Title, &post.Description,
-		&post.Image, &post.CreateAt, &post.Likes, &post.Dislikes, &categoriesStr, &post.Status); err != nil {
+		&post.Image, &post.CreateAt, &post.Likes, &post.Dislikes, &categoriesStr, &post.Status, &post.AuthorReputation, &badges); err != nil {
 		return models.Post{}, err
 	}
+	post.AuthorBadges = parseBadges(badges)
 	post.Category = strings.Split(categoriesStr, ", ")
 	return post, nil
 }
@@ -232,7 +236,7 @@ func (p *PostStorage) GetMyPost(id int) ([]models.Post, error) {
 	ON
 		u.id = p.author_id
 	where 
-		u.id = $1`
+		u.id = $1 AND p.status NOT IN ('draft', 'scheduled')`
 	row, err := p.db.Query(query, id)
 	if err != nil {
 		return nil, fmt.Errorf("storage: get my all posts: %w", err)
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+type PostFlagIR interface {
+	SetPostFlag(postID int, action, scope string, actorID int, expiresAt time.Time) error
+	GetPostFlags(postID int) (models.PostFlags, error)
+	GetPostFlagAudit(postID int) ([]models.PostFlagAudit, error)
+	GetPinnedPosts(scope string) ([]models.Post, error)
+	GetAnnouncements(userID int) ([]models.Post, error)
+	DismissAnnouncement(userID, postID int) error
+}
+
+type PostFlagStorage struct {
+	db *sql.DB
+}
+
+func NewPostFlagStorage(db *sql.DB) PostFlagIR {
+	return &PostFlagStorage{
+		db: db,
+	}
+}
+
+// notExpired is the condition every read of the flag tables shares.
+const notExpired = `(expires_at IS NULL OR datetime(expires_at) > datetime('now'))`
+
+// SetPostFlag applies a moderator action and records it in the audit log in
+// the same transaction.
+func (f *PostFlagStorage) SetPostFlag(postID int, action, scope string, actorID int, expiresAt time.Time) error {
+	var expires sql.NullTime
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt.Truncate(time.Second), Valid: true}
+	}
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch action {
+	case "pin":
+		_, err = tx.Exec(`INSERT INTO post_pins(post_id, scope, pinned_by, expires_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT(post_id, scope) DO UPDATE SET
+				pinned_by = excluded.pinned_by,
+				pinned_at = datetime('now','localtime'),
+				expires_at = excluded.expires_at;`, postID, scope, actorID, expires)
+	case "unpin":
+		_, err = tx.Exec(`DELETE FROM post_pins WHERE post_id = $1 AND scope = $2;`, postID, scope)
+	case "lock":
+		_, err = tx.Exec(`INSERT INTO post_locks(post_id, locked_by, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT(post_id) DO UPDATE SET
+				locked_by = excluded.locked_by,
+				locked_at = datetime('now','localtime'),
+				expires_at = excluded.expires_at;`, postID, actorID, expires)
+	case "unlock":
+		_, err = tx.Exec(`DELETE FROM post_locks WHERE post_id = $1;`, postID)
+	case "announce":
+		_, err = tx.Exec(`INSERT INTO announcements(post_id, created_by, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT(post_id) DO UPDATE SET
+				created_by = excluded.created_by,
+				created_at = datetime('now','localtime'),
+				expires_at = excluded.expires_at;`, postID, actorID, expires)
+		if err == nil {
+			// a new announcement is shown again to those who closed the old one
+			_, err = tx.Exec(`DELETE FROM announcement_dismissals WHERE post_id = $1;`, postID)
+		}
+	case "unannounce":
+		_, err = tx.Exec(`DELETE FROM announcements WHERE post_id = $1;`, postID)
+	default:
+		return fmt.Errorf("storage: unknown post flag action %q", action)
+	}
+	if err != nil {
+		return fmt.Errorf("storage: %s post: %w", action, err)
+	}
+	_, err = tx.Exec(`INSERT INTO post_flag_audit(post_id, action, scope, actor_id, expires_at) VALUES ($1, $2, $3, $4, $5);`,
+		postID, action, scope, actorID, expires)
+	if err != nil {
+		return fmt.Errorf("storage: post flag audit: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (f *PostFlagStorage) GetPostFlags(postID int) (models.PostFlags, error) {
+	var flags models.PostFlags
+	rows, err := f.db.Query(`SELECT scope, pinned_by, expires_at FROM post_pins WHERE post_id = $1 AND `+notExpired+` ORDER BY scope;`, postID)
+	if err != nil {
+		return flags, fmt.Errorf("storage: post pins: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pin models.PostPin
+		var expires sql.NullTime
+		if err := rows.Scan(&pin.Scope, &pin.PinnedBy, &expires); err != nil {
+			return flags, fmt.Errorf("storage: post pins: %w", err)
+		}
+		pin.ExpiresAt = expires.Time
+		flags.Pins = append(flags.Pins, pin)
+	}
+	if err := rows.Err(); err != nil {
+		return flags, err
+	}
+
+	var expires sql.NullTime
+	err = f.db.QueryRow(`SELECT expires_at FROM post_locks WHERE post_id = $1 AND `+notExpired+`;`, postID).Scan(&expires)
+	if err != nil && err != sql.ErrNoRows {
+		return flags, fmt.Errorf("storage: post lock: %w", err)
+	}
+	flags.Locked, flags.LockExpires = err == nil, expires.Time
+
+	expires = sql.NullTime{}
+	err = f.db.QueryRow(`SELECT expires_at FROM announcements WHERE post_id = $1 AND `+notExpired+`;`, postID).Scan(&expires)
+	if err != nil && err != sql.ErrNoRows {
+		return flags, fmt.Errorf("storage: announcement: %w", err)
+	}
+	flags.Announced, flags.AnnounceExpires = err == nil, expires.Time
+	return flags, nil
+}
+
+func (f *PostFlagStorage) GetPostFlagAudit(postID int) ([]models.PostFlagAudit, error) {
+	query := `SELECT a.id, a.post_id, a.action, a.scope, a.actor_id, COALESCE(u.username, ''), a.expires_at, a.created_at
+		FROM post_flag_audit a
+		LEFT JOIN user u
+		ON u.id = a.actor_id
+		WHERE a.post_id = $1
+		ORDER BY a.id DESC LIMIT 50;`
+	rows, err := f.db.Query(query, postID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: post flag audit: %w", err)
+	}
+	defer rows.Close()
+
+	var audit []models.PostFlagAudit
+	for rows.Next() {
+		var entry models.PostFlagAudit
+		var expires sql.NullTime
+		if err := rows.Scan(&entry.Id, &entry.PostID, &entry.Action, &entry.Scope, &entry.ActorID, &entry.ActorName,
+			&expires, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("storage: post flag audit: %w", err)
+		}
+		entry.ExpiresAt = expires.Time
+		audit = append(audit, entry)
+	}
+	return audit, rows.Err()
+}
+
+// GetPinnedPosts returns the published posts pinned in scope, latest pin
+// first.
+func (f *PostFlagStorage) GetPinnedPosts(scope string) ([]models.Post, error) {
+	query := `SELECT p.id, p.author_id, COALESCE(u.username, ''), p.title, p.description, p.imageURL, p.likes, p.dislikes, p.category, p.created_at,
+			(SELECT COALESCE(SUM(points), 0) FROM reputation_events WHERE user_id = p.author_id),
+			(SELECT COALESCE(GROUP_CONCAT(badge), '') FROM user_badges WHERE user_id = p.author_id)
+		FROM post_pins pin
+		JOIN post p
+		ON p.id = pin.post_id
+		LEFT JOIN user u
+		ON u.id = p.author_id
+		WHERE pin.scope = $1 AND p.status = 'done' AND (pin.expires_at IS NULL OR datetime(pin.expires_at) > datetime('now'))
+		ORDER BY pin.pinned_at DESC, p.id;`
+	rows, err := f.db.Query(query, scope)
+	if err != nil {
+		return nil, fmt.Errorf("storage: pinned posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		var post models.Post
+		var categories, badges string
+		if err := rows.Scan(&post.Id, &post.UserId, &post.Author, &post.Title, &post.Description, &post.Image, &post.Likes,
+			&post.Dislikes, &categories, &post.CreateAt, &post.AuthorReputation, &badges); err != nil {
+			return nil, fmt.Errorf("storage: pinned posts: %w", err)
+		}
+		post.Category = strings.Split(categories, ", ")
+		post.AuthorBadges = parseBadges(badges)
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// GetAnnouncements returns the running announcements userID has not closed.
+// Guests (userID 0) get all of them.
+func (f *PostFlagStorage) GetAnnouncements(userID int) ([]models.Post, error) {
+	query := `SELECT p.id, p.title, p.description
+		FROM announcements a
+		JOIN post p
+		ON p.id = a.post_id
+		WHERE p.status = 'done' AND (a.expires_at IS NULL OR datetime(a.expires_at) > datetime('now'))
+			AND NOT EXISTS (SELECT 1 FROM announcement_dismissals d WHERE d.post_id = a.post_id AND d.user_id = $1)
+		ORDER BY a.created_at DESC;`
+	rows, err := f.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: announcements: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		var post models.Post
+		if err := rows.Scan(&post.Id, &post.Title, &post.Description); err != nil {
+			return nil, fmt.Errorf("storage: announcements: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (f *PostFlagStorage) DismissAnnouncement(userID, postID int) error {
+	_, err := f.db.Exec(`INSERT OR IGNORE INTO announcement_dismissals(user_id, post_id) VALUES ($1, $2);`, userID, postID)
+	return err
+}
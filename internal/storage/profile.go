@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+)
+
+type ProfileIR interface {
+	GetPrivacy(userID int) (models.Privacy, error)
+	SavePrivacy(privacy models.Privacy) error
+	GetPublishedPosts(userID int) ([]models.Post, error)
+	GetCommentsByUser(userID int) ([]models.Comment, error)
+	UpdateAvatar(userID int, image string) error
+	UpdateBanner(userID int, image string) error
+	UpdateBio(userID int, bio string) error
+}
+
+type ProfileStorage struct {
+	db *sql.DB
+}
+
+func NewProfileStorage(db *sql.DB) ProfileIR {
+	return &ProfileStorage{
+		db: db,
+	}
+}
+
+func (p *ProfileStorage) GetPrivacy(userID int) (models.Privacy, error) {
+	query := `SELECT u.id,
+			COALESCE(pr.public_profile, 1),
+			COALESCE(pr.show_email, 0),
+			COALESCE(pr.show_comments, 1),
+			COALESCE(pr.show_liked, 0)
+		FROM user u
+		LEFT JOIN user_privacy pr
+		ON pr.user_id = u.id
+		WHERE u.id = $1;`
+	var privacy models.Privacy
+	err := p.db.QueryRow(query, userID).Scan(&privacy.UserID, &privacy.PublicProfile, &privacy.ShowEmail,
+		&privacy.ShowComments, &privacy.ShowLiked)
+	return privacy, err
+}
+
+func (p *ProfileStorage) SavePrivacy(privacy models.Privacy) error {
+	query := `INSERT INTO user_privacy(user_id, public_profile, show_email, show_comments, show_liked)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT(user_id) DO UPDATE SET
+			public_profile = excluded.public_profile,
+			show_email = excluded.show_email,
+			show_comments = excluded.show_comments,
+			show_liked = excluded.show_liked;`
+	_, err := p.db.Exec(query, privacy.UserID, privacy.PublicProfile, privacy.ShowEmail, privacy.ShowComments, privacy.ShowLiked)
+	return err
+}
+
+// GetPublishedPosts is GetMyPost without posts still waiting for moderation.
+func (p *ProfileStorage) GetPublishedPosts(userID int) ([]models.Post, error) {
+	query := `SELECT p.id, u.username, p.title, p.description, p.imageURL, p.likes, p.dislikes, p.category, p.created_at
+		FROM post p
+		LEFT JOIN user u
+		ON u.id = p.author_id
+		WHERE p.author_id = $1 AND p.status = 'done'
+		ORDER BY p.created_at DESC;`
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: published posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		var post models.Post
+		var categoriesStr string
+		if err := rows.Scan(&post.Id, &post.Author, &post.Title, &post.Description, &post.Image, &post.Likes, &post.Dislikes, &categoriesStr, &post.CreateAt); err != nil {
+			return nil, fmt.Errorf("storage: published posts: %w", err)
+		}
+		post.Category = strings.Split(categoriesStr, ", ")
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (p *ProfileStorage) GetCommentsByUser(userID int) ([]models.Comment, error) {
+	query := `SELECT c.id, c.id_post, c.author_id, u.username, c.comment, c.likes, c.dislikes, c.created_at
+		FROM comment c
+		JOIN post p
+		ON p.id = c.id_post
+		LEFT JOIN user u
+		ON u.id = c.author_id
+		WHERE c.author_id = $1 AND p.status = 'done'
+		ORDER BY c.created_at DESC LIMIT 100;`
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: comments by user: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		var comment models.Comment
+		if err := rows.Scan(&comment.Id, &comment.PostId, &comment.UserId, &comment.Creator, &comment.Text, &comment.Likes,
+			&comment.Dislikes, &comment.Created_at); err != nil {
+			return nil, fmt.Errorf("storage: comments by user: %w", err)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (p *ProfileStorage) UpdateAvatar(userID int, image string) error {
+	_, err := p.db.Exec(`UPDATE user SET imageURL = $1, updated_at = datetime('now','localtime') WHERE id = $2;`, image, userID)
+	return err
+}
+
+func (p *ProfileStorage) UpdateBanner(userID int, image string) error {
+	_, err := p.db.Exec(`UPDATE user SET imageBack = $1, updated_at = datetime('now','localtime') WHERE id = $2;`, image, userID)
+	return err
+}
+
+func (p *ProfileStorage) UpdateBio(userID int, bio string) error {
+	_, err := p.db.Exec(`UPDATE user SET bio = $1, updated_at = datetime('now','localtime') WHERE id = $2;`, bio, userID)
+	return err
+}
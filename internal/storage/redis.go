@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"forum/internal/server"
 	"log"
 	"time"
@@ -11,7 +12,9 @@ import (
 
 var RDB *redis.Client
 
-func InitRedis(config server.Config) {
+// InitRedis connects RDB. When Redis does not answer the forum still starts:
+// rate limits count in memory and /readyz reports Redis until it is back.
+func InitRedis(config server.Config) error {
 	RDB = redis.NewClient(&redis.Options{
 		Addr:         config.Redis.Addr,
 		Password:     config.Redis.Password,
@@ -25,8 +28,9 @@ func InitRedis(config server.Config) {
 	defer cancel()
 
 	if err := RDB.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Redis connection failed: %v", err)
+		return fmt.Errorf("redis connection failed: %w", err)
 	}
 
 	log.Println("Redis connected")
+	return nil
 }
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"database/sql"
+	"forum/internal/models"
+)
+
+type ReputationIR interface {
+	GetReputation(userID int) (int, error)
+	GetPointsGainedToday(userID int) (int, error)
+	GetReaction(source string, sourceID, actorID int) (authorID, like int, err error)
+	SaveReputationEvent(event models.ReputationEvent) error
+	DeleteReputationEvent(actorID int, source string, sourceID int) error
+	BackfillReputation(postLike, postDislike, commentLike, commentDislike int) (int64, error)
+}
+
+type ReputationStorage struct {
+	db *sql.DB
+}
+
+func NewReputationStorage(db *sql.DB) ReputationIR {
+	return &ReputationStorage{
+		db: db,
+	}
+}
+
+func (r *ReputationStorage) GetReputation(userID int) (int, error) {
+	var reputation int
+	err := r.db.QueryRow(`SELECT COALESCE(SUM(points), 0) FROM reputation_events WHERE user_id = $1;`, userID).Scan(&reputation)
+	return reputation, err
+}
+
+func (r *ReputationStorage) GetPointsGainedToday(userID int) (int, error) {
+	query := `SELECT COALESCE(SUM(points), 0)
+		FROM reputation_events
+		WHERE user_id = $1 AND points > 0 AND created_at >= datetime('now','localtime','start of day');`
+	var points int
+	err := r.db.QueryRow(query, userID).Scan(&points)
+	return points, err
+}
+
+// GetReaction returns the author of a post or comment and the current
+// reaction of actorID on it: 1 like, 0 dislike, -1 none.
+func (r *ReputationStorage) GetReaction(source string, sourceID, actorID int) (int, int, error) {
+	var query string
+	switch source {
+	case "post":
+		query = `SELECT p.author_id,
+				COALESCE((SELECT like1 FROM likesPost WHERE postId = p.id AND userId = $1 ORDER BY id DESC LIMIT 1), -1)
+			FROM post p WHERE p.id = $2;`
+	default:
+		query = `SELECT c.author_id,
+				COALESCE((SELECT like1 FROM likesComment WHERE commentsId = c.id AND userId = $1 ORDER BY id DESC LIMIT 1), -1)
+			FROM comment c WHERE c.id = $2;`
+	}
+	var authorID, like int
+	err := r.db.QueryRow(query, actorID, sourceID).Scan(&authorID, &like)
+	return authorID, like, err
+}
+
+func (r *ReputationStorage) SaveReputationEvent(event models.ReputationEvent) error {
+	query := `INSERT INTO reputation_events(user_id, actor_id, source, source_id, points)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT(actor_id, source, source_id) DO UPDATE SET
+			user_id = excluded.user_id,
+			points = excluded.points,
+			created_at = datetime('now','localtime');`
+	_, err := r.db.Exec(query, event.UserID, event.ActorID, event.Source, event.SourceID, event.Points)
+	return err
+}
+
+func (r *ReputationStorage) DeleteReputationEvent(actorID int, source string, sourceID int) error {
+	_, err := r.db.Exec(`DELETE FROM reputation_events WHERE actor_id = $1 AND source = $2 AND source_id = $3;`,
+		actorID, source, sourceID)
+	return err
+}
+
+// BackfillReputation fills an empty ledger from the reactions that already
+// exist. Daily caps are not applied to history.
+func (r *ReputationStorage) BackfillReputation(postLike, postDislike, commentLike, commentDislike int) (int64, error) {
+	var count int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM reputation_events;`).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, nil
+	}
+	var total int64
+	queries := []struct {
+		query        string
+		like, unlike int
+	}{
+		{`INSERT OR IGNORE INTO reputation_events(user_id, actor_id, source, source_id, points)
+			SELECT p.author_id, l.userId, 'post', l.postId, CASE l.like1 WHEN 1 THEN $1 ELSE $2 END
+			FROM likesPost l
+			JOIN post p ON p.id = l.postId
+			WHERE l.like1 IN (0, 1) AND p.author_id != l.userId;`, postLike, postDislike},
+		{`INSERT OR IGNORE INTO reputation_events(user_id, actor_id, source, source_id, points)
+			SELECT c.author_id, l.userId, 'comment', l.commentsId, CASE l.like1 WHEN 1 THEN $1 ELSE $2 END
+			FROM likesComment l
+			JOIN comment c ON c.id = l.commentsId
+			WHERE l.like1 IN (0, 1) AND c.author_id != l.userId;`, commentLike, commentDislike},
+	}
+	for _, q := range queries {
+		res, err := r.db.Exec(q.query, q.like, q.unlike)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
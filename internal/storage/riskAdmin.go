@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"time"
+)
+
+type RiskAdminIR interface {
+	ListRiskUsers(level string) ([]models.RiskUser, error)
+	GetEventsByUserID(userID, limit int) ([]models.AuthLog, error)
+	OverrideRisk(userID int, action, level, note string, actorID int, expiresAt time.Time) error
+	GetRiskOverrides(userID int) ([]models.RiskOverride, error)
+}
+
+type RiskAdminStorage struct {
+	db *sql.DB
+}
+
+func NewRiskAdminStorage(db *sql.DB) RiskAdminIR {
+	return &RiskAdminStorage{
+		db: db,
+	}
+}
+
+// ListRiskUsers returns the assessed users, RED first, then the latest
+// assessed. An empty level lists every level.
+func (s *RiskAdminStorage) ListRiskUsers(level string) ([]models.RiskUser, error) {
+	rows, err := s.db.Query(`SELECT r.user_id, u.username, r.risk_level, r.reason, r.assessed_at, r.pinned_until, e.event_time
+		FROM risk_assessments r
+		JOIN user u ON u.id = r.user_id
+		LEFT JOIN user_events e ON e.id = (
+			SELECT id FROM user_events WHERE user_id = r.user_id ORDER BY event_time DESC, id DESC LIMIT 1)
+		WHERE $1 = '' OR r.risk_level = $1
+		ORDER BY CASE r.risk_level WHEN 'RED' THEN 0 WHEN 'YELLOW' THEN 1 ELSE 2 END,
+			r.assessed_at DESC, r.user_id;`, level)
+	if err != nil {
+		return nil, fmt.Errorf("storage: risk users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.RiskUser
+	for rows.Next() {
+		var user models.RiskUser
+		var reason sql.NullString
+		var assessedAt, pinnedUntil, lastEvent sql.NullTime
+		if err := rows.Scan(&user.UserID, &user.Username, &user.RiskLevel, &reason, &assessedAt, &pinnedUntil, &lastEvent); err != nil {
+			return nil, fmt.Errorf("storage: risk users: %w", err)
+		}
+		user.Reason = reason.String
+		user.AssessedAt = assessedAt.Time
+		user.PinnedUntil = pinnedUntil.Time
+		user.LastEventAt = lastEvent.Time
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetEventsByUserID returns the auth events of the user, newest first. A
+// limit below 1 returns all of them.
+func (s *RiskAdminStorage) GetEventsByUserID(userID, limit int) ([]models.AuthLog, error) {
+	if limit < 1 {
+		limit = -1
+	}
+	rows, err := s.db.Query(
+		`SELECT id, user_id, ip, geo, city, asn, device, status, reason, method, event_time
+			FROM user_events
+			WHERE user_id = $1
+			ORDER BY event_time DESC, id DESC
+			LIMIT $2`,
+		userID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuthLogs(rows)
+}
+
+// OverrideRisk applies an admin action to the risk level of the user and
+// records it in the audit log in the same transaction. An export changes
+// nothing and is only recorded.
+func (s *RiskAdminStorage) OverrideRisk(userID int, action, level, note string, actorID int, expiresAt time.Time) error {
+	var expires sql.NullTime
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt.Truncate(time.Second), Valid: true}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var res sql.Result
+	switch action {
+	case "pin":
+		res, err = tx.Exec(`UPDATE risk_assessments SET risk_level = $1, reason = $2, pinned_until = $3 WHERE user_id = $4;`,
+			level, overrideReason("pinned by an admin", note), expires, userID)
+	case "unpin":
+		res, err = tx.Exec(`UPDATE risk_assessments SET pinned_until = NULL WHERE user_id = $1;`, userID)
+	case "reset":
+		res, err = tx.Exec(`UPDATE risk_assessments SET risk_level = 'GREEN', reason = $1, pinned_until = NULL WHERE user_id = $2;`,
+			overrideReason("reset by an admin", note), userID)
+	case "export":
+	default:
+		return fmt.Errorf("storage: unknown risk override %q", action)
+	}
+	if err != nil {
+		return fmt.Errorf("storage: %s risk: %w", action, err)
+	}
+	if res != nil {
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return sql.ErrNoRows
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO risk_overrides(user_id, action, level, note, actor_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6);`,
+		userID, action, level, note, actorID, expires)
+	if err != nil {
+		return fmt.Errorf("storage: risk override audit: %w", err)
+	}
+	return tx.Commit()
+}
+
+func overrideReason(what, note string) string {
+	if note == "" {
+		return what
+	}
+	return what + ": " + note
+}
+
+// GetRiskOverrides returns the audit log of the user, newest first.
+func (s *RiskAdminStorage) GetRiskOverrides(userID int) ([]models.RiskOverride, error) {
+	rows, err := s.db.Query(`SELECT o.id, o.user_id, o.action, o.level, o.note, o.actor_id, COALESCE(u.username, ''), o.expires_at, o.created_at
+		FROM risk_overrides o
+		LEFT JOIN user u ON u.id = o.actor_id
+		WHERE o.user_id = $1
+		ORDER BY o.created_at DESC, o.id DESC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: risk overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []models.RiskOverride
+	for rows.Next() {
+		var override models.RiskOverride
+		var expires sql.NullTime
+		if err := rows.Scan(&override.Id, &override.UserID, &override.Action, &override.Level, &override.Note,
+			&override.ActorID, &override.ActorName, &expires, &override.CreatedAt); err != nil {
+			return nil, fmt.Errorf("storage: risk overrides: %w", err)
+		}
+		override.ExpiresAt = expires.Time
+		overrides = append(overrides, override)
+	}
+	return overrides, rows.Err()
+}
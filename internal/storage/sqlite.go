@@ -2,42 +2,175 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 	"forum/internal/models"
 	"forum/internal/server"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func InitDB(config server.Config) *sql.DB {
+// migrations are applied in order on every start; each one only creates
+// what does not exist yet.
+var migrations = []string{"userTable.sql",
+	"postTable.sql",
+	"categoriesTable.sql",
+	"commentTable.sql",
+	"reactionCommentTable.sql",
+	"reactionPostTable.sql",
+	"notificationTable.sql",
+	"communication.sql",
+	"action.sql",
+	"userWebAuth.sql",
+	"userAuth.sql",
+	"emailDigest.sql",
+	"directMessage.sql",
+	"userPrivacy.sql",
+	"reputation.sql",
+	"badges.sql",
+	"polls.sql",
+	"postFlags.sql",
+	"categoryModerators.sql",
+	"userIdentities.sql",
+	"userTotp.sql",
+	"userTokens.sql",
+	"emailChanges.sql",
+	"riskOverrides.sql",
+	"jobs.sql"}
+
+var createTable = regexp.MustCompile(`(?i)CREATE TABLE IF NOT EXISTS\s+(\w+)`)
+
+// migratedTables are the tables the migrations create, for CheckSchema.
+var migratedTables []string
+
+func InitDB(config server.Config) (*sql.DB, error) {
 	db, err := sql.Open(config.DB.Driver, config.DB.Dsn)
 	if err != nil {
-		models.ErrLog.Fatal(err)
+		return nil, err
 	}
 	if err = db.Ping(); err != nil {
-		models.ErrLog.Fatal(err)
-	}
-	migrations := []string{"userTable.sql",
-		"postTable.sql",
-		"categoriesTable.sql",
-		"commentTable.sql",
-		"reactionCommentTable.sql",
-		"reactionPostTable.sql",
-		"notificationTable.sql",
-		"communication.sql",
-		"action.sql",
-		"userWebAuth.sql",
-		"userAuth.sql"}
+		db.Close()
+		return nil, err
+	}
+	var tables []string
 	for _, migrationFile := range migrations {
 		content, err := ioutil.ReadFile(filepath.Join("migrations", migrationFile))
 		if err != nil {
-			models.ErrLog.Fatal(err)
+			db.Close()
+			return nil, err
 		}
 		if _, err = db.Exec(string(content)); err != nil {
-			models.ErrLog.Fatal(err)
+			db.Close()
+			return nil, fmt.Errorf("migration %s: %w", migrationFile, err)
 		}
+		for _, m := range createTable.FindAllStringSubmatch(string(content), -1) {
+			tables = append(tables, m[1])
+		}
+	}
+	if err := ensureColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := ensureTokenPurposes(db); err != nil {
+		db.Close()
+		return nil, err
 	}
+	migratedTables = tables
 	models.InfoLog.Println("Connection to the database was successful")
-	return db
+	return db, nil
+}
+
+// addedColumns are columns added to tables that already existed. SQLite has
+// no ADD COLUMN IF NOT EXISTS, so ensureColumns checks table_info first.
+// backfill runs once, right after the column was added.
+var addedColumns = []struct {
+	table, column, definition, backfill string
+}{
+	{"post", "publish_at", "DATETIME", ""},
+	{"hashtags", "slug", "TEXT DEFAULT ''", `UPDATE hashtags SET slug = lower(replace(trim(hashtag), ' ', '-'));`},
+	{"hashtags", "description", "TEXT DEFAULT ''", ""},
+	{"hashtags", "icon", "TEXT DEFAULT ''", ""},
+	{"hashtags", "parent", "TEXT DEFAULT ''", ""},
+	{"hashtags", "position", "INTEGER DEFAULT 0", ""},
+	{"hashtags", "archived", "BOOLEAN DEFAULT 0", ""},
+	{"user", "second_factor", "TEXT DEFAULT 'email'", ""},
+	// accounts from before email verification count as verified
+	{"user", "email_verified", "BOOLEAN DEFAULT 0", `UPDATE user SET email_verified = 1;`},
+	{"user", "sudo_until", "DATETIME", ""},
+	// credentials saved before had their backup flags dropped; is_passkey
+	// held the backup eligible flag
+	{"webauthn_credentials", "name", "TEXT NOT NULL DEFAULT ''", `UPDATE webauthn_credentials SET name = 'Passkey', backup_eligible = is_passkey, backup_state = is_passkey;`},
+	{"risk_assessments", "explanation", "TEXT DEFAULT ''", ""},
+	{"risk_assessments", "assessed_at", "DATETIME", ""},
+	// primary_geo was never looked up, only defaulted to one country
+	{"user_events", "city", "TEXT DEFAULT ''", `UPDATE risk_assessments SET primary_geo = '' WHERE primary_geo = 'Kazakhstan';`},
+	{"user_events", "asn", "TEXT DEFAULT ''", ""},
+	{"risk_assessments", "pinned_until", "DATETIME", ""},
+	{"user_events", "method", "TEXT DEFAULT ''", `UPDATE user_events SET method = CASE
+		WHEN reason IN ('success login by 1 step auth', 'fail auth password does not match') THEN 'password'
+		WHEN reason IN ('success login by 2 steps auth', 'fail auth by Invalid code') THEN 'email code'
+		WHEN reason IN ('success login by authenticator app', 'fail auth by Invalid authenticator code') THEN 'authenticator app'
+		WHEN reason = 'success login by recovery code' THEN 'recovery code'
+		WHEN reason = 'success login by webauthn' THEN 'passkey'
+		WHEN reason LIKE 'success login by %' THEN substr(reason, 18)
+		ELSE '' END;`},
+}
+
+func ensureColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2;`, c.table, c.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.column, err)
+		}
+		if c.backfill == "" {
+			continue
+		}
+		if _, err := db.Exec(c.backfill); err != nil {
+			return fmt.Errorf("backfill column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
+// ensureTokenPurposes rebuilds user_tokens when it was created before the
+// "not-me" purpose was allowed; SQLite cannot change a CHECK in place.
+func ensureTokenPurposes(db *sql.DB) error {
+	var schema string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'user_tokens';`).Scan(&schema); err != nil {
+		return err
+	}
+	if strings.Contains(schema, "'not-me'") {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join("migrations", "userTokens.sql"))
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		`DROP INDEX IF EXISTS idx_user_tokens_user;`,
+		`ALTER TABLE user_tokens RENAME TO user_tokens_old;`,
+		string(content),
+		`INSERT INTO user_tokens SELECT * FROM user_tokens_old;`,
+		`DROP TABLE user_tokens_old;`,
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("rebuild user_tokens: %w", err)
+		}
+	}
+	return tx.Commit()
 }
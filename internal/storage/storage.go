@@ -11,6 +11,21 @@ type Storage struct {
 	ReactionIR
 	NotificationIR
 	CommunicationIR
+	DigestIR
+	DirectMessageIR
+	ProfileIR
+	ReputationIR
+	BadgeIR
+	PollIR
+	DraftIR
+	PostFlagIR
+	CategoryIR
+	IdentityIR
+	TotpIR
+	AccountIR
+	RiskAdminIR
+	JobIR
+	HealthIR
 }
 
 func NewStorage(db *sql.DB) *Storage {
@@ -23,5 +38,20 @@ func NewStorage(db *sql.DB) *Storage {
 		ReactionIR:      NewEmotionSQL(db),
 		NotificationIR:  NewNotificationStorage(db),
 		CommunicationIR: NewCommunicationStore(db),
+		DigestIR:        NewDigestStorage(db),
+		DirectMessageIR: NewDirectMessageStorage(db),
+		ProfileIR:       NewProfileStorage(db),
+		ReputationIR:    NewReputationStorage(db),
+		BadgeIR:         NewBadgeStorage(db),
+		PollIR:          NewPollStorage(db),
+		DraftIR:         NewDraftStorage(db),
+		PostFlagIR:      NewPostFlagStorage(db),
+		CategoryIR:      NewCategoryStorage(db),
+		IdentityIR:      NewIdentityStorage(db),
+		TotpIR:          NewTotpStorage(db),
+		AccountIR:       NewAccountStorage(db),
+		RiskAdminIR:     NewRiskAdminStorage(db),
+		JobIR:           NewJobStorage(db),
+		HealthIR:        NewHealthStorage(db),
 	}
 }
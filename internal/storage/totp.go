@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"database/sql"
+	"forum/internal/models"
+)
+
+type TotpIR interface {
+	GetTOTP(userID int) (models.TOTP, error)
+	SaveTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, codeHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	GetSecondFactor(userID int) (string, error)
+	SetSecondFactor(userID int, factor string) error
+}
+
+type TotpStorage struct {
+	db *sql.DB
+}
+
+func NewTotpStorage(db *sql.DB) TotpIR {
+	return &TotpStorage{
+		db: db,
+	}
+}
+
+func (t *TotpStorage) GetTOTP(userID int) (models.TOTP, error) {
+	query := `SELECT user_id, secret, enabled, last_step, created_at FROM user_totp WHERE user_id = $1;`
+	var totp models.TOTP
+	err := t.db.QueryRow(query, userID).Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastStep, &totp.CreatedAt)
+	return totp, err
+}
+
+// SaveTOTPSecret starts a new enrollment. An enabled secret is kept until the
+// new one is confirmed, so only a pending one is replaced.
+func (t *TotpStorage) SaveTOTPSecret(userID int, secret string) error {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret,
+			last_step = 0,
+			created_at = datetime('now','localtime')
+		WHERE user_totp.enabled = 0;`
+	_, err := t.db.Exec(query, userID, secret)
+	return err
+}
+
+// EnableTOTP turns the pending secret on together with its recovery codes.
+func (t *TotpStorage) EnableTOTP(userID int, codeHashes []string) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE user_totp SET enabled = 1 WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the secret and the recovery codes. The preferred
+// factor falls back to email.
+func (t *TotpStorage) DisableTOTP(userID int) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE user SET second_factor = $1 WHERE id = $2 AND second_factor = $3;`, models.FactorEmail, userID, models.FactorTOTP); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code. It reports false
+// when that step or a later one was used already, so a code cannot be
+// replayed.
+func (t *TotpStorage) UseTOTPStep(userID int, step int64) (bool, error) {
+	res, err := t.db.Exec(`UPDATE user_totp SET last_step = $1 WHERE user_id = $2 AND last_step < $1;`, step, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (t *TotpStorage) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2);`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the code used and reports whether it was valid.
+func (t *TotpStorage) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := `UPDATE totp_recovery_codes SET used_at = datetime('now','localtime')
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`
+	res, err := t.db.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (t *TotpStorage) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := t.db.QueryRow(`SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL;`, userID).Scan(&count)
+	return count, err
+}
+
+func (t *TotpStorage) GetSecondFactor(userID int) (string, error) {
+	var factor sql.NullString
+	if err := t.db.QueryRow(`SELECT second_factor FROM user WHERE id = $1;`, userID).Scan(&factor); err != nil {
+		return "", err
+	}
+	if factor.String == "" {
+		return models.FactorEmail, nil
+	}
+	return factor.String, nil
+}
+
+func (t *TotpStorage) SetSecondFactor(userID int, factor string) error {
+	_, err := t.db.Exec(`UPDATE user SET second_factor = $1 WHERE id = $2;`, factor, userID)
+	return err
+}
@@ -38,12 +38,13 @@ func (u *UserStorage) GetUserByToken(token string) (models.User, error) {
 			imageURL,
 			rol,
 			bio,
-			expiresAt
+			expiresAt,
+			email_verified
 		FROM user 
 		WHERE session_token = $1;`
 	row := u.db.QueryRow(query, token)
 	var user models.User
-	if err := row.Scan(&user.Id, &user.Email, &user.Username, &user.ImageBack, &user.ImageURL, &user.Rol, &user.Bio, &user.ExpiresAt); err != nil {
+	if err := row.Scan(&user.Id, &user.Email, &user.Username, &user.ImageBack, &user.ImageURL, &user.Rol, &user.Bio, &user.ExpiresAt, &user.EmailVerified); err != nil {
 		return models.User{}, err
 	}
 	return user, nil
@@ -58,12 +59,13 @@ func (u *UserStorage) GetUserById(id int) (models.User, error) {
 			imageURL,
 			rol,
 			bio,
-			expiresAt
+			expiresAt,
+			created_at
 		FROM user 
 		WHERE id = $1;`
 	row := u.db.QueryRow(query, id)
 	var user models.User
-	if err := row.Scan(&user.Id, &user.Email, &user.Username, &user.ImageBack, &user.ImageURL, &user.Rol, &user.Bio, &user.ExpiresAt); err != nil {
+	if err := row.Scan(&user.Id, &user.Email, &user.Username, &user.ImageBack, &user.ImageURL, &user.Rol, &user.Bio, &user.ExpiresAt, &user.Created_at); err != nil {
 		return models.User{}, err
 	}
 	return user, nil
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_badges (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    badge TEXT NOT NULL,
+    -- month the badge was won for ('2006-01'), empty for one-off badges
+    period TEXT NOT NULL DEFAULT '',
+    awarded_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_badges_award
+ON user_badges(user_id, badge, period);
@@ -2,3 +2,14 @@ CREATE TABLE IF NOT EXISTS hashtags (
     hashtag TEXT
 );
 
+-- the default categories are only seeded into an empty table, so categories
+-- the king removed or edited stay that way after a restart
+INSERT INTO hashtags (hashtag)
+SELECT column1 FROM (VALUES
+    ('Art'), ('Animal'), ('Anime'),
+    ('Book'), ('Cars'), ('Education'),
+    ('Food'), ('Game'), ('Legend'),
+    ('Marvel'), ('Medicine'), ('Movie'),
+    ('Psychology'), ('Nature'), ('News'),
+    ('Technology'), ('Sport'), ('Other'))
+WHERE NOT EXISTS (SELECT 1 FROM hashtags);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS category_moderators (
+    hashtag TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    PRIMARY KEY (hashtag, user_id),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_category_moderators_user
+ON category_moderators(user_id);
@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS conversations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title TEXT DEFAULT '',
+    created_by INTEGER,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    updated_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (created_by) REFERENCES user(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_members (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    last_read_id INTEGER DEFAULT 0,
+    joined_at DATETIME DEFAULT (datetime('now','localtime')),
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS direct_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    body TEXT DEFAULT '',
+    image TEXT DEFAULT '',
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_direct_messages_conversation
+ON direct_messages(conversation_id, created_at);
+
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    PRIMARY KEY (blocker_id, blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES user(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS dm_reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL,
+    message_id INTEGER NULL,
+    reporter_id INTEGER NOT NULL,
+    reason TEXT,
+    status TEXT DEFAULT 'open' CHECK (status IN ('open','resolved')),
+    handled_by INTEGER NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES direct_messages(id) ON DELETE SET NULL,
+    FOREIGN KEY (reporter_id) REFERENCES user(id) ON DELETE CASCADE,
+    FOREIGN KEY (handled_by) REFERENCES user(id) ON DELETE SET NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    user_id INTEGER PRIMARY KEY,
+    new_email TEXT NOT NULL,
+    confirm_hash TEXT NOT NULL UNIQUE,
+    cancel_hash TEXT NOT NULL UNIQUE,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
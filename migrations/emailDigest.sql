@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS email_preferences (
+    user_id INTEGER PRIMARY KEY,
+    digest TEXT DEFAULT 'off' CHECK (digest IN ('off','daily','weekly')),
+    include_notifications BOOLEAN DEFAULT 1,
+    include_replies BOOLEAN DEFAULT 1,
+    include_categories BOOLEAN DEFAULT 1,
+    unsubscribe_token TEXT UNIQUE,
+    last_sent_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS category_follows (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    hashtag TEXT NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_category_follows_user_hashtag
+ON category_follows(user_id, hashtag);
@@ -0,0 +1,17 @@
+-- background work: risk evaluation, mail and image processing. Finished jobs
+-- are deleted; one that failed max_attempts times stays as 'dead' until an
+-- admin retries or discards it.
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    payload TEXT NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued','running','dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    last_error TEXT NOT NULL DEFAULT '',
+    run_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    updated_at DATETIME DEFAULT (datetime('now','localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(status, run_at);
@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS polls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL UNIQUE,
+    question TEXT NOT NULL,
+    multiple INTEGER NOT NULL DEFAULT 0,
+    hide_results INTEGER NOT NULL DEFAULT 0,
+    closes_at DATETIME,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    poll_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id INTEGER NOT NULL,
+    option_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    PRIMARY KEY (poll_id, user_id, option_id),
+    FOREIGN KEY (option_id) REFERENCES poll_options(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_poll_votes_option
+ON poll_votes(option_id);
+
+-- a single choice poll takes one option per user, whatever the caller does
+CREATE TRIGGER IF NOT EXISTS poll_votes_single_choice
+BEFORE INSERT ON poll_votes
+WHEN (SELECT multiple FROM polls WHERE id = NEW.poll_id) = 0
+    AND EXISTS (SELECT 1 FROM poll_votes WHERE poll_id = NEW.poll_id AND user_id = NEW.user_id)
+BEGIN
+    SELECT RAISE(ABORT, 'single choice poll already voted');
+END;
@@ -0,0 +1,60 @@
+-- scope is '' for the front page or the category the post is pinned in
+CREATE TABLE IF NOT EXISTS post_pins (
+    post_id INTEGER NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    pinned_by INTEGER NOT NULL,
+    pinned_at DATETIME DEFAULT (datetime('now','localtime')),
+    expires_at DATETIME,
+    PRIMARY KEY (post_id, scope),
+    FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS post_locks (
+    post_id INTEGER PRIMARY KEY,
+    locked_by INTEGER NOT NULL,
+    locked_at DATETIME DEFAULT (datetime('now','localtime')),
+    expires_at DATETIME,
+    FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS announcements (
+    post_id INTEGER PRIMARY KEY,
+    created_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    expires_at DATETIME,
+    FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS announcement_dismissals (
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    dismissed_at DATETIME DEFAULT (datetime('now','localtime')),
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS post_flag_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('pin','unpin','lock','unlock','announce','unannounce')),
+    scope TEXT NOT NULL DEFAULT '',
+    actor_id INTEGER NOT NULL,
+    expires_at DATETIME,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (post_id) REFERENCES post(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_flag_audit_post
+ON post_flag_audit(post_id, created_at);
+
+-- the seeded welcome and rules posts used to rely on their date to stay on
+-- top; pin them once, unless a moderator has already dealt with them
+INSERT OR IGNORE INTO post_pins(post_id, scope, pinned_by)
+SELECT p.id, '', 1 FROM post p
+WHERE p.category IN ('king message', 'king message 2', 'king message 3')
+    AND NOT EXISTS (SELECT 1 FROM post_flag_audit a WHERE a.post_id = p.id);
+
+INSERT INTO post_flag_audit(post_id, action, scope, actor_id)
+SELECT p.post_id, 'pin', '', 1 FROM post_pins p
+WHERE NOT EXISTS (SELECT 1 FROM post_flag_audit a WHERE a.post_id = p.post_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS reputation_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    actor_id INTEGER NOT NULL,
+    source TEXT NOT NULL CHECK (source IN ('post','comment')),
+    source_id INTEGER NOT NULL,
+    points INTEGER NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reputation_events_reaction
+ON reputation_events(actor_id, source, source_id);
+
+CREATE INDEX IF NOT EXISTS idx_reputation_events_user
+ON reputation_events(user_id, created_at);
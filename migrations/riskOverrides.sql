@@ -0,0 +1,16 @@
+-- every manual change of a risk level, and every export of the events of a
+-- user, with who did it
+CREATE TABLE IF NOT EXISTS risk_overrides (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('pin','unpin','reset','export')),
+    level TEXT NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    actor_id INTEGER NOT NULL,
+    expires_at DATETIME,
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_risk_overrides_user
+ON risk_overrides(user_id, created_at);
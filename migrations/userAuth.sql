@@ -2,7 +2,7 @@ CREATE TABLE IF NOT EXISTS risk_assessments (
     user_id INTEGER NOT NULL,
     risk_level TEXT DEFAULT 'YELLOW' CHECK (risk_level IN ('GREEN','YELLOW','RED')),
     reason TEXT,
-    primary_geo TEXT DEFAULT 'Kazakhstan',
+    primary_geo TEXT DEFAULT '',
     primary_ip TEXT,
     primary_device TEXT,
     primary_online_time DATETIME DEFAULT (datetime('now','localtime')),
@@ -35,7 +35,7 @@ INSERT INTO risk_assessments (
 SELECT
     1,
     'GREEN',
-    'Kazakhstan',
+    '',
     'unknown',
     'unknown',
     datetime('now','localtime')
@@ -52,7 +52,7 @@ INSERT INTO risk_assessments (
 SELECT
     2,
     'GREEN',
-    'Kazakhstan',
+    '',
     'unknown',
     'unknown',
     datetime('now','localtime')
@@ -69,7 +69,7 @@ INSERT INTO risk_assessments (
 SELECT
     3,
     'GREEN',
-    'Kazakhstan',
+    '',
     'unknown',
     'unknown',
     datetime('now','localtime')
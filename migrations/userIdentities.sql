@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT DEFAULT '',
+    created_at DATETIME DEFAULT (datetime('now','localtime')),
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
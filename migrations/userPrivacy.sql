@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_privacy (
+    user_id INTEGER PRIMARY KEY,
+    public_profile INTEGER DEFAULT 1,
+    show_email INTEGER DEFAULT 0,
+    show_comments INTEGER DEFAULT 1,
+    show_liked INTEGER DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);